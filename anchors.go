@@ -0,0 +1,105 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The anchors are the ids of the headings and the fragments of the links
+// to them, e.g. [[#Etymology 2]] and [[book#Verb]]. An anchor is made of
+// the text of the heading without the markups, the same as MediaWiki's
+// Sanitizer: the spaces and underscores are folded into one '_', and the
+// legacy encoding encodes it further as URLs with '%' replaced by '.'. The
+// anchors of the headings of a document are unique, the duplicated ones are
+// suffixed by numbers, e.g. "Symbol", "Symbol_2" and "Symbol_3".
+
+// AnchorEncoding is an encoding of the anchors.
+type AnchorEncoding int
+
+const (
+	AnchorHTML5 AnchorEncoding = iota // e.g. "Café_2" of "Café 2"
+	AnchorLegacy // e.g. "Caf.C3.A9_2" of "Café 2"
+)
+
+var sectionSpaceRegexp = regexp.MustCompile(`[ _]+`)
+
+// Anchor returns the anchor of the text in the encoding, e.g. "Etymology_2"
+// of "Etymology 2".
+func Anchor(text string, enc AnchorEncoding) string {
+	text = strings.Trim(sectionSpaceRegexp.ReplaceAllString(text, " "), " \t\r\n\x00\x0b")
+	id := strings.Replace(text, " ", "_", -1)
+	if enc != AnchorLegacy {
+		return id
+	}
+	var b strings.Builder
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == ':':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, ".%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// HeadingText returns the text of the heading e without the markups, e.g.
+// "a b" of "== ''a'' [[c|b]] ==".
+func HeadingText(e *Entity) string {
+	a, b := headingRange(e)
+	return strings.TrimSpace(rangeText(e, a, b))
+}
+
+// asciiLower returns s with the ASCII letters in lower case, the same as
+// strtolower of PHP.
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c - 'A' + 'a'
+		}
+	}
+	return string(b)
+}
+
+// HeadingAnchors returns the anchors of the headings of the sections of the
+// document of wiki, the duplicated ones (case-insensitively) are suffixed.
+func HeadingAnchors(wiki *Entity, enc AnchorEncoding) map[*Entity]string {
+	anchors := make(map[*Entity]string)
+	refers := make(map[string]bool)
+	for _, h := range sectionHeadings(wiki) {
+		anchor := Anchor(HeadingText(h), enc)
+		key := asciiLower(anchor)
+		if refers[key] {
+			i := 2
+			for refers[fmt.Sprintf("%s_%d", key, i)] {
+				i++
+			}
+			anchor += fmt.Sprintf("_%d", i)
+			key += fmt.Sprintf("_%d", i)
+		}
+		refers[key] = true
+		anchors[h] = anchor
+	}
+	return anchors
+}
+
+var percentRegexp = regexp.MustCompile(`%([0-9a-fA-F]{2})`)
+
+// fragmentURL returns the fragment of a link for URLs, e.g. "#c_d" of
+// "c d", or "" if it's empty.
+func fragmentURL(fragment string, enc AnchorEncoding) string {
+	id := Anchor(decodeCharRefs([]byte(fragment)), enc)
+	if id == "" {
+		return ""
+	}
+	if enc == AnchorHTML5 {
+		id = percentRegexp.ReplaceAllString(id, "%25$1") // not to be decoded
+	}
+	return "#" + id
+}
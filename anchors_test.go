@@ -0,0 +1,73 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnchor(t *testing.T) {
+	tests := []struct{
+		text, html5, legacy string
+	}{
+		{ "Etymology 2", "Etymology_2", "Etymology_2" },
+		{ "  a __ b_ ", "a_b", "a_b" },
+		{ "Café 2", "Café_2", "Caf.C3.A9_2" },
+		{ "b & c: d~", "b_&_c:_d~", "b_.26_c:_d.7E" },
+		{ "", "", "" },
+	}
+	for i, test := range tests {
+		if s := Anchor(test.text, AnchorHTML5); s != test.html5 {
+			t.Errorf("TestAnchor: [%d] expect %q, got %q", i, test.html5, s)
+		}
+		if s := Anchor(test.text, AnchorLegacy); s != test.legacy {
+			t.Errorf("TestAnchor: [%d] expect %q, got %q", i, test.legacy, s)
+		}
+	}
+}
+
+func TestHeadingAnchors(t *testing.T) {
+	wiki, _ := ParseString("== ''a'' &amp; [[b|c]] ==\n=== Symbol ===\n== X ==\n=== Symbol ===\n" +
+		"=== symbol ===\n== Symbol_2 ==\n<pre>\n== Symbol ==\n</pre>")
+	var anchors []string
+	a := HeadingAnchors(wiki, AnchorLegacy)
+	for _, s := range Sections(wiki)[1:] {
+		anchors = append(anchors, a[s.Heading])
+	}
+	if s := strings.Join(anchors, " "); s != "a_.26_c Symbol X Symbol_2 symbol_3 Symbol_2_2" {
+		t.Errorf("TestHeadingAnchors: got %q", s)
+	}
+
+	data, err := readTestData("testdata/a.wiki.gz")
+	if err != nil {
+		t.Fatalf("TestHeadingAnchors: %v", err)
+	}
+	wiki, _ = Parse(data)
+	a = HeadingAnchors(wiki, AnchorHTML5)
+	n := 0
+	for _, s := range Sections(wiki)[1:] {
+		if s.Title == "Symbol" {
+			if n++; n == 3 && a[s.Heading] != "Symbol_3" {
+				t.Errorf("TestHeadingAnchors: the third Symbol is %q", a[s.Heading])
+			}
+		}
+	}
+
+	wiki, _ = ParseString("[[#Café 2]] [[book#Verb]]\n== Café 2 ==\n== Café 2 ==")
+	for enc, expect := range map[AnchorEncoding]string{
+		AnchorHTML5: `<a href="#Café_2">#Café 2</a> <a href="/wiki/Book#Verb" title="Book">book#Verb</a>` +
+			`<h2><span class="mw-headline" id="Café_2">Café 2</span></h2>` + "\n" +
+			`<h2><span class="mw-headline" id="Café_2_2">Café 2</span></h2>` + "\n",
+		AnchorLegacy: `<a href="#Caf.C3.A9_2">#Café 2</a> <a href="/wiki/Book#Verb" title="Book">book#Verb</a>` +
+			`<h2><span class="mw-headline" id="Caf.C3.A9_2">Café 2</span></h2>` + "\n" +
+			`<h2><span class="mw-headline" id="Caf.C3.A9_2_2">Café 2</span></h2>` + "\n",
+	} {
+		var b strings.Builder
+		RenderHTML(&b, wiki, &HTMLOptions{ Anchors: enc })
+		if b.String() != expect {
+			t.Errorf("TestHeadingAnchors: [%d] expect %q, got %q", enc, expect, b.String())
+		}
+	}
+}
@@ -0,0 +1,147 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The character references are decoded after scanning, in the texts except
+// the tags and the URLs, including the texts of <nowiki> and <pre>:
+//
+//	&amp; &nbsp; &#x3B1; &mdash;	WikiEntityCharRef
+//
+// The Text of a reference is the decoded character, the Raw is as written.
+// The named references are of HTML5, the semicolon is required. A numeric
+// reference must be a valid character of XML, the same as MediaWiki's
+// Sanitizer. The invalid references are left as text, e.g. &bogus;, see
+// Diagnostics.
+
+var charRefRegexp = regexp.MustCompile(`&(?:[a-zA-Z][a-zA-Z0-9]*|#[0-9]+|#[xX][0-9a-fA-F]+);`)
+
+// validCodepoint tells if the character c is allowed in XML.
+func validCodepoint(c int64) bool {
+	return c == 0x09 || c == 0x0a || c == 0x0d ||
+		0x20 <= c && c <= 0xd7ff ||
+		0xe000 <= c && c <= 0xfffd ||
+		0x10000 <= c && c <= 0x10ffff
+}
+
+// codepoint returns the character of the numeric reference ref, e.g. 0x3B1 of
+// "&#x3B1;", or -1 if it's not valid.
+func codepoint(ref []byte) int64 {
+	s, base := string(ref[2:len(ref)-1]), 10
+	if s[0] == 'x' || s[0] == 'X' {
+		s, base = s[1:], 16
+	}
+	c, err := strconv.ParseInt(s, base, 32)
+	if err != nil || !validCodepoint(c) {
+		return -1
+	}
+	return c
+}
+
+// decodeCharRef returns the text of the reference ref, e.g. "—" of
+// "&mdash;", ok is false if it's not valid.
+func decodeCharRef(ref []byte) (s string, ok bool) {
+	if ref[1] == '#' {
+		if codepoint(ref) < 0 {
+			return "", false
+		}
+		return html.UnescapeString(string(ref)), true
+	}
+	// The prefix of a longer name may be decoded, e.g. "¬it;" of "&notit;".
+	if s = html.UnescapeString(string(ref)); s == string(ref) || strings.HasSuffix(s, ";") {
+		return "", false
+	}
+	return s, true
+}
+
+// decodeCharRefs returns the text s with the valid references decoded.
+func decodeCharRefs(s []byte) string {
+	return string(charRefRegexp.ReplaceAllFunc(s, func(ref []byte) []byte {
+		if t, ok := decodeCharRef(ref); ok {
+			return []byte(t)
+		}
+		return ref
+	}))
+}
+
+// normalCharRef returns the reference ref normalized the same way as
+// MediaWiki's Sanitizer, e.g. "&#160;" of "&nbsp;", "&#x3b1;" of "&#x3B1;",
+// and "&amp;bogus;" of the invalid "&bogus;".
+func normalCharRef(ref []byte) string {
+	s, ok := decodeCharRef(ref)
+	switch {
+	case !ok:
+		return "&amp;" + string(ref[1:])
+	case ref[1] == '#' && (ref[2] == 'x' || ref[2] == 'X'):
+		return fmt.Sprintf("&#x%x;", codepoint(ref))
+	case ref[1] == '#':
+		return fmt.Sprintf("&#%d;", codepoint(ref))
+	}
+	switch name := string(ref[1:len(ref)-1]); name {
+	case "lt", "gt", "amp", "quot":
+		return "&" + name + ";"
+	}
+	var b strings.Builder
+	for _, c := range s {
+		fmt.Fprintf(&b, "&#%d;", c)
+	}
+	return b.String()
+}
+
+// A Diagnostic is a problem of the wikitext found by Diagnostics, the
+// wikitext is parsed anyway.
+type Diagnostic struct {
+	Pos int // offset in the document
+	Raw []byte // the wikitext of the problem, e.g. "&bogus;"
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d: %s: %q", d.Pos, d.Message, string(d.Raw))
+}
+
+// Diagnostics returns the problems of the wikitext of the tree of wiki in
+// order, which are the invalid character references currently.
+func Diagnostics(wiki *Entity) (res []Diagnostic) {
+	var walk func(e *Entity, pos int)
+	walk = func(e *Entity, pos int) {
+		switch e.Type {
+		case WikiEntityTag, WikiEntityTagBeg, WikiEntityTagEnd,
+			WikiEntityLinkURL, WikiEntityLinkExternalURL, WikiEntityCharRef:
+			return
+		}
+		segments(e, 0, len(e.Raw), func(text []byte, child *Entity) {
+			if child != nil {
+				walk(child, pos + rawOffset(e, child))
+				return
+			}
+			off := pos + cap(e.Raw) - cap(text)
+			for _, m := range charRefRegexp.FindAllIndex(text, -1) {
+				ref := text[m[0]:m[1]]
+				if _, ok := decodeCharRef(ref); ok {
+					continue
+				}
+				msg := "unknown character reference"
+				if ref[1] == '#' {
+					msg = "invalid character code"
+				}
+				res = append(res, Diagnostic{ off + m[0], ref, msg })
+			}
+		})
+		for _, child := range e.Entities {
+			if rawOffset(e, child) < 0 {
+				walk(child, child.Pos) // top-level, e.g. of sections
+			}
+		}
+	}
+	walk(wiki, 0)
+	return
+}
@@ -0,0 +1,124 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCharRefs(t *testing.T) {
+	tests := []struct{
+		src string
+		entities []*entityTestResult
+	}{
+		/***** 0 *****/
+		{`a &amp; b &bogus; <nowiki>&lt;</nowiki>`,
+			[]*entityTestResult{
+				{WikiEntityText, `a `, []*entityTestResult{}},
+				{WikiEntityCharRef, `&`, []*entityTestResult{}},
+				{WikiEntityText, ` b &bogus; `, []*entityTestResult{}},
+				{WikiEntityTagBeg, `nowiki`, nil},
+				{WikiEntityCharRef, `<`, []*entityTestResult{}},
+				{WikiEntityTagEnd, `nowiki`, nil},
+			}},
+		/***** 1 *****/
+		{`[[a&amp;b|&alpha;]] [http://a.b/?c&amp;d &mdash;]`,
+			[]*entityTestResult{
+				{WikiEntityLinkInternal, `a&amp;b|&alpha;`, []*entityTestResult{
+					{WikiEntityLinkInternalName, `a&amp;b`, []*entityTestResult{}},
+					{WikiEntityLinkInternalProp, `&alpha;`, []*entityTestResult{
+						{WikiEntityCharRef, `α`, []*entityTestResult{}},
+					}},
+				}},
+				{WikiEntityText, ` `, []*entityTestResult{}},
+				{WikiEntityLinkExternal, `http://a.b/?c&amp;d &mdash;`, []*entityTestResult{
+					{WikiEntityLinkExternalURL, `http://a.b/?c&amp;d`, []*entityTestResult{}},
+					{WikiEntityLinkExternalLabel, `&mdash;`, []*entityTestResult{
+						{WikiEntityCharRef, `—`, []*entityTestResult{}},
+					}},
+				}},
+			}},
+		/***** 2 *****/
+		{"* &#x3B1;&#0; http://a.b/&amp; {{t|&amp;}}",
+			[]*entityTestResult{
+				{WikiEntityListBulleted, " &#x3B1;&#0; http://a.b/&amp; {{t|&amp;}}", []*entityTestResult{
+					{WikiEntityCharRef, `α`, []*entityTestResult{}},
+					{WikiEntityLinkURL, `http://a.b/&amp;`, []*entityTestResult{}},
+					{WikiEntityTemplate, `t|&amp;`, nil},
+				}},
+			}},
+	}
+	for i, tc := range tests {
+		data := []byte(tc.src)
+		wiki, err := Parse(data)
+		if err != nil {
+			t.Errorf("TestCharRefs: [%d] %v", i, err)
+			continue
+		}
+		checkEntityResults(t, i, "TestCharRefs", data, tc.src, wiki, tc.entities, true)
+		checkEntityRanges(t, data, wiki)
+
+		opts := &ParseOptions{ ChunkSize: 1, CopyRaw: true }
+		res, err := ParseReader(iotest.OneByteReader(strings.NewReader(tc.src)), opts)
+		if err != nil {
+			t.Errorf("TestCharRefs: [%d] ParseReader: %v", i, err)
+			continue
+		}
+		sameEntity(t, fmt.Sprintf("TestCharRefs: [%d]", i), wiki, res)
+	}
+}
+
+func TestDecodeCharRef(t *testing.T) {
+	tests := []struct{
+		ref, text, normal string
+	}{
+		{ `&amp;`, `&`, `&amp;` },
+		{ `&nbsp;`, " ", `&#160;` },
+		{ `&mdash;`, `—`, `&#8212;` },
+		{ `&#x3B1;`, `α`, `&#x3b1;` },
+		{ `&#945;`, `α`, `&#945;` },
+		{ `&#0945;`, `α`, `&#945;` },
+		{ `&bogus;`, ``, `&amp;bogus;` },
+		{ `&notit;`, ``, `&amp;notit;` },
+		{ `&#0;`, ``, `&amp;#0;` },
+		{ `&#xD800;`, ``, `&amp;#xD800;` },
+		{ `&#99999999999;`, ``, `&amp;#99999999999;` },
+	}
+	for i, test := range tests {
+		s, ok := decodeCharRef([]byte(test.ref))
+		if s != test.text || ok != (test.text != "") {
+			t.Errorf("TestDecodeCharRef: [%d] %s: expect %q, got %q (%v)", i, test.ref, test.text, s, ok)
+		}
+		if s := normalCharRef([]byte(test.ref)); s != test.normal {
+			t.Errorf("TestDecodeCharRef: [%d] %s: expect %q, got %q", i, test.ref, test.normal, s)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	src := "a &bogus; [[b|&#0;]] http://c.d/&e;\n== &x; ==\n* [http://f.g &#xFFFFFF;] &amp;"
+	wiki, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("TestDiagnostics: %v", err)
+	}
+	var res []string
+	for _, d := range Diagnostics(wiki) {
+		if src[d.Pos:d.Pos+len(d.Raw)] != string(d.Raw) {
+			t.Errorf("TestDiagnostics: %v: wrong position", d)
+		}
+		res = append(res, d.String())
+	}
+	expect := []string{
+		`2: unknown character reference: "&bogus;"`,
+		`14: invalid character code: "&#0;"`,
+		`39: unknown character reference: "&x;"`,
+		`60: invalid character code: "&#xFFFFFF;"`,
+	}
+	if s, e := strings.Join(res, "\n"), strings.Join(expect, "\n"); s != e {
+		t.Errorf("TestDiagnostics: expect\n%s\ngot\n%s", e, s)
+	}
+}
@@ -0,0 +1,570 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The tree is edited by the methods of the root of it, e.g.
+//
+//	link, err := NewLink("Foo", "bar")
+//	if err == nil {
+//		err = wiki.Replace(old, link)
+//	}
+//
+// The wikitext of the whole tree is written into a new buffer after each
+// edit, the Raw, Pos and Text of all entities are updated the same way as
+// the parser makes them: the Raw of a child is a slice of the Raw of the
+// parent, the Pos of it is relative to the parent, except the entities of
+// the top-level and of the sections, of which the Pos is the offset in the
+// document. The newline before an entity of a line, e.g. a heading or a
+// list, is added if needed. The entities not in the tree are not changed.
+
+// piece is a piece of the wikitext of an entity, a text or a child.
+type piece struct {
+	text []byte
+	child *Entity
+}
+
+// editor writes the wikitext of a tree into a new buffer.
+type editor struct {
+	root *Entity
+	buf bytes.Buffer
+	pieces map[*Entity][]piece // the pieces of the edited entities
+	ends map[*Entity]int // index of the pieces after the Text
+	detached map[*Entity]bool // the entities of the top-level and the sections
+	gaps map[*Entity][]byte // the wikitext before the detached entities
+	marks map[*Entity][2]int // the sizes of the markups around the Text
+	spans map[*Entity][2]int // the ranges in the buffer
+	anchors map[*Entity]anchor // the children overlapped by the others
+	offsets map[*Entity]int // the offsets in the Raw of the parents
+	touched map[*Entity]bool // the detached entities new or after an edit
+	line bool // the last detached entity written is of a line
+	fresh bool // the last detached entity written is new
+}
+
+func newEditor(root *Entity) *editor {
+	ed := &editor{
+		root: root,
+		pieces: make(map[*Entity][]piece),
+		ends: make(map[*Entity]int),
+		detached: make(map[*Entity]bool),
+		gaps: make(map[*Entity][]byte),
+		marks: make(map[*Entity][2]int),
+		spans: make(map[*Entity][2]int),
+		anchors: make(map[*Entity]anchor),
+		offsets: make(map[*Entity]int),
+		touched: make(map[*Entity]bool),
+	}
+	ed.init(root)
+	return ed
+}
+
+// hasRawText tells if the Text of the entity type is the wikitext within
+// the markups, it's not for the decoded references and the magic words.
+func hasRawText(t EntityType) bool {
+	switch t {
+	case WikiEntityWiki, WikiEntityCharRef, WikiEntityMagicWord,
+		WikiEntityBehaviorSwitch, WikiEntityRedirect:
+		return false
+	}
+	return true
+}
+
+// isLine tells if the entity e is of a whole line.
+func isLine(e *Entity) bool {
+	return isHeading(e) || isList(e) || e.Type == WikiEntityHR
+}
+
+// init records the markups and the gaps of the entity e and the children
+// of it, it returns the end of e and the sections in it, and the Raw which
+// ends there.
+func (ed *editor) init(e *Entity) (end int, last []byte) {
+	if a, b := innerRange(e); hasRawText(e.Type) && e.Text == string(e.Raw[a:b]) {
+		ed.marks[e] = [2]int{ a, len(e.Raw) - b }
+	}
+	ed.overlaps(e)
+	end, last = e.Pos + len(e.Raw), e.Raw
+	for _, child := range e.Entities {
+		if off := rawOffset(e, child); 0 <= off {
+			ed.offsets[child] = off
+			ed.init(child)
+			continue
+		}
+		ed.detached[child] = true
+		if n := child.Pos - end; n <= 0 {
+			ed.gaps[child] = nil
+		} else if n <= cap(last) - len(last) {
+			ed.gaps[child] = last[len(last):len(last)+n] // stripped by the parser
+		} else {
+			ed.gaps[child] = bytes.Repeat([]byte("\n"), n)
+		}
+		end, last = ed.init(child)
+	}
+	return
+}
+
+// anchor is the entity in which an overlapped entity is, and the offset of
+// it in the Raw of the entity.
+type anchor struct {
+	e *Entity
+	off int
+}
+
+// overlaps records the anchors of the children of e which are not in the
+// segments of e, as they're overlapped by the others, e.g. of broken
+// wikitext.
+func (ed *editor) overlaps(e *Entity) {
+	var written []*Entity
+	segments(e, 0, len(e.Raw), func(text []byte, child *Entity) {
+		if child != nil {
+			written = append(written, child)
+		}
+	})
+	if len(written) == len(e.Entities) {
+		return
+	}
+	for _, child := range e.Entities {
+		off := rawOffset(e, child)
+		if off < 0 {
+			continue
+		}
+		a := anchor{ e, off }
+		for _, w := range written {
+			if w == child {
+				a.e = nil
+				break
+			}
+			if o := rawOffset(e, w); o <= off && off + len(child.Raw) <= o + len(w.Raw) {
+				a = anchor{ w, off - o }
+			}
+		}
+		for found := a.e != nil; found; {
+			found = false // the innermost one
+			for _, w := range a.e.Entities {
+				if o := rawOffset(a.e, w); 0 <= o && o <= a.off && a.off + len(child.Raw) <= o + len(w.Raw) {
+					a, found = anchor{ w, a.off - o }, true
+					break
+				}
+			}
+		}
+		if a.e != nil {
+			ed.anchors[child] = a
+		}
+	}
+}
+
+// inline returns the pieces of the wikitext of e without the detached
+// children, end is the index of the pieces after the Text of e.
+func (ed *editor) inline(e *Entity) (pieces []piece, end int) {
+	if p, ok := ed.pieces[e]; ok {
+		return p, ed.ends[e]
+	}
+	_, b := innerRange(e)
+	end = -1
+	segments(e, 0, len(e.Raw), func(text []byte, child *Entity) {
+		if child != nil {
+			if off := rawOffset(e, child); end < 0 && b <= off {
+				end = len(pieces)
+			}
+			pieces = append(pieces, piece{ nil, child })
+			return
+		}
+		off := cap(e.Raw) - cap(text)
+		switch {
+		case end < 0 && b <= off:
+			end = len(pieces)
+		case end < 0 && b < off + len(text):
+			pieces = append(pieces, piece{ text[0:b-off], nil })
+			end, text = len(pieces), text[b-off:]
+		}
+		pieces = append(pieces, piece{ text, nil })
+	})
+	if end < 0 {
+		end = len(pieces)
+	}
+	return
+}
+
+// atLineStart tells if the buffer is empty or ends with a newline.
+func (ed *editor) atLineStart() bool {
+	b := ed.buf.Bytes()
+	return len(b) == 0 || b[len(b)-1] == '\n'
+}
+
+func (ed *editor) write(e *Entity) {
+	start := ed.buf.Len()
+	pieces, _ := ed.inline(e)
+	for _, p := range pieces {
+		if p.child != nil {
+			ed.write(p.child)
+		} else {
+			ed.buf.Write(p.text)
+		}
+	}
+	ed.spans[e] = [2]int{ start, ed.buf.Len() }
+	if isLine(e) {
+		ed.line = true
+	}
+	for _, child := range e.Entities {
+		if !ed.detached[child] {
+			continue
+		}
+		gap, touched := ed.gaps[child], ed.touched[child] || ed.fresh
+		startsLine := 0 < len(child.Raw) && child.Raw[0] == '\n'
+		if len(gap) == 0 && touched && !ed.atLineStart() && (isLine(child) || ed.line && !startsLine) {
+			gap = []byte("\n") // an entity of a line is after a newline
+		}
+		ed.buf.Write(gap)
+		ed.line, ed.fresh = false, false
+		ed.write(child)
+		if _, known := ed.gaps[child]; !known {
+			ed.fresh = true // a new entity
+		}
+	}
+}
+
+// assign sets the Raw, Pos and Text of e and the children of it of the
+// ranges in data.
+func (ed *editor) assign(e *Entity, data []byte) {
+	s := ed.spans[e]
+	e.Raw = data[s[0]:s[1]]
+	if m, ok := ed.marks[e]; ok && m[0] <= len(e.Raw) - m[1] {
+		e.Text = string(e.Raw[m[0]:len(e.Raw)-m[1]])
+	}
+	var entities []*Entity
+	for _, child := range e.Entities {
+		cs, ok := ed.spans[child]
+		if a, overlapped := ed.anchors[child]; overlapped {
+			as, written := ed.spans[a.e]
+			if !written {
+				continue // the anchor is removed
+			}
+			cs, ok = clampSpan(as, a.off, len(child.Raw)), true
+		} else if off, inline := ed.offsets[child]; !ok && inline {
+			cs, ok = clampSpan(s, off, len(child.Raw)), true // in an overlapped one
+		}
+		entities = append(entities, child)
+		child.parent = e
+		if !ok {
+			continue
+		}
+		ed.spans[child] = cs
+		if ed.detached[child] {
+			child.Pos = cs[0]
+		} else {
+			child.Pos = cs[0] - s[0]
+		}
+		ed.assign(child, data)
+	}
+	e.Entities = entities
+}
+
+// clampSpan returns the range of the size n at the offset off in the range
+// s, which is clamped into s.
+func clampSpan(s [2]int, off, n int) [2]int {
+	a, b := s[0] + off, s[0] + off + n
+	if s[1] < b { b = s[1] }
+	if b < a { a = b }
+	return [2]int{ a, b }
+}
+
+// hasSections tells if the entity e has children not in the Raw of it, e.g.
+// the entities of the top-level.
+func hasSections(e *Entity) bool {
+	for _, child := range e.Entities {
+		if rawOffset(e, child) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the parent of the entity x in the tree of e and the index
+// of x in the children of the parent.
+func find(e, x *Entity) (parent *Entity, index int) {
+	for i, child := range e.Entities {
+		if child == x {
+			return e, i
+		}
+		if parent, index = find(child, x); parent != nil {
+			return
+		}
+	}
+	return nil, -1
+}
+
+// contains tells if x is e or in the tree of e.
+func contains(e, x *Entity) bool {
+	p, _ := find(e, x)
+	return e == x || p != nil
+}
+
+// splice replaces the n children of parent from the index i with the
+// children, n is either 0 or 1.
+func (ed *editor) splice(parent *Entity, i, n int, children []*Entity) error {
+	for p := parent; p != nil; p, _ = find(ed.root, p) {
+		if _, overlapped := ed.anchors[p]; overlapped {
+			return fmt.Errorf("wiki: %v is overlapped by the others", p)
+		}
+	}
+	var old *Entity
+	if 0 < n {
+		old = parent.Entities[i]
+	}
+	detached := false
+	switch {
+	case old != nil:
+		detached = ed.detached[old]
+	case i < len(parent.Entities):
+		detached = ed.detached[parent.Entities[i]]
+	case len(parent.Raw) == 0:
+		detached = parent == ed.root || ed.detached[parent] // e.g. the top-level
+	default:
+		detached = 0 < len(parent.Entities) && ed.detached[parent.Entities[len(parent.Entities)-1]]
+	}
+
+	if detached {
+		for _, child := range children {
+			ed.detached[child], ed.touched[child] = true, true
+		}
+		if old != nil && 0 < len(children) {
+			ed.gaps[children[0]] = ed.gaps[old]
+		}
+		if k := i + n; k < len(parent.Entities) {
+			ed.touched[parent.Entities[k]] = true
+		}
+	} else {
+		pieces, end := ed.inline(parent)
+		at, found := end, false // at the end of the Text, after the children before
+		for k, p := range pieces {
+			switch {
+			case p.child == nil:
+			case i < len(parent.Entities) && p.child == parent.Entities[i]:
+				at, found = k, true
+			case 0 < i && p.child == parent.Entities[i-1] && at <= k:
+				at = k + 1
+			}
+		}
+		if 0 < n && !found {
+			return fmt.Errorf("wiki: %v is overlapped by the others", old)
+		}
+		var res []piece
+		res = append(res, pieces[0:at]...)
+		for _, child := range children {
+			res = append(res, piece{ nil, child })
+		}
+		if res = append(res, pieces[at+n:]...); at <= end {
+			end += len(children) - n
+		}
+		ed.pieces[parent], ed.ends[parent] = res, end
+		for _, child := range children {
+			delete(ed.detached, child)
+		}
+	}
+
+	if old != nil {
+		old.parent = nil
+	}
+	var entities []*Entity
+	entities = append(entities, parent.Entities[0:i]...)
+	entities = append(entities, children...)
+	parent.Entities = append(entities, parent.Entities[i+n:]...)
+	return nil
+}
+
+// layout writes the wikitext of the tree of e into a new buffer and updates
+// the entities.
+func (ed *editor) layout(e *Entity) {
+	raw := e.Raw
+	ed.write(e)
+	ed.assign(e, ed.buf.Bytes())
+	if raw == nil && len(e.Raw) == 0 {
+		e.Raw = nil // e.g. the top-level
+	}
+}
+
+// checkNew returns an error if any of the children is nil or in the tree
+// of e.
+func (e *Entity) checkNew(children []*Entity) error {
+	for _, child := range children {
+		if child == nil {
+			return fmt.Errorf("wiki: nil entity")
+		}
+		if contains(e, child) {
+			return fmt.Errorf("wiki: %v is already in the tree", child)
+		}
+	}
+	return nil
+}
+
+// Insert inserts the children into the children of parent at the index i,
+// parent is e or in the tree of e. The children are inserted into the
+// section of a heading if it has one, e.g. for i = len(parent.Entities).
+func (e *Entity) Insert(parent *Entity, i int, children ...*Entity) error {
+	if !contains(e, parent) {
+		return fmt.Errorf("wiki: %v is not in the tree", parent)
+	}
+	if i < 0 || len(parent.Entities) < i {
+		return fmt.Errorf("wiki: index %d out of range of %v", i, parent)
+	}
+	if err := e.checkNew(children); err != nil {
+		return err
+	}
+	ed := newEditor(e)
+	if err := ed.splice(parent, i, 0, children); err != nil {
+		return err
+	}
+	ed.layout(e)
+	return nil
+}
+
+// Replace replaces the entity old in the tree of e with the children, old
+// is removed if there're no children.
+func (e *Entity) Replace(old *Entity, children ...*Entity) error {
+	parent, i := find(e, old)
+	if parent == nil {
+		return fmt.Errorf("wiki: %v is not in the tree", old)
+	}
+	if err := e.checkNew(children); err != nil {
+		return err
+	}
+	ed := newEditor(e)
+	if err := ed.splice(parent, i, 1, children); err != nil {
+		return err
+	}
+	ed.layout(e)
+	return nil
+}
+
+// Remove removes the entity child from the tree of e, the sections of a
+// removed heading are removed with it.
+func (e *Entity) Remove(child *Entity) error {
+	return e.Replace(child)
+}
+
+// quoteMarkups are the markups of the types of Wrap.
+var quoteMarkups = map[EntityType]string{
+	WikiEntityTextBold: "'''",
+	WikiEntityTextItalic: "''",
+	WikiEntityTextBoldItalic: "'''''",
+}
+
+// Wrap replaces the entity child in the tree of e with a new entity of the
+// type t of it, which is either WikiEntityTextBold, WikiEntityTextItalic or
+// WikiEntityTextBoldItalic, e.g. '''[[Foo]]''' of [[Foo]]. The entities of
+// lines can't be wrapped, e.g. the headings.
+func (e *Entity) Wrap(child *Entity, t EntityType) (*Entity, error) {
+	markup, ok := quoteMarkups[t]
+	if !ok {
+		return nil, fmt.Errorf("wiki: can't wrap with %v", t)
+	}
+	parent, i := find(e, child)
+	switch {
+	case parent == nil:
+		return nil, fmt.Errorf("wiki: %v is not in the tree", child)
+	case isLine(child) || hasSections(child):
+		return nil, fmt.Errorf("wiki: can't wrap %v", child.Type)
+	}
+	w := &Entity{ Type: t, Entities: []*Entity{ child } }
+	ed := newEditor(e)
+	if err := ed.splice(parent, i, 1, []*Entity{ w }); err != nil {
+		return nil, err
+	}
+	ed.pieces[w] = []piece{ { []byte(markup), nil }, { nil, child }, { []byte(markup), nil } }
+	ed.ends[w] = 2
+	ed.marks[w] = [2]int{ len(markup), len(markup) }
+	delete(ed.detached, child)
+	ed.layout(e)
+	return w, nil
+}
+
+// Wikitext returns the wikitext of the entity e, including the sections of
+// it if it's a heading or the top-level.
+func Wikitext(e *Entity) []byte {
+	ed := newEditor(e)
+	ed.write(e)
+	return ed.buf.Bytes()
+}
+
+// newEntity returns the entity of the wikitext s, or an error if it's not
+// of the type t.
+func newEntity(s string, t EntityType) (*Entity, error) {
+	if wiki, err := ParseString(s); err == nil && len(wiki.Entities) == 1 {
+		if e := wiki.Entities[0]; e.Type == t && len(e.Raw) == len(s) {
+			e.parent = nil
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("wiki: %q is not a %v", s, t)
+}
+
+// NewText returns a text entity of s, which is written as is.
+func NewText(s string) *Entity {
+	return &Entity{ Type: WikiEntityText, Raw: []byte(s), Text: s }
+}
+
+// NewLink returns an internal link to the target with the label, e.g.
+// [[Foo|bar]], or [[Foo]] if the label is empty. It returns an error if the
+// target and the label are not of a link, e.g. "a]]b" and "a|b".
+func NewLink(target, label string) (*Entity, error) {
+	s := target
+	if label != "" {
+		s += "|" + label
+	}
+	e, err := newEntity("[[" + s + "]]", WikiEntityLinkInternal)
+	if err != nil {
+		return nil, err
+	}
+	props := 0
+	for _, child := range e.Entities {
+		if child.Type == WikiEntityLinkInternalProp {
+			props++
+		}
+	}
+	if label != "" && props != 1 || label == "" && props != 0 || strings.TrimSpace(target) == "" {
+		return nil, fmt.Errorf("wiki: %q is not a link to %q", string(e.Raw), target)
+	}
+	return e, nil
+}
+
+// NewExternalLink returns an external link to the URL with the label, e.g.
+// [http://example.com Example], or [http://example.com] if the label is
+// empty. It returns an error if the URL and the label are not of a link,
+// e.g. "a b" and "a]b".
+func NewExternalLink(url, label string) (*Entity, error) {
+	s := url
+	if label != "" {
+		s += " " + label
+	}
+	e, err := newEntity("[" + s + "]", WikiEntityLinkExternal)
+	if err != nil {
+		return nil, err
+	}
+	if u, l := externalLinkParts(e); u == nil || string(u.Raw) != url || (l == nil) != (label == "") {
+		return nil, fmt.Errorf("wiki: %q is not a link to %q", string(e.Raw), url)
+	}
+	return e, nil
+}
+
+// NewTemplate returns a template of the name with the arguments, e.g.
+// {{cite|title=Foo|2010}} of "cite", "title=Foo" and "2010". The arguments
+// are escaped, e.g. "a{{!}}b" of "a|b", "x=&#125;&#125;y" of "x=}}y", the
+// brackets of them are all escaped if the links and the templates can't be
+// kept. It returns an error if the name is not of a template, e.g. "a}}b"
+// and "a|b".
+func NewTemplate(name string, args ...string) (*Entity, error) {
+	s := "{{" + name
+	for _, arg := range args {
+		s += "|" + argValue(arg)
+	}
+	s += "}}"
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("wiki: %q has no name", s)
+	}
+	return parseTemplate(s, len(args))
+}
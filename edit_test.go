@@ -0,0 +1,173 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// checkEditedRanges checks the Raw and Pos of the entities of the edited
+// tree wiki against the wikitext data of it.
+func checkEditedRanges(t *testing.T, data []byte, wiki *Entity) {
+	var check func(e *Entity)
+	check = func(e *Entity) {
+		for _, child := range e.Entities {
+			if len(child.Raw) == 0 {
+				// the empty entities are anywhere
+			} else if off := rawOffset(e, child); 0 <= off {
+				if off != child.Pos {
+					t.Errorf("checkEditedRanges: %v at %d, expect %d", child, child.Pos, off)
+				}
+			} else if len(data) < child.Pos + len(child.Raw) || !bytes.Equal(data[child.Pos:child.Pos+len(child.Raw)], child.Raw) {
+				t.Errorf("checkEditedRanges: %v is not at %d", child, child.Pos)
+			}
+			check(child)
+		}
+	}
+	check(wiki)
+}
+
+func TestWikitext(t *testing.T) {
+	files, _ := filepath.Glob("testdata/*.wiki.gz")
+	for _, file := range files {
+		data, err := readTestData(file)
+		if err != nil {
+			t.Fatalf("TestWikitext: %v", err)
+		}
+		wiki, _ := Parse(data)
+		if s := Wikitext(wiki); !bytes.Equal(s, data) {
+			t.Errorf("TestWikitext: %s: %d bytes, expect %d", file, len(s), len(data))
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	first := func(wiki *Entity, selector string) *Entity {
+		if res, _ := Query(wiki, selector); 0 < len(res) {
+			return res[0]
+		}
+		return nil
+	}
+	must := func(e *Entity, err error) *Entity {
+		if err != nil {
+			t.Fatalf("TestEdit: %v", err)
+		}
+		return e
+	}
+	tests := []struct{
+		src string
+		edit func(wiki *Entity) error
+		res string
+	}{
+		{ "a [[b]] c", func(wiki *Entity) error {
+			return wiki.Replace(first(wiki, "linkinternal"), must(NewLink("Foo", "bar")))
+		}, "a [[Foo|bar]] c" },
+		{ "a [[b]] c", func(wiki *Entity) error {
+			return wiki.Remove(first(wiki, "linkinternal"))
+		}, "a  c" },
+		{ "a [[b]] c", func(wiki *Entity) error {
+			_, err := wiki.Wrap(first(wiki, "linkinternal"), WikiEntityTextBold)
+			return err
+		}, "a '''[[b]]''' c" },
+		{ "''a'' b", func(wiki *Entity) error {
+			italic := first(wiki, "textitalic")
+			return wiki.Insert(italic, len(italic.Entities), must(NewTemplate("x", "1=a|b", "c")))
+		}, "''a{{x|1=a{{!}}b|c}}'' b" },
+		{ "{{t|a|b}}", func(wiki *Entity) error {
+			return wiki.Replace(first(wiki, "templateprop"), NewText("|"), must(NewExternalLink("http://example.com", "c")))
+		}, "{{t|[http://example.com c]|b}}" },
+		{ "a\n== h ==\nb\n== j ==\nc", func(wiki *Entity) error {
+			return wiki.Remove(first(wiki, "heading2"))
+		}, "a\n== j ==\nc" },
+		{ "a\n== h ==\nb", func(wiki *Entity) error {
+			h := first(wiki, "heading2")
+			return wiki.Insert(h, len(h.Entities), must(NewTemplate("stub")))
+		}, "a\n== h ==\nb{{stub}}" },
+		{ "== h ==\nb", func(wiki *Entity) error {
+			return wiki.Insert(wiki, 0, NewText("a"))
+		}, "a\n== h ==\nb" },
+		{ "a\n\n== h ==\nb", func(wiki *Entity) error {
+			return wiki.Replace(first(wiki, "heading2"), must(NewTemplate("x")))
+		}, "a\n\n{{x}}" },
+		{ "* a\n* b", func(wiki *Entity) error {
+			return wiki.Insert(wiki, 1, must(NewLink("c", "")))
+		}, "* a\n[[c]]\n* b" },
+	}
+	for i, test := range tests {
+		wiki, err := ParseString(test.src)
+		if err != nil {
+			t.Errorf("TestEdit: [%d] %v", i, err)
+			continue
+		}
+		if err := test.edit(wiki); err != nil {
+			t.Errorf("TestEdit: [%d] %v", i, err)
+			continue
+		}
+		s := Wikitext(wiki)
+		if string(s) != test.res {
+			t.Errorf("TestEdit: [%d] expect %q, got %q", i, test.res, string(s))
+		}
+		checkEditedRanges(t, s, wiki)
+	}
+
+	wiki, _ := ParseString("a ''b'' [[c]]")
+	italic, link := first(wiki, "textitalic"), first(wiki, "linkinternal")
+	if err := wiki.Insert(italic, 0, link); err == nil {
+		t.Errorf("TestEdit: expect an error of inserting an entity in the tree")
+	}
+	if err := wiki.Remove(NewText("x")); err == nil {
+		t.Errorf("TestEdit: expect an error of removing an entity not in the tree")
+	}
+	if _, err := wiki.Wrap(link, WikiEntityTemplate); err == nil {
+		t.Errorf("TestEdit: expect an error of wrapping with a template")
+	}
+	if w, _ := wiki.Wrap(italic, WikiEntityTextBold); w == nil || w.Text != "''b''" || italic.Pos != 3 || italic.Text != "b" {
+		t.Errorf("TestEdit: wrapped %v, %v at %d", w, italic, italic.Pos)
+	}
+}
+
+func TestNewTemplate(t *testing.T) {
+	tests := []struct{
+		args []string
+		raw string
+	}{
+		{ []string{ "x=}}y" }, `{{t|x=&#125;&#125;y}}` },
+		{ []string{ "a|[[b|c]]", "{{d|e}}" }, `{{t|a{{!}}[[b|c]]|{{d|e}}}}` },
+		{ []string{ "{{a", "[[b", "c]]" }, `{{t|&#123;&#123;a|&#91;&#91;b|c&#93;&#93;}}` },
+		{ []string{ "}}{{", "a}" }, `{{t|&#125;&#125;&#123;&#123;|a&#125;}}` },
+		{ []string{ "[[a}}", "{{b]]}}" }, `{{t|&#91;&#91;a&#125;&#125;|{{b&#93;&#93;}}}}` },
+		{ []string{ "[[a]] <b|c" }, `{{t|&#91;&#91;a&#93;&#93; &#60;b&#124;c}}` },
+	}
+	for i, test := range tests {
+		e, err := NewTemplate("t", test.args...)
+		if err != nil || e.Type != WikiEntityTemplate || string(e.Raw) != test.raw {
+			t.Errorf("TestNewTemplate: [%d] expect %q, got %v %v", i, test.raw, e, err)
+			continue
+		}
+		if args, err := e.TemplateArgs(); err != nil || len(args.List) != len(test.args) {
+			t.Errorf("TestNewTemplate: [%d] %v, %v", i, args, err)
+		}
+	}
+
+	for _, name := range []string{ "a}}b", "a|b", "" } {
+		if e, err := NewTemplate(name); err == nil {
+			t.Errorf("TestNewTemplate: expect an error of the name %q, got %v", name, e)
+		}
+	}
+	for _, test := range [][2]string{ { "a]]b", "" }, { "a|b", "" }, { "a", "b|c" }, { "a", "b]]" }, { "", "" } } {
+		if e, err := NewLink(test[0], test[1]); err == nil {
+			t.Errorf("TestNewTemplate: expect an error of the link %q, got %v", test, e)
+		}
+	}
+	for _, test := range [][2]string{ { "http://a b", "" }, { "http://a", "b]c" }, { "a", "" }, { "http://a]", "" } } {
+		if e, err := NewExternalLink(test[0], test[1]); err == nil {
+			t.Errorf("TestNewTemplate: expect an error of the external link %q, got %v", test, e)
+		}
+	}
+	if e, err := NewExternalLink("http://a", "b c"); err != nil || string(e.Raw) != "[http://a b c]" {
+		t.Errorf("TestNewTemplate: external link %v, %v", e, err)
+	}
+}
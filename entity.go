@@ -0,0 +1,168 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import "bytes"
+
+// rawOffset returns the offset of child.Raw in e.Raw, or -1 if it's not a
+// slice of it.
+func rawOffset(e, child *Entity) int {
+	a, b := e.Raw, child.Raw
+	if cap(a) == 0 || cap(b) == 0 || &a[0:cap(a)][cap(a)-1] != &b[0:cap(b)][cap(b)-1] {
+		return -1
+	}
+	if off := cap(a) - cap(b); 0 <= off && off + len(b) <= len(a) {
+		return off
+	}
+	return -1
+}
+
+// markupSize returns the size of the left markup of the entity type, e.g. 3
+// for "'''" of WikiEntityTextBold.
+func markupSize(t EntityType) int {
+	switch t {
+	case WikiEntityTextBold:		return 3
+	case WikiEntityTextItalic:		return 2
+	case WikiEntityTextBoldItalic:		return 5
+	case WikiEntityHeading2:		return 2
+	case WikiEntityHeading3:		return 3
+	case WikiEntityHeading4:		return 4
+	case WikiEntityHeading5:		return 5
+	case WikiEntityLinkExternal:		return 1
+	case WikiEntityLinkInternal:		return 2
+	case WikiEntityLinkInternalProp:	return 1
+	case WikiEntityTemplate:		return 2
+	case WikiEntityTemplateProp:		return 1
+	case WikiEntityTag, WikiEntityTagBeg:	return 1
+	case WikiEntityTagEnd:			return 2
+	case WikiEntityListBulleted, WikiEntityListNumbered, WikiEntityIndent,
+		WikiEntityRedirect:
+		return 1
+	}
+	return 0
+}
+
+// innerRange returns the range of e.Text in e.Raw, the content without the
+// markups.
+func innerRange(e *Entity) (a, b int) {
+	raw, text := e.Raw, []byte(e.Text)
+	if n := markupSize(e.Type); n + len(text) <= len(raw) && bytes.Equal(raw[n:n+len(text)], text) {
+		return n, n + len(text)
+	}
+	if n := bytes.Index(raw, text); 0 <= n {
+		return n, n + len(text)
+	}
+	return 0, len(raw)
+}
+
+// segments calls fn for the pieces of e.Raw[a:b] in order, which is either a
+// text (child is nil) or a child entity within the range.
+func segments(e *Entity, a, b int, fn func(text []byte, child *Entity)) {
+	at := a
+	for _, child := range e.Entities {
+		off := rawOffset(e, child)
+		if off < at || b < off + len(child.Raw) {
+			continue
+		}
+		if at < off {
+			fn(e.Raw[at:off], nil)
+		}
+		fn(nil, child)
+		at = off + len(child.Raw)
+	}
+	if at < b {
+		fn(e.Raw[at:b], nil)
+	}
+}
+
+// The parents of the entities are set by the parser and the methods editing
+// the tree, see SetParents for the entities made otherwise.
+
+// SetParents sets the parents of the entities in the tree of e, of which e
+// is the root.
+func (e *Entity) SetParents() {
+	for _, child := range e.Entities {
+		child.parent = e
+		child.SetParents()
+	}
+}
+
+// Parent returns the parent of e, or nil if it's the root.
+func (e *Entity) Parent() *Entity {
+	return e.parent
+}
+
+// titleOptions returns the options of the titles of the site of the tree of
+// e, or nil if it's parsed without a site.
+func (e *Entity) titleOptions() *TitleOptions {
+	for ; e != nil; e = e.parent {
+		if e.titles != nil {
+			return e.titles
+		}
+	}
+	return nil
+}
+
+// extensionTags returns the extension tags of the parser of the tree of e,
+// or nil if there are none.
+func (e *Entity) extensionTags() ExtensionTags {
+	for ; e != nil; e = e.parent {
+		if e.tags != nil {
+			return e.tags
+		}
+	}
+	return nil
+}
+
+// index returns the index of e in the children of the parent, or -1.
+func (e *Entity) index() int {
+	if e.parent != nil {
+		for i, child := range e.parent.Entities {
+			if child == e {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// sibling returns the n-th sibling of e after it, or before it if n is
+// negative.
+func (e *Entity) sibling(n int) *Entity {
+	if i := e.index(); 0 <= i && 0 <= i + n && i + n < len(e.parent.Entities) {
+		return e.parent.Entities[i+n]
+	}
+	return nil
+}
+
+// NextSibling returns the entity after e in the children of the parent, or
+// nil if it's the last.
+func (e *Entity) NextSibling() *Entity {
+	return e.sibling(1)
+}
+
+// PrevSibling returns the entity before e in the children of the parent, or
+// nil if it's the first.
+func (e *Entity) PrevSibling() *Entity {
+	return e.sibling(-1)
+}
+
+// Ancestors returns the parent of e, the parent of the parent and so on to
+// the root.
+func (e *Entity) Ancestors() (res []*Entity) {
+	for p := e.parent; p != nil; p = p.parent {
+		res = append(res, p)
+	}
+	return
+}
+
+// Path returns the indexes of e and the ancestors of it in the children of
+// the parents, from the root, e.g. [2 0] of the first child of the third
+// entity of the root. It's empty for the root.
+func (e *Entity) Path() (path []int) {
+	for x := e; x.parent != nil; x = x.parent {
+		path = append([]int{ x.index() }, path...)
+	}
+	return
+}
@@ -0,0 +1,82 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// checkParents checks the parents of the entities in the tree of e.
+func checkParents(t *testing.T, e *Entity) {
+	for i, child := range e.Entities {
+		if child.Parent() != e {
+			t.Errorf("checkParents: %v of %v has parent %v", child, e, child.Parent())
+		}
+		if i == 0 && child.PrevSibling() != nil || 0 < i && child.PrevSibling() != e.Entities[i-1] {
+			t.Errorf("checkParents: wrong sibling before %v", child)
+		}
+		if i == len(e.Entities) - 1 && child.NextSibling() != nil || i < len(e.Entities) - 1 && child.NextSibling() != e.Entities[i+1] {
+			t.Errorf("checkParents: wrong sibling after %v", child)
+		}
+		checkParents(t, child)
+	}
+}
+
+func TestParents(t *testing.T) {
+	src := "a [[b|''c'']]\n== h ==\n* {{t|x=[[y]]}}\n=== i ===\nz"
+	for _, opts := range []*ParseOptions{ nil, &ParseOptions{ Paragraphs: true } } {
+		wiki, _ := ParseWithOptions([]byte(src), opts)
+		checkParents(t, wiki)
+	}
+	wiki, _ := ParseReader(strings.NewReader(src), &ParseOptions{ ChunkSize: 1, CopyRaw: true })
+	checkParents(t, wiki)
+
+	wiki, _ = ParseString(src)
+	res, _ := Query(wiki, "linkinternal textitalic")
+	if len(res) != 1 {
+		t.Fatalf("TestParents: %v", res)
+	}
+	italic := res[0]
+	var types []string
+	for _, e := range italic.Ancestors() {
+		types = append(types, e.Type.String())
+	}
+	if s := strings.Join(types, " "); s != "WikiEntityLinkInternalProp WikiEntityLinkInternal WikiEntityWiki" {
+		t.Errorf("TestParents: ancestors %s", s)
+	}
+	if path := italic.Path(); len(path) != 3 || path[0] != 1 || path[1] != 1 || path[2] != 0 {
+		t.Errorf("TestParents: path %v", path)
+	}
+	if wiki.Parent() != nil || len(wiki.Ancestors()) != 0 || len(wiki.Path()) != 0 || wiki.NextSibling() != nil {
+		t.Errorf("TestParents: the root has parent %v", wiki.Parent())
+	}
+
+	res, _ = Query(wiki, "heading3")
+	if i := res[0]; i.Parent().Type != WikiEntityHeading2 || i.Parent().Parent() != wiki {
+		t.Errorf("TestParents: the parent of %v is %v", i, i.Parent())
+	}
+	if _, err := json.Marshal(wiki); err != nil {
+		t.Errorf("TestParents: %v", err)
+	}
+
+	link := italic.Parent().Parent()
+	d, _ := NewLink("d", "")
+	if err := wiki.Replace(link, d); err != nil || link.Parent() != nil {
+		t.Errorf("TestParents: the parent of the removed %v is %v, %v", link, link.Parent(), err)
+	}
+	wrapped, _ := wiki.Wrap(wiki.Entities[1], WikiEntityTextBold)
+	if wrapped.Parent() != wiki || wrapped.Entities[0].Parent() != wrapped {
+		t.Errorf("TestParents: wrapped %v of %v", wrapped, wrapped.Parent())
+	}
+	checkParents(t, wiki)
+
+	e := &Entity{ Type: WikiEntityTextBold, Entities: []*Entity{ NewText("a") } }
+	if e.Entities[0].Parent() != nil {
+		t.Errorf("TestParents: the parent of a new text is %v", e.Entities[0].Parent())
+	}
+	e.SetParents()
+	checkParents(t, e)
+}
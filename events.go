@@ -0,0 +1,112 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+// Handler receives the entities of a document in order while it's being
+// parsed, without building the whole Entity tree.
+//
+// The entity passed to StartEntity and EndEntity is the same value for
+// both calls, it has the Type, Pos, Raw and Text fields as in the tree built
+// by Parse, but its children are reported by the events in between instead
+// of being attached to Entities. Text entities (WikiEntityText) are reported
+// by Text only.
+//
+// Returning a non-nil error from any method stops the parsing, and the error
+// is returned by ParseEvents.
+type Handler interface {
+	StartEntity(e *Entity) error
+	EndEntity(e *Entity) error
+	Text(e *Entity) error
+}
+
+type eventParser struct {
+	h Handler
+	headings []*Entity // open headings (sections)
+}
+
+func (p *eventParser) walk(e *Entity) (err error) {
+	if e.Type == WikiEntityText && len(e.Entities) == 0 {
+		return p.h.Text(e)
+	}
+	ent := *e
+	ent.Entities = nil
+	if err = p.h.StartEntity(&ent); err != nil {
+		return
+	}
+	for _, child := range e.Entities {
+		if err = p.walk(child); err != nil {
+			return
+		}
+	}
+	return p.h.EndEntity(&ent)
+}
+
+// closeHeadings ends all open headings of the level t or deeper.
+func (p *eventParser) closeHeadings(t EntityType) (err error) {
+	for n := len(p.headings); 0 < n && t <= p.headings[n-1].Type; n-- {
+		if err = p.h.EndEntity(p.headings[n-1]); err != nil {
+			return
+		}
+		p.headings = p.headings[0:n-1]
+	}
+	return
+}
+
+func (p *eventParser) entity(e *Entity) (err error) {
+	if e.Type < WikiEntityHeading2 || WikiEntityHeading5 < e.Type {
+		return p.walk(e)
+	}
+
+	// Headings stay open until the next heading of the same or a higher
+	// level, the entities in between are the children.
+	if err = p.closeHeadings(e.Type); err != nil {
+		return
+	}
+	ent := *e
+	ent.Entities = nil
+	if err = p.h.StartEntity(&ent); err != nil {
+		return
+	}
+	p.headings = append(p.headings, &ent)
+	for _, child := range e.Entities {
+		if err = p.walk(child); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// run reports the entities passed by each to the handler.
+func (p *eventParser) run(each func(fn func(e *Entity) error) error) (err error) {
+	wiki := &Entity{ Type: WikiEntityWiki }
+	if err = p.h.StartEntity(wiki); err != nil {
+		return
+	}
+	if err = each(p.entity); err != nil {
+		return
+	}
+	if err = p.closeHeadings(WikiEntityHeading2); err != nil {
+		return
+	}
+	return p.h.EndEntity(wiki)
+}
+
+// ParseEvents parses data and reports the entities to h without building
+// the whole tree. The events are not of the scanning, they're replayed per
+// top-level entity after it's parsed. The inline entities, e.g. the texts
+// and the links of the lines, are buffered until a block entity, e.g. a
+// heading or a list, and so are the paragraphs if enabled, so the memory
+// used is bounded by the largest run of them, not by the document.
+func ParseEvents(data []byte, h Handler) error {
+	return ParseEventsWithOptions(data, h, nil)
+}
+
+// ParseEventsWithOptions is the same as ParseEvents but with options, opts
+// may be nil.
+func ParseEventsWithOptions(data []byte, h Handler, opts *ParseOptions) error {
+	p := newParser(opts)
+	return (&eventParser{ h: h }).run(func(fn func(e *Entity) error) error {
+		return p.each(data, fn)
+	})
+}
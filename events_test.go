@@ -0,0 +1,144 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// treeHandler rebuilds the Entity tree from the events.
+type treeHandler struct {
+	stack []*Entity
+	root *Entity
+}
+
+func (h *treeHandler) add(e *Entity) {
+	if n := len(h.stack); 0 < n {
+		parent := h.stack[n-1]
+		parent.Entities = append(parent.Entities, e)
+	}
+}
+
+func (h *treeHandler) StartEntity(e *Entity) error {
+	h.add(e)
+	h.stack = append(h.stack, e)
+	return nil
+}
+
+func (h *treeHandler) EndEntity(e *Entity) error {
+	n := len(h.stack)
+	if n == 0 || h.stack[n-1] != e {
+		return errors.New("unbalanced EndEntity " + e.String())
+	}
+	if h.stack = h.stack[0:n-1]; n == 1 {
+		h.root = e
+	}
+	return nil
+}
+
+func (h *treeHandler) Text(e *Entity) error {
+	h.add(e)
+	return nil
+}
+
+func sameEntity(t *testing.T, tag string, a, b *Entity) bool {
+	if a.Type != b.Type || a.Pos != b.Pos || a.Text != b.Text || string(a.Raw) != string(b.Raw) {
+		t.Errorf("%s: %v (pos=%v) != %v (pos=%v)", tag, a, a.Pos, b, b.Pos)
+		return false
+	}
+	if len(a.Entities) != len(b.Entities) {
+		t.Errorf("%s: %v: children: %v != %v", tag, a, len(a.Entities), len(b.Entities))
+		return false
+	}
+	for i := range a.Entities {
+		if !sameEntity(t, tag, a.Entities[i], b.Entities[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseEvents(t *testing.T) {
+	sources := []string{
+		`Normal text.`,
+		`normal '''bold ''italic'' bold''' normal`,
+		"== A ==\nhello [[foo|bar]] {{t|a=b|c}}\n* item\n=== B ===\ntext\n==== C ====\n== D ==\nend",
+		"=== deep ===\ntext\n== up ==\n",
+	}
+	files, _ := filepath.Glob("testdata/*.wiki.gz")
+	for _, file := range files {
+		data, err := readTestData(file)
+		if err != nil {
+			t.Errorf("TestParseEvents: %s: %v", file, err)
+			continue
+		}
+		sources = append(sources, string(data))
+	}
+	for i, src := range sources {
+		wiki, err := ParseString(src)
+		if err != nil {
+			t.Errorf("TestParseEvents: [%d] Parse: %v", i, err)
+			continue
+		}
+		h := new(treeHandler)
+		if err := ParseEvents([]byte(src), h); err != nil {
+			t.Errorf("TestParseEvents: [%d] ParseEvents: %v", i, err)
+			continue
+		}
+		if h.root == nil {
+			t.Errorf("TestParseEvents: [%d] no root", i)
+			continue
+		}
+		sameEntity(t, "TestParseEvents", wiki, h.root)
+	}
+}
+
+var errStop = errors.New("stop")
+
+type templateNameHandler struct {
+	names []string
+	limit int
+}
+
+func (h *templateNameHandler) StartEntity(e *Entity) error { return nil }
+func (h *templateNameHandler) EndEntity(e *Entity) error {
+	if e.Type == WikiEntityTemplateName {
+		h.names = append(h.names, e.Text)
+		if len(h.names) == h.limit {
+			return errStop
+		}
+	}
+	return nil
+}
+func (h *templateNameHandler) Text(e *Entity) error { return nil }
+
+func TestParseEventsStop(t *testing.T) {
+	h := &templateNameHandler{ limit: 2 }
+	err := ParseEvents([]byte(`{{a}} text {{b|x}} text {{c}}`), h)
+	if err != errStop {
+		t.Errorf("TestParseEventsStop: %v", err)
+	}
+	if len(h.names) != 2 || h.names[0] != "a" || h.names[1] != "b" {
+		t.Errorf("TestParseEventsStop: %v", h.names)
+	}
+}
+
+func TestParseEventsWithOptions(t *testing.T) {
+	h := new(treeHandler)
+	err := ParseEventsWithOptions([]byte(strings.Repeat("[[", 5000)), h, &ParseOptions{ MaxInputSize: 1000 })
+	if e, ok := err.(*LimitError); !ok || e.Limit != "MaxInputSize" {
+		t.Errorf("TestParseEventsWithOptions: %v", err)
+	}
+	src, opts := "[x:y z] {{a}}", &ParseOptions{ Protocols: []string{ "x:" } }
+	wiki, _ := ParseWithOptions([]byte(src), opts)
+	h = new(treeHandler)
+	if err = ParseEventsWithOptions([]byte(src), h, opts); err != nil || h.root == nil {
+		t.Errorf("TestParseEventsWithOptions: %v", err)
+	} else if sameEntity(t, "TestParseEventsWithOptions", wiki, h.root) && h.root.Entities[0].Type != WikiEntityLinkExternal {
+		t.Errorf("TestParseEventsWithOptions: %v", h.root.Entities)
+	}
+}
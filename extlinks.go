@@ -0,0 +1,152 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// An external link in brackets is split into the URL and the label after
+// scanning, the same way as handleExternalLinks of MediaWiki's Parser.php:
+//
+//	[http://www.example.org Link label]
+//	 ^^^^^^^^^^^^^^^^^^^^^^			WikiEntityLinkExternalURL
+//	                        ^^^^^^^^^^	WikiEntityLinkExternalLabel
+//
+// The URL must start with one of the protocols (ParseOptions.Protocols), it
+// ends at the first character not allowed in URLs, e.g. a space or '<'. An
+// external link of which the URL is not valid has no children of the URL
+// and the label, e.g. [not a url].
+
+// isURLRune tells if r is allowed in URLs, the EXT_LINK_URL_CLASS of
+// MediaWiki.
+func isURLRune(r rune) bool {
+	switch {
+	case r <= 0x20, r == 0x7F, r == utf8.RuneError:
+		return false
+	case strings.ContainsRune(`[]<>"`, r):
+		return false
+	}
+	return !unicode.Is(unicode.Zs, r)
+}
+
+// protocolSize returns the size of the protocol of the URL s, or 0 if it
+// has none of the protocols.
+func protocolSize(s []byte, protocols []string) int {
+	for _, p := range protocols {
+		if p != "" && len(p) <= len(s) && strings.EqualFold(string(s[0:len(p)]), p) {
+			return len(p)
+		}
+	}
+	return 0
+}
+
+// externalLinks splits the external links in the tree of e.
+func externalLinks(e *Entity, protocols []string) {
+	for _, child := range e.Entities {
+		externalLinks(child, protocols)
+	}
+	if e.Type == WikiEntityLinkExternal {
+		splitExternalLink(e, protocols)
+	}
+}
+
+// splitExternalLink makes the URL and the label children of the external
+// link e, the children within them are moved into them.
+func splitExternalLink(e *Entity, protocols []string) {
+	raw := e.Raw
+	a, b := innerRange(e)
+	n := protocolSize(raw[a:b], protocols)
+	if n == 0 {
+		return
+	}
+	end := a + n
+	for more := true; more; {
+		for end < b {
+			r, size := utf8.DecodeRune(raw[end:b])
+			if !isURLRune(r) {
+				break
+			}
+			end += size
+		}
+		more = false
+		for _, child := range e.Entities {
+			// e.g. a template in the URL: [http://{{host|a b}}/path label]
+			if off := rawOffset(e, child); 0 <= off && off < end && end < off + len(child.Raw) {
+				end, more = off + len(child.Raw), true
+			}
+		}
+	}
+	if b < end {
+		end = b
+	}
+	if end == a + n {
+		return // the protocol only
+	}
+	beg := end
+	for beg < b {
+		r, size := utf8.DecodeRune(raw[beg:b])
+		if r != '\t' && !unicode.Is(unicode.Zs, r) {
+			break
+		}
+		beg += size
+	}
+
+	u := &Entity{ Type: WikiEntityLinkExternalURL, Pos: a, Raw: raw[a:end], Text: string(raw[a:end]) }
+	var label *Entity
+	if beg < b {
+		label = &Entity{ Type: WikiEntityLinkExternalLabel, Pos: beg, Raw: raw[beg:b], Text: string(raw[beg:b]) }
+	}
+	var rest []*Entity // children out of the range, e.g. of broken wikitext
+	for _, child := range e.Entities {
+		switch off := rawOffset(e, child); {
+		case a <= off && off + len(child.Raw) <= end:
+			child.Pos = off - a
+			u.Entities = append(u.Entities, child)
+		case label != nil && beg <= off && off + len(child.Raw) <= b:
+			child.Pos = off - beg
+			label.Entities = append(label.Entities, child)
+		default:
+			rest = append(rest, child)
+		}
+	}
+	e.Entities = []*Entity{ u }
+	if label != nil {
+		e.Entities = append(e.Entities, label)
+	}
+	e.Entities = append(e.Entities, rest...)
+}
+
+// externalLinkParts returns the URL and the label of the external link e,
+// the URL is nil if it's not valid.
+func externalLinkParts(e *Entity) (u, label *Entity) {
+	for _, child := range e.Entities {
+		switch child.Type {
+		case WikiEntityLinkExternalURL:
+			u = child
+		case WikiEntityLinkExternalLabel:
+			label = child
+		}
+	}
+	return
+}
+
+// URL returns the parsed URL of an external link, e.g. WikiEntityLinkExternal,
+// WikiEntityLinkExternalURL or WikiEntityLinkURL (bare URL).
+func (e *Entity) URL() (*url.URL, error) {
+	switch e.Type {
+	case WikiEntityLinkExternal:
+		if u, _ := externalLinkParts(e); u != nil {
+			return u.URL()
+		}
+		return nil, fmt.Errorf("wiki: %q has no valid URL", string(e.Raw))
+	case WikiEntityLinkExternalURL, WikiEntityLinkURL:
+		return url.Parse(e.Text)
+	}
+	return nil, fmt.Errorf("wiki: %v is not an external link", e.Type)
+}
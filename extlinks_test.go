@@ -0,0 +1,146 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestExternalLinks(t *testing.T) {
+	tests := []struct{
+		src string
+		entities []*entityTestResult
+	}{
+		/***** 0 *****/
+		{`[http://example.com Link label]`,
+			[]*entityTestResult{
+				{WikiEntityLinkExternal, `http://example.com Link label`, []*entityTestResult{
+					{WikiEntityLinkExternalURL, `http://example.com`, []*entityTestResult{}},
+					{WikiEntityLinkExternalLabel, `Link label`, []*entityTestResult{}},
+				}},
+			}},
+		/***** 1 *****/
+		{`[http://example.com]`,
+			[]*entityTestResult{
+				{WikiEntityLinkExternal, `http://example.com`, []*entityTestResult{
+					{WikiEntityLinkExternalURL, `http://example.com`, []*entityTestResult{}},
+				}},
+			}},
+		/***** 2 *****/
+		{`[http://a.b/ '''c''' {{d}} ''e'']`,
+			[]*entityTestResult{
+				{WikiEntityLinkExternal, `http://a.b/ '''c''' {{d}} ''e''`, []*entityTestResult{
+					{WikiEntityLinkExternalURL, `http://a.b/`, []*entityTestResult{}},
+					{WikiEntityLinkExternalLabel, `'''c''' {{d}} ''e''`, []*entityTestResult{
+						{WikiEntityTextBold, `c`, []*entityTestResult{}},
+						{WikiEntityTemplate, `d`, nil},
+						{WikiEntityTextItalic, `e`, []*entityTestResult{}},
+					}},
+				}},
+			}},
+		/***** 3 *****/
+		{`[//a.b/{{c|d e}}/f  label]`,
+			[]*entityTestResult{
+				{WikiEntityLinkExternal, `//a.b/{{c|d e}}/f  label`, []*entityTestResult{
+					{WikiEntityLinkExternalURL, `//a.b/{{c|d e}}/f`, []*entityTestResult{
+						{WikiEntityTemplate, `c|d e`, nil},
+					}},
+					{WikiEntityLinkExternalLabel, `label`, []*entityTestResult{}},
+				}},
+			}},
+		/***** 4 *****/
+		{`[HTTP://a.b<b>c</b>]`,
+			[]*entityTestResult{
+				{WikiEntityLinkExternal, `HTTP://a.b<b>c</b>`, []*entityTestResult{
+					{WikiEntityLinkExternalURL, `HTTP://a.b`, []*entityTestResult{}},
+					{WikiEntityLinkExternalLabel, `<b>c</b>`, []*entityTestResult{
+						{WikiEntityTagBeg, `b`, nil},
+						{WikiEntityTagEnd, `b`, nil},
+					}},
+				}},
+			}},
+		/***** 5 *****/
+		{`[not a url] [http:// a] [javascript:x y]`,
+			[]*entityTestResult{
+				{WikiEntityLinkExternal, `not a url`, []*entityTestResult{}},
+				{WikiEntityText, ` `, []*entityTestResult{}},
+				{WikiEntityLinkExternal, `http:// a`, []*entityTestResult{}},
+				{WikiEntityText, ` `, []*entityTestResult{}},
+				{WikiEntityLinkExternal, `javascript:x y`, []*entityTestResult{}},
+			}},
+		/***** 6 *****/
+		{`* [[a|[http://b c]]] [http://d ''e'']`,
+			[]*entityTestResult{
+				{WikiEntityListBulleted, ` [[a|[http://b c]]] [http://d ''e'']`, []*entityTestResult{
+					{WikiEntityLinkInternal, `a|[http://b c]`, []*entityTestResult{
+						{WikiEntityLinkInternalName, `a`, []*entityTestResult{}},
+						{WikiEntityLinkInternalProp, `[http://b c]`, []*entityTestResult{
+							{WikiEntityLinkExternal, `http://b c`, []*entityTestResult{
+								{WikiEntityLinkExternalURL, `http://b`, []*entityTestResult{}},
+								{WikiEntityLinkExternalLabel, `c`, []*entityTestResult{}},
+							}},
+						}},
+					}},
+					{WikiEntityLinkExternal, `http://d ''e''`, []*entityTestResult{
+						{WikiEntityLinkExternalURL, `http://d`, []*entityTestResult{}},
+						{WikiEntityLinkExternalLabel, `''e''`, []*entityTestResult{
+							{WikiEntityTextItalic, `e`, []*entityTestResult{}},
+						}},
+					}},
+				}},
+			}},
+	}
+	for i, tc := range tests {
+		data := []byte(tc.src)
+		wiki, err := Parse(data)
+		if err != nil {
+			t.Errorf("TestExternalLinks: [%d] %v", i, err)
+			continue
+		}
+		checkEntityResults(t, i, "TestExternalLinks", data, tc.src, wiki, tc.entities, true)
+		checkEntityRanges(t, data, wiki)
+
+		opts := &ParseOptions{ ChunkSize: 1, CopyRaw: true }
+		res, err := ParseReader(iotest.OneByteReader(strings.NewReader(tc.src)), opts)
+		if err != nil {
+			t.Errorf("TestExternalLinks: [%d] ParseReader: %v", i, err)
+			continue
+		}
+		sameEntity(t, fmt.Sprintf("TestExternalLinks: [%d]", i), wiki, res)
+	}
+}
+
+func TestEntityURL(t *testing.T) {
+	wiki, err := ParseString(`[http://a.b/c?d=e f] http://g.h/ [i j] [[k]]`)
+	if err != nil {
+		t.Fatalf("TestEntityURL: %v", err)
+	}
+	tests := []struct{
+		e *Entity
+		url string // "" for errors
+	}{
+		{ wiki.Entities[0], "http://a.b/c?d=e" },
+		{ wiki.Entities[0].Entities[0], "http://a.b/c?d=e" },
+		{ wiki.Entities[2], "http://g.h/" },
+		{ wiki.Entities[4], "" },
+		{ wiki.Entities[6], "" },
+	}
+	for i, test := range tests {
+		u, err := test.e.URL()
+		switch {
+		case test.url == "" && err == nil:
+			t.Errorf("TestEntityURL: [%d] %v: expect an error, got %v", i, test.e, u)
+		case test.url != "" && err != nil:
+			t.Errorf("TestEntityURL: [%d] %v: %v", i, test.e, err)
+		case test.url != "" && u.String() != test.url:
+			t.Errorf("TestEntityURL: [%d] expect %v, got %v", i, test.url, u)
+		}
+	}
+	if u, _ := wiki.Entities[0].URL(); u.Host != "a.b" || u.Query().Get("d") != "e" {
+		t.Errorf("TestEntityURL: %v", u)
+	}
+}
@@ -0,0 +1,128 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The bodies of the extension tags are not parsed as wikitext, the same as
+// the preprocessor of MediaWiki, a tag with the body is a WikiEntityTag:
+//
+//	<math>x^2</math>	WikiEntityTag
+//
+// The Text of it is the tag without '<' and '>', e.g. "math" and
+// `syntaxhighlight lang="go"`, the body is Entity.TagBody. An extension tag
+// without the closing tag is the same as the other tags. The tags are
+// declared by ParseOptions.ExtensionTags, <nowiki> and <pre> are parsed by
+// the parser itself.
+
+// ExtensionTag is the handlers of an extension tag, which may be nil.
+type ExtensionTag struct {
+	// Parse returns the children of the tag e made of the body of it, the
+	// Raw of them should be slices of e.Raw. The body is left unparsed if
+	// Parse is nil.
+	Parse func(e *Entity) ([]*Entity, error)
+
+	// Render writes the HTML of the tag e, the body is written as text if
+	// Render is nil.
+	Render func(w io.Writer, e *Entity) error
+}
+
+// ExtensionTags are the extension tags by the names, which are
+// case-insensitive, e.g. "math" and "Math" are the same.
+type ExtensionTags map[string]*ExtensionTag
+
+// NewExtensionTags returns the extension tags of the names without the
+// handlers, e.g. of SiteConfig.ExtensionTags.
+func NewExtensionTags(names ...string) ExtensionTags {
+	tags := make(ExtensionTags)
+	for _, name := range names {
+		tags[strings.ToLower(name)] = &ExtensionTag{}
+	}
+	return tags
+}
+
+// merge adds the tags of other to tags by the names in lower case.
+func (tags ExtensionTags) merge(other ExtensionTags) {
+	for name, tag := range other {
+		tags[strings.ToLower(name)] = tag
+	}
+}
+
+// lookup returns the tag of the name in lower case, the names of tags are
+// case-insensitive.
+func (tags ExtensionTags) lookup(name string) *ExtensionTag {
+	if tag, ok := tags[name]; ok {
+		return tag
+	}
+	for s, tag := range tags {
+		if strings.EqualFold(s, name) {
+			return tag
+		}
+	}
+	return nil
+}
+
+// raw tells if the body of the tag of the name is not parsed, the names of
+// tags are in lower case, see merge.
+func (tags ExtensionTags) raw(name string) bool {
+	_, ok := tags[name]
+	return ok && !noLinkTags[name]
+}
+
+// tagBodyRange returns the range of the body of the tag e in e.Raw, ok is
+// false if e has no body.
+func tagBodyRange(e *Entity) (a, b int, ok bool) {
+	if e.Type != WikiEntityTag {
+		return 0, 0, false
+	}
+	a, b = len(e.Text) + 2, bytes.LastIndex(e.Raw, []byte("</"))
+	if b < a || e.Raw[a-1] != '>' {
+		return 0, 0, false
+	}
+	return a, b, true
+}
+
+// TagBody returns the body of the extension tag e, e.g. "x^2" of
+// <math>x^2</math>, or nil if it has none.
+func (e *Entity) TagBody() []byte {
+	if a, b, ok := tagBodyRange(e); ok {
+		return e.Raw[a:b]
+	}
+	return nil
+}
+
+// parse makes the children of the extension tags in the tree of e by the
+// Parse handlers.
+func (tags ExtensionTags) parse(e *Entity) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	for _, child := range e.Entities {
+		if err := tags.parse(child); err != nil {
+			return err
+		}
+	}
+	if _, _, ok := tagBodyRange(e); !ok {
+		return nil
+	}
+	name, _ := tagName(e.Text)
+	if tag := tags[name]; tag != nil && tag.Parse != nil {
+		children, err := tag.Parse(e)
+		if err != nil {
+			return fmt.Errorf("wiki: <%s>: %v", name, err)
+		}
+		for _, child := range children {
+			if off := rawOffset(e, child); 0 <= off {
+				child.Pos = off
+			}
+		}
+		e.Entities = children
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtensionTags(t *testing.T) {
+	tags := NewExtensionTags("math", "Score", "nowiki")
+	tests := []struct{
+		src string
+		tags string // the Raw of the extension tags
+		body string // the body of the first one
+	}{
+		{ "a <math>[[x]] {{y}} ''z''</math> b", "<math>[[x]] {{y}} ''z''</math>", "[[x]] {{y}} ''z''" },
+		{ "{{t|<math>a|b</math>|c}}", "<math>a|b</math>", "a|b" },
+		{ "<math display=\"block\">\n== h ==\n* x\n</MATH >\n", "<math display=\"block\">\n== h ==\n* x\n</MATH >", "\n== h ==\n* x\n" },
+		{ "<score></score><math/>", "<score></score>", "" },
+		{ "<math>unclosed [[x]]", "", "" },
+		{ "<nowiki>[[x]]</nowiki>", "", "" },
+	}
+	for i, test := range tests {
+		for _, chunk := range []int{ 0, 1, 7 } {
+			opts := &ParseOptions{ ExtensionTags: tags, ChunkSize: chunk }
+			data := []byte(test.src)
+			var wiki *Entity
+			if chunk == 0 {
+				wiki, _ = ParseWithOptions(data, opts)
+				checkEntityRanges(t, data, wiki)
+			} else {
+				wiki, _ = ParseReader(bytes.NewReader(data), opts)
+			}
+			var raws []string
+			var first *Entity
+			res, _ := Query(wiki, "tag")
+			for _, e := range res {
+				if e.TagBody() != nil {
+					raws = append(raws, string(e.Raw))
+					if first == nil {
+						first = e
+					}
+				}
+			}
+			if s := strings.Join(raws, " "); s != test.tags || first != nil && string(first.TagBody()) != test.body {
+				t.Errorf("TestExtensionTags: [%d] chunk %d: expect %q, got %q", i, chunk, test.tags, s)
+			}
+			if s := Wikitext(wiki); string(s) != test.src {
+				t.Errorf("TestExtensionTags: [%d] chunk %d: wikitext %q", i, chunk, string(s))
+			}
+		}
+	}
+
+	// The lines of the poems are the children, the names of the tags are
+	// case-insensitive.
+	tags["Poem"] = &ExtensionTag{
+		Parse: func(e *Entity) ([]*Entity, error) {
+			body := e.TagBody()
+			if bytes.Contains(body, []byte("error")) {
+				return nil, fmt.Errorf("bad poem")
+			}
+			var lines []*Entity
+			for _, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+				lines = append(lines, &Entity{ Type: WikiEntityText, Raw: line, Text: string(line) })
+			}
+			return lines, nil
+		},
+		Render: func(w io.Writer, e *Entity) error {
+			var lines []string
+			for _, line := range e.Entities {
+				lines = append(lines, htmlEscape(line.Raw, false))
+			}
+			_, err := io.WriteString(w, `<div class="poem"><p>` + strings.Join(lines, "<br />\n") + "</p></div>")
+			return err
+		},
+	}
+	opts := &ParseOptions{ ExtensionTags: tags }
+	wiki, err := ParseWithOptions([]byte("<poem>\na [[b]]\nc & d\n</poem> <math>x<y</math>"), opts)
+	if err != nil {
+		t.Fatalf("TestExtensionTags: %v", err)
+	}
+	poem := wiki.Entities[0]
+	if len(poem.Entities) != 2 || poem.Entities[1].Pos != 15 || poem.Entities[1].Parent() != poem {
+		t.Errorf("TestExtensionTags: poem %v", poem.Entities)
+	}
+	var b bytes.Buffer
+	if err := RenderHTML(&b, wiki, nil); err != nil {
+		t.Errorf("TestExtensionTags: %v", err)
+	}
+	if expect := "<div class=\"poem\"><p>a [[b]]<br />\nc &amp; d</p></div> x&lt;y"; b.String() != expect {
+		t.Errorf("TestExtensionTags: expect %q, got %q", expect, b.String())
+	}
+	math := &ExtensionTag{ Render: func(w io.Writer, e *Entity) error {
+		_, err := io.WriteString(w, "<math/>")
+		return err
+	} }
+	b.Reset()
+	if err := RenderHTML(&b, wiki, &HTMLOptions{ ExtensionTags: ExtensionTags{ "MATH": math } }); err != nil || !strings.HasSuffix(b.String(), "</div> <math/>") {
+		t.Errorf("TestExtensionTags: %q %v", b.String(), err)
+	}
+	if s := PlainText(wiki); s != "\na [[b]]\nc & d\n x<y" {
+		t.Errorf("TestExtensionTags: text %q", s)
+	}
+	if _, err := ParseWithOptions([]byte("<poem>error</poem>"), opts); err == nil {
+		t.Errorf("TestExtensionTags: expect an error of the poem")
+	}
+}
+
+func TestExtensionTagsUnclosed(t *testing.T) {
+	// The data is searched once for the closing tags of a name, so many
+	// unclosed tags are parsed in linear time.
+	tags := NewExtensionTags("math", "score")
+	src := strings.Repeat("<math>", 50000) + strings.Repeat("<score>[[a]]", 10000) + "</score>"
+	for _, chunk := range []int{ 0, 64 } {
+		opts := &ParseOptions{ ExtensionTags: tags, ChunkSize: chunk }
+		start := time.Now()
+		var wiki *Entity
+		var err error
+		if chunk == 0 {
+			wiki, err = ParseWithOptions([]byte(src), opts)
+		} else {
+			wiki, err = ParseReader(strings.NewReader(src), opts)
+		}
+		if d := time.Since(start); 2*time.Second < d {
+			t.Errorf("TestExtensionTagsUnclosed: chunk %d: %v", chunk, d)
+		}
+		if err != nil {
+			t.Fatalf("TestExtensionTagsUnclosed: chunk %d: %v", chunk, err)
+		}
+		res, _ := Query(wiki, "tagbeg")
+		links, _ := Query(wiki, "linkinternal")
+		if last := wiki.Entities[len(wiki.Entities)-1]; len(res) != 50000 || len(links) != 0 || len(last.TagBody()) != 10000*12 - 7 {
+			t.Errorf("TestExtensionTagsUnclosed: chunk %d: %d tags, %d links, last %v", chunk, len(res), len(links), last.Type)
+		}
+	}
+}
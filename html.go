@@ -0,0 +1,617 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HTMLOptions controls the output of RenderHTML.
+type HTMLOptions struct {
+	// LinkURL returns the URL of the page title of an internal link, it's
+	// "/wiki/" followed by the title (spaces replaced by '_') if nil.
+	LinkURL func(title string) string
+
+	// PageExists tells if the page of an internal link exists, links to
+	// missing pages are rendered as red links. All pages exist if nil.
+	PageExists func(title string) bool
+
+	// Template renders a template, the wikitext of the template is written
+	// as text if nil.
+	Template func(w io.Writer, e *Entity) error
+
+	// Page is the page of which the variables are evaluated, e.g.
+	// {{PAGENAME}}, the wikitext of the variables is written as text if
+	// nil.
+	Page *PageContext
+
+	// ExtensionTags override the extension tags of the parser of the tree
+	// to render the tags by the Render handlers, the bodies of the tags
+	// without handlers are written as text.
+	ExtensionTags ExtensionTags
+
+	// Anchors is the encoding of the ids of the headings and the fragments
+	// of the links, AnchorHTML5 by default.
+	Anchors AnchorEncoding
+}
+
+type htmlRenderer struct {
+	w io.Writer
+	err error
+	opts *HTMLOptions
+	autonumber int // number of the external links without label
+	anchors map[*Entity]string // the ids of the headings of the document
+}
+
+var entityRefRegexp = regexp.MustCompile(`^&([a-zA-Z][a-zA-Z0-9]*|#[0-9]+|#[xX][0-9a-fA-F]+);`)
+
+func htmlEscape(s []byte, attr bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '<': b.WriteString("&lt;")
+		case '>': b.WriteString("&gt;")
+		case '"':
+			if attr {
+				b.WriteString("&quot;")
+			} else {
+				b.WriteByte(c)
+			}
+		case '&':
+			if ref := entityRefRegexp.Find(s[i:]); ref != nil {
+				b.WriteString(normalCharRef(ref))
+				i += len(ref) - 1
+			} else {
+				b.WriteString("&amp;")
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (r *htmlRenderer) write(s string) {
+	if r.err == nil {
+		_, r.err = io.WriteString(r.w, s)
+	}
+}
+
+func (r *htmlRenderer) text(s []byte) {
+	r.write(htmlEscape(s, false))
+}
+
+// content renders e.Raw[a:b], texts and the child entities within.
+func (r *htmlRenderer) content(e *Entity, a, b int) {
+	segments(e, a, b, func(text []byte, child *Entity) {
+		if child == nil {
+			r.text(text)
+		} else {
+			r.entity(child)
+		}
+	})
+}
+
+func (r *htmlRenderer) inner(e *Entity) {
+	a, b := innerRange(e)
+	r.content(e, a, b)
+}
+
+func isList(e *Entity) bool {
+	switch e.Type {
+	case WikiEntityListBulleted, WikiEntityListNumbered, WikiEntityIndent:
+		return true
+	}
+	return false
+}
+
+func isHeading(e *Entity) bool {
+	return WikiEntityHeading2 <= e.Type && e.Type <= WikiEntityHeading5
+}
+
+// block renders a sequence of sibling entities.
+func (r *htmlRenderer) block(entities []*Entity) {
+	for i := 0; i < len(entities); {
+		if !isList(entities[i]) {
+			r.entity(entities[i])
+			i++
+			continue
+		}
+		n := i + 1
+		for n < len(entities) && isList(entities[n]) {
+			n++
+		}
+		r.lists(entities[i:n])
+		i = n
+	}
+}
+
+// listPrefix returns the list markups of a list item, e.g. "*#".
+func listPrefix(e *Entity) string {
+	n := 0
+	for n < len(e.Raw) && (e.Raw[n] == '*' || e.Raw[n] == '#' || e.Raw[n] == ':') {
+		n++
+	}
+	return string(e.Raw[0:n])
+}
+
+func listTags(c byte) (list, item string) {
+	switch c {
+	case '#': return "ol", "li"
+	case ':': return "dl", "dd"
+	}
+	return "ul", "li"
+}
+
+// listContent calls fn for the pieces of the content of a list item except
+// the nested lists, which is in the innermost of the nested list entities.
+func listContent(e *Entity, prefix int, fn func(text []byte, child *Entity)) {
+	for off := 0; ; {
+		var next *Entity
+		for _, child := range e.Entities {
+			if isList(child) && 0 <= rawOffset(e, child) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			a := prefix - off
+			if a < 0 {
+				a = 0
+			}
+			segments(e, a, len(e.Raw), func(text []byte, child *Entity) {
+				if child == nil || !isList(child) {
+					fn(text, child)
+				}
+			})
+			return
+		}
+		off += rawOffset(e, next)
+		e = next
+	}
+}
+
+// listItem renders the content of a list item.
+func (r *htmlRenderer) listItem(e *Entity, prefix int) {
+	listContent(e, prefix, func(text []byte, child *Entity) {
+		if child == nil {
+			r.text(text)
+		} else {
+			r.entity(child)
+		}
+	})
+}
+
+// lists renders a run of list items the way of MediaWiki, nested lists are
+// made from the common prefix of adjacent items.
+func (r *htmlRenderer) lists(items []*Entity) {
+	last := ""
+	for _, item := range items {
+		prefix := listPrefix(item)
+		common := 0
+		for common < len(prefix) && common < len(last) && prefix[common] == last[common] {
+			common++
+		}
+		if 0 < common && common == len(prefix) && common == len(last) {
+			_, tag := listTags(prefix[common-1])
+			r.write("</" + tag + ">\n<" + tag + ">")
+		} else {
+			for n := len(last); common < n; n-- {
+				list, tag := listTags(last[n-1])
+				r.write("</" + tag + "></" + list + ">")
+			}
+			if 0 < common && len(prefix) <= common {
+				_, tag := listTags(prefix[common-1])
+				r.write("</" + tag + ">\n<" + tag + ">")
+			}
+			for n := common; n < len(prefix); n++ {
+				list, tag := listTags(prefix[n])
+				if 0 < n {
+					r.write("\n")
+				}
+				r.write("<" + list + "><" + tag + ">")
+			}
+		}
+		r.listItem(item, len(prefix))
+		last = prefix
+	}
+	for n := len(last); 0 < n; n-- {
+		list, tag := listTags(last[n-1])
+		r.write("</" + tag + "></" + list + ">")
+	}
+	r.write("\n")
+}
+
+// rangeText returns the text of e.Raw[a:b] without the markups.
+func rangeText(e *Entity, a, b int) string {
+	var s bytes.Buffer
+	segments(e, a, b, func(text []byte, child *Entity) {
+		if child == nil {
+			s.Write(text)
+			return
+		}
+		switch child.Type {
+		case WikiEntityTemplate, WikiEntityTag, WikiEntityTagBeg, WikiEntityTagEnd,
+			WikiEntityBehaviorSwitch, WikiEntityMagicWord:
+		case WikiEntityLinkInternal:
+			s.WriteString(linkLabelText(child))
+		case WikiEntityCharRef:
+			s.WriteString(child.Text)
+		default:
+			a, b := innerRange(child)
+			s.WriteString(rangeText(child, a, b))
+		}
+	})
+	return s.String()
+}
+
+// linkParts returns the name and the label (nil if none) of an internal link.
+func linkParts(e *Entity) (name string, label *Entity) {
+	for _, child := range e.Entities {
+		switch child.Type {
+		case WikiEntityLinkInternalName:
+			name = strings.TrimSpace(child.Text)
+		case WikiEntityLinkInternalProp:
+			label = child
+		}
+	}
+	return
+}
+
+// isCategoryLink tells if e is a link of a category, e.g. [[Category:Foo]].
+func isCategoryLink(e *Entity) bool {
+	if e.Type != WikiEntityLinkInternal {
+		return false
+	}
+	name, _ := linkParts(e)
+	return strings.HasPrefix(strings.ToLower(name), "category:")
+}
+
+func linkLabelText(e *Entity) (s string) {
+	name, label := linkParts(e)
+	switch {
+	case label == nil:
+		s = decodeCharRefs([]byte(strings.TrimPrefix(name, ":")))
+	case isPipeTrick(e):
+		s = decodeCharRefs([]byte(pipeTrickLabel(name)))
+	default:
+		a, b := innerRange(label)
+		s = rangeText(label, a, b)
+	}
+	if trail := linkTrail(e); trail != nil {
+		s += trail.Text
+	}
+	return
+}
+
+// urlencodeReplacer restores the characters kept by MediaWiki's wfUrlencode.
+var urlencodeReplacer = strings.NewReplacer("%3B", ";", "%40", "@", "%24", "$",
+	"%21", "!", "%2A", "*", "%28", "(", "%29", ")", "%2C", ",", "%2F", "/",
+	"%7E", "~", "%3A", ":")
+
+// urlencode encodes a page title for URLs the way of MediaWiki.
+func urlencode(title string) string {
+	return urlencodeReplacer.Replace(url.QueryEscape(strings.Replace(title, " ", "_", -1)))
+}
+
+func (r *htmlRenderer) linkURL(title string) string {
+	if r.opts != nil && r.opts.LinkURL != nil {
+		return r.opts.LinkURL(title)
+	}
+	return "/wiki/" + urlencode(title)
+}
+
+func (r *htmlRenderer) linkInternal(e *Entity) {
+	if isCategoryLink(e) {
+		return // category links are not rendered in place
+	}
+	name, label := linkParts(e)
+	t, err := e.Title(nil)
+	if err != nil {
+		r.text(e.Raw) // not a valid title
+		return
+	}
+	title, fragment := t.PrefixedText(), ""
+	if i := strings.IndexByte(name, '#'); 0 <= i {
+		fragment = fragmentURL(name[i+1:], r.anchorEncoding())
+	}
+	if title == "" && strings.HasPrefix(name, "#") {
+		r.write(`<a href="` + htmlEscape([]byte(fragment), true) + `">`)
+	} else if r.opts != nil && r.opts.PageExists != nil && !r.opts.PageExists(title) {
+		href := "/index.php?title=" + urlencode(title) + "&action=edit&redlink=1"
+		r.write(`<a href="` + htmlEscape([]byte(href), true) + `" class="new" title="` + htmlEscape([]byte(title + " (page does not exist)"), true) + `">`)
+	} else {
+		r.write(`<a href="` + htmlEscape([]byte(r.linkURL(title) + fragment), true) + `" title="` + htmlEscape([]byte(title), true) + `">`)
+	}
+	switch {
+	case label == nil:
+		r.text([]byte(strings.TrimPrefix(name, ":")))
+	case isPipeTrick(e):
+		r.text([]byte(pipeTrickLabel(name)))
+	default:
+		r.inner(label)
+	}
+	if trail := linkTrail(e); trail != nil {
+		r.text(trail.Raw)
+	}
+	r.write("</a>")
+}
+
+// redirect writes the target of a redirect page, the same way as the
+// redirect header of MediaWiki.
+func (r *htmlRenderer) redirect(e *Entity) {
+	title, fragment := redirectTarget(e, nil)
+	href, text := r.linkURL(title) + fragmentURL(fragment, r.anchorEncoding()), title
+	if fragment != "" {
+		text += "#" + fragment
+	}
+	r.write(`<div class="redirectMsg"><p>Redirect to:</p><ul class="redirectText"><li>`)
+	r.write(`<a href="` + htmlEscape([]byte(href), true) + `" title="` + htmlEscape([]byte(title), true) + `">`)
+	r.text([]byte(text))
+	r.write("</a></li></ul></div>\n")
+}
+
+func (r *htmlRenderer) linkExternal(e *Entity) {
+	u, label := externalLinkParts(e)
+	if u == nil {
+		a, b := innerRange(e)
+		r.write("[")
+		r.content(e, a, b)
+		r.write("]")
+		return
+	}
+	href := htmlEscape(u.Raw, true)
+	if label != nil {
+		r.write(`<a rel="nofollow" class="external text" href="` + href + `">`)
+		r.inner(label)
+	} else {
+		r.autonumber++
+		r.write(`<a rel="nofollow" class="external autonumber" href="` + href + `">[` + strconv.Itoa(r.autonumber) + "]")
+	}
+	r.write("</a>")
+}
+
+// htmlTags is the set of HTML tags allowed in wikitext.
+var htmlTags = map[string]bool{
+	"abbr": true, "b": true, "bdi": true, "bdo": true, "big": true,
+	"blockquote": true, "br": true, "caption": true, "center": true,
+	"cite": true, "code": true, "data": true, "dd": true, "del": true,
+	"dfn": true, "div": true, "dl": true, "dt": true, "em": true,
+	"font": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "i": true, "ins": true,
+	"kbd": true, "li": true, "mark": true, "ol": true, "p": true,
+	"pre": true, "q": true, "rb": true, "rp": true, "rt": true,
+	"ruby": true, "s": true, "samp": true, "small": true, "span": true,
+	"strike": true, "strong": true, "sub": true, "sup": true,
+	"table": true, "td": true, "th": true, "time": true, "tr": true,
+	"tt": true, "u": true, "ul": true, "var": true, "wbr": true,
+}
+
+var htmlAttrRegexp = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9_:.-]*)\s*(?:=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+
+// htmlAttrs is the set of attributes allowed for all htmlTags.
+var htmlAttrs = map[string]bool{
+	"class": true, "id": true, "style": true, "title": true, "lang": true,
+	"dir": true, "align": true, "colspan": true, "rowspan": true,
+	"width": true, "height": true, "color": true, "size": true, "face": true,
+}
+
+// tagName splits the Text of a tag entity into name and attributes.
+func tagName(text string) (name, attrs string) {
+	n := 0
+	for n < len(text) && (isLetter(text[n]) || ('0' <= text[n] && text[n] <= '9' && 0 < n)) {
+		n++
+	}
+	return strings.ToLower(text[0:n]), strings.TrimSuffix(strings.TrimSpace(text[n:]), "/")
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// isbnSeparatorRegexp matches the separators in ISBN numbers, including the
+// entity references of spaces, e.g. &nbsp;.
+var isbnSeparatorRegexp = regexp.MustCompile(`&[^;]*;|[^0-9Xx]`)
+
+func (r *htmlRenderer) magicLink(e *Entity) {
+	num := htmlEscape([]byte(e.Text), false)
+	switch e.Type {
+	case WikiEntityLinkISBN:
+		isbn := strings.ToUpper(isbnSeparatorRegexp.ReplaceAllString(e.Text, ""))
+		href := htmlEscape([]byte(r.linkURL("Special:BookSources/" + isbn)), true)
+		r.write(`<a href="` + href + `" class="internal mw-magiclink-isbn">ISBN ` + num + "</a>")
+	case WikiEntityLinkRFC:
+		r.write(`<a class="external mw-magiclink-rfc" rel="nofollow" href="https://tools.ietf.org/html/rfc` + e.Text + `">RFC ` + num + "</a>")
+	case WikiEntityLinkPMID:
+		r.write(`<a class="external mw-magiclink-pmid" rel="nofollow" href="//www.ncbi.nlm.nih.gov/pubmed/` + e.Text + `?dopt=Abstract">PMID ` + num + "</a>")
+	}
+}
+
+// extensionTag renders the extension tag e with the body.
+func (r *htmlRenderer) extensionTag(e *Entity) {
+	name, _ := tagName(e.Text)
+	tag := e.extensionTags().lookup(name)
+	if r.opts != nil {
+		if t := r.opts.ExtensionTags.lookup(name); t != nil {
+			tag = t
+		}
+	}
+	if tag != nil && tag.Render != nil {
+		if r.err == nil {
+			r.err = tag.Render(r.w, e)
+		}
+		return
+	}
+	a, b, _ := tagBodyRange(e)
+	r.content(e, a, b)
+}
+
+func (r *htmlRenderer) tag(e *Entity) {
+	if _, _, ok := tagBodyRange(e); ok {
+		r.extensionTag(e)
+		return
+	}
+	name, attrs := tagName(strings.TrimPrefix(e.Text, "/"))
+	if !htmlTags[name] {
+		r.text(e.Raw)
+		return
+	}
+	if e.Type == WikiEntityTagEnd {
+		r.write("</" + name + ">")
+		return
+	}
+	r.write("<" + name)
+	for _, m := range htmlAttrRegexp.FindAllStringSubmatch(attrs, -1) {
+		key := strings.ToLower(m[1])
+		value := m[2] + m[3] + m[4]
+		if !htmlAttrs[key] {
+			continue
+		}
+		if key == "style" && (strings.Contains(strings.ToLower(value), "url(") || strings.Contains(strings.ToLower(value), "expression")) {
+			continue
+		}
+		r.write(" " + key + `="` + htmlEscape([]byte(value), true) + `"`)
+	}
+	if e.Type == WikiEntityTag || name == "br" || name == "hr" || name == "wbr" {
+		r.write(" />")
+	} else {
+		r.write(">")
+	}
+}
+
+// headingRange returns the range of the title of the heading e in e.Raw,
+// without the spaces around.
+func headingRange(e *Entity) (a, b int) {
+	a, b = innerRange(e)
+	return trimRange(e, a, b)
+}
+
+// headingSection returns the entities of the section of the heading e.
+func headingSection(e *Entity) (section []*Entity) {
+	for _, child := range e.Entities {
+		if rawOffset(e, child) < 0 {
+			section = append(section, child)
+		}
+	}
+	return
+}
+
+func (r *htmlRenderer) anchorEncoding() AnchorEncoding {
+	if r.opts != nil {
+		return r.opts.Anchors
+	}
+	return AnchorHTML5
+}
+
+// anchor returns the id of the heading e, which is unique in the document.
+func (r *htmlRenderer) anchor(e *Entity) string {
+	if r.anchors == nil {
+		root := e
+		for root.Parent() != nil {
+			root = root.Parent()
+		}
+		r.anchors = HeadingAnchors(root, r.anchorEncoding())
+	}
+	if id, ok := r.anchors[e]; ok {
+		return id
+	}
+	return Anchor(HeadingText(e), r.anchorEncoding())
+}
+
+func (r *htmlRenderer) heading(e *Entity) {
+	n := strconv.Itoa(int(e.Type - WikiEntityHeading2) + 2)
+	a, b := headingRange(e)
+	r.write("<h" + n + `><span class="mw-headline" id="` + htmlEscape([]byte(r.anchor(e)), true) + `">`)
+	r.content(e, a, b)
+	r.write("</span></h" + n + ">\n")
+
+	r.block(headingSection(e))
+}
+
+func (r *htmlRenderer) entity(e *Entity) {
+	switch e.Type {
+	case WikiEntityWiki:
+		r.block(e.Entities)
+	case WikiEntityText:
+		r.text([]byte(e.Text))
+	case WikiEntityTextBold:
+		r.write("<b>"); r.inner(e); r.write("</b>")
+	case WikiEntityTextItalic:
+		r.write("<i>"); r.inner(e); r.write("</i>")
+	case WikiEntityTextBoldItalic:
+		if len(e.Raw) == 5 {
+			break // a lonely ''''' at the end of a line
+		}
+		r.write("<i><b>"); r.inner(e); r.write("</b></i>")
+	case WikiEntityHeading2, WikiEntityHeading3, WikiEntityHeading4, WikiEntityHeading5:
+		r.heading(e)
+	case WikiEntityLinkInternal:
+		r.linkInternal(e)
+	case WikiEntityLinkExternal:
+		r.linkExternal(e)
+	case WikiEntityTemplate:
+		if r.opts != nil && r.opts.Template != nil {
+			if r.err == nil {
+				r.err = r.opts.Template(r.w, e)
+			}
+		} else {
+			r.text(e.Raw)
+		}
+	case WikiEntityTag, WikiEntityTagBeg, WikiEntityTagEnd:
+		r.tag(e)
+	case WikiEntityListBulleted, WikiEntityListNumbered, WikiEntityIndent:
+		r.lists([]*Entity{ e })
+	case WikiEntityHR:
+		r.write("<hr />\n")
+	case WikiEntityLinkURL:
+		href := htmlEscape(e.Raw, true)
+		r.write(`<a rel="nofollow" class="external free" href="` + href + `">` + href + "</a>")
+	case WikiEntityLinkISBN, WikiEntityLinkRFC, WikiEntityLinkPMID:
+		r.magicLink(e)
+	case WikiEntityRedirect:
+		r.redirect(e)
+	case WikiEntityCharRef:
+		r.text(e.Raw)
+	case WikiEntityBehaviorSwitch:
+		// not rendered in place
+	case WikiEntityMagicWord:
+		if isFunction(e.Text) {
+			break // not rendered in place
+		}
+		if r.opts != nil && r.opts.Page != nil {
+			if s, ok := r.opts.Page.Eval(e); ok {
+				r.text([]byte(s))
+				break
+			}
+		}
+		r.text(e.Raw)
+	case WikiEntityParagraph:
+		r.write("<p>"); r.content(e, 0, len(e.Raw)); r.write("\n</p>")
+	case WikiEntityLinkInternalName, WikiEntityLinkInternalProp,
+		WikiEntityLinkExternalURL, WikiEntityLinkExternalLabel,
+		WikiEntityTemplateName, WikiEntityTemplateProp:
+		r.inner(e)
+	default:
+		r.text(e.Raw)
+	}
+}
+
+// RenderHTML writes the HTML of the entity e to w, opts may be nil.
+func RenderHTML(w io.Writer, e *Entity, opts *HTMLOptions) error {
+	r := &htmlRenderer{ w: w, opts: opts }
+	r.entity(e)
+	return r.err
+}
+
+// HTML returns the HTML of the entity e with the default options.
+func HTML(e *Entity) string {
+	var b bytes.Buffer
+	RenderHTML(&b, e, nil)
+	return b.String()
+}
@@ -0,0 +1,75 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		src, html string
+	}{
+		{ ``, `` },
+		{ `text & <b>bold</b>`, `text &amp; <b>bold</b>` },
+		{ `a '''b''' ''c'' '''''d'''''`, `a <b>b</b> <i>c</i> <i><b>d</b></i>` },
+		{ `a '''''`, `a ` },
+		{ "== A b ==\ntext", "<h2><span class=\"mw-headline\" id=\"A_b\">A b</span></h2>\n\ntext" },
+		{ "* a\n* b\n", "<ul><li> a</li>\n<li> b</li></ul>\n\n" },
+		{ "# a\n## b\n# c\n", "<ol><li> a\n<ol><li> b</li></ol></li>\n<li> c</li></ol>\n\n" },
+		{ `[[foo bar]]`, `<a href="/wiki/Foo_bar" title="Foo bar">foo bar</a>` },
+		{ `[[foo|''bar'']]`, `<a href="/wiki/Foo" title="Foo"><i>bar</i></a>` },
+		{ `[[Category:Foo]]`, `` },
+		{ `[[bus]]es [[Help:Contents|]]s`, `<a href="/wiki/Bus" title="Bus">buses</a> <a href="/wiki/Help:Contents" title="Help:Contents">Contentss</a>` },
+		{ `[http://a.b/ c d]`, `<a rel="nofollow" class="external text" href="http://a.b/">c d</a>` },
+		{ `[http://a.b/][http://c.d/]`, `<a rel="nofollow" class="external autonumber" href="http://a.b/">[1]</a><a rel="nofollow" class="external autonumber" href="http://c.d/">[2]</a>` },
+		{ `[not a url]`, `[not a url]` },
+		{ `see http://a.b/?c&d.`, `see <a rel="nofollow" class="external free" href="http://a.b/?c&amp;d">http://a.b/?c&amp;d</a>.` },
+		{ `ISBN 0-306-40615-x`, `<a href="/wiki/Special:BookSources/030640615X" class="internal mw-magiclink-isbn">ISBN 0-306-40615-x</a>` },
+		{ `<script>x</script>`, `&lt;script&gt;x&lt;/script&gt;` },
+		{ `<span style="x:url(y)" onclick="z" class=c>a</span>`, `<span class="c">a</span>` },
+		{ `a<br>b`, `a<br />b` },
+		{ "----\n", "<hr />\n\n" },
+		{ `{{PAGENAME}} __NOTOC__{{DEFAULTSORT:a}}`, `{{PAGENAME}} ` },
+		{ `[[a|&alpha;]] &Alpha;&bogus; &#X3B1;`, `<a href="/wiki/A" title="A">&#945;</a> &#913;&amp;bogus; &#x3b1;` },
+		{ "#REDIRECT [[a b#c d]]\n", "<div class=\"redirectMsg\"><p>Redirect to:</p><ul class=\"redirectText\"><li><a href=\"/wiki/A_b#c_d\" title=\"A b\">A b#c d</a></li></ul></div>\n\n" },
+	}
+	for i, test := range tests {
+		wiki, err := ParseString(test.src)
+		if err != nil {
+			t.Errorf("TestRenderHTML: [%d] %v", i, err)
+			continue
+		}
+		if s := HTML(wiki); s != test.html {
+			t.Errorf("TestRenderHTML: [%d] expect %q, got %q", i, test.html, s)
+		}
+	}
+}
+
+func TestRenderHTMLOptions(t *testing.T) {
+	opts := &HTMLOptions{
+		LinkURL: func(title string) string { return "/w/" + title },
+		PageExists: func(title string) bool { return title == "Foo" },
+		Template: func(w io.Writer, e *Entity) error {
+			_, err := fmt.Fprintf(w, "(%s)", e.Text)
+			return err
+		},
+		Page: &PageContext{ Title: "help:foo_bar" },
+	}
+	wiki, err := ParseString(`[[foo]] [[a b]] {{t|x}} {{PAGENAME}}`)
+	if err != nil {
+		t.Fatalf("TestRenderHTMLOptions: %v", err)
+	}
+	var b bytes.Buffer
+	if err := RenderHTML(&b, wiki, opts); err != nil {
+		t.Fatalf("TestRenderHTMLOptions: %v", err)
+	}
+	expect := `<a href="/w/Foo" title="Foo">foo</a> <a href="/index.php?title=A_b&amp;action=edit&amp;redlink=1" class="new" title="A b (page does not exist)">a b</a> (t|x) Foo bar`
+	if s := b.String(); s != expect {
+		t.Errorf("TestRenderHTMLOptions: expect %q, got %q", expect, s)
+	}
+}
@@ -0,0 +1,176 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// parserTest is a case of the MediaWiki parserTests.txt format.
+type parserTest struct {
+	name string
+	line int
+	sections map[string]string // e.g. "wikitext", "html", "options"
+}
+
+type parserTests struct {
+	articles map[string]string
+	tests []*parserTest
+}
+
+// readParserTests reads a file of the MediaWiki parserTests.txt format.
+func readParserTests(file string) (*parserTests, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		res = &parserTests{ articles: make(map[string]string) }
+		sections map[string]string
+		section string
+		lines []string
+		line, start int
+		s = bufio.NewScanner(f)
+	)
+	for s.Scan() {
+		line++
+		text := s.Text()
+		if !strings.HasPrefix(text, "!!") {
+			if sections != nil {
+				lines = append(lines, text)
+			}
+			continue
+		}
+		if sections != nil && section != "" {
+			sections[section] = strings.Join(lines, "\n")
+		}
+		section, lines = strings.ToLower(strings.TrimSpace(text[2:])), nil
+		switch section {
+		case "test", "article":
+			sections, start = make(map[string]string), line
+		case "end":
+			if sections != nil {
+				t := &parserTest{ name: strings.TrimSpace(sections["test"]), line: start, sections: sections }
+				if _, ok := sections["wikitext"]; !ok {
+					sections["wikitext"] = sections["input"]
+				}
+				if _, ok := sections["html/php"]; ok {
+					sections["html"] = sections["html/php"]
+				} else if _, ok := sections["html"]; !ok {
+					if r, ok := sections["result"]; ok {
+						sections["html"] = r
+					}
+				}
+				res.tests = append(res.tests, t)
+			}
+			sections, section = nil, ""
+		case "endarticle":
+			if sections != nil {
+				if title, err := ParseTitle(sections["article"], nil); err == nil {
+					res.articles[title.PrefixedText()] = sections["text"]
+				}
+			}
+			sections, section = nil, ""
+		default:
+			if sections == nil {
+				section = "" // e.g. "!! Version 2", "!! hooks"
+			}
+		}
+	}
+	return res, s.Err()
+}
+
+// skip tells the reason of not running the test, or "" if it should be run.
+func (t *parserTest) skip() string {
+	if _, ok := t.sections["html"]; !ok {
+		return "no html/php output"
+	}
+	for _, opt := range strings.Fields(t.sections["options"]) {
+		switch opt {
+		case "disabled", "parsoid-only":
+			return opt
+		}
+	}
+	return ""
+}
+
+// normalizeHTML removes the insignificant differences of the HTML outputs.
+func normalizeHTML(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.Replace(s, "> ", ">", -1)
+	s = strings.Replace(s, " <", "<", -1)
+	return s
+}
+
+func readKnownFailures(file string) (map[string]bool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && line[0] != '#' {
+			res[line] = true
+		}
+	}
+	return res, nil
+}
+
+func TestHTMLTests(t *testing.T) {
+	tests, err := readParserTests("testdata/htmlTests.txt")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	known, err := readKnownFailures("testdata/htmlTests.known-failures")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	opts := &HTMLOptions{
+		PageExists: func(title string) bool {
+			_, ok := tests.articles[title]
+			return ok
+		},
+	}
+
+	var passed, failed, skipped int
+	for _, test := range tests.tests {
+		if reason := test.skip(); reason != "" {
+			skipped++
+			t.Logf("SKIP %s (%s)", test.name, reason)
+			continue
+		}
+
+		var b bytes.Buffer
+		ent, err := ParseWithOptions([]byte(test.sections["wikitext"]), &ParseOptions{ Paragraphs: true })
+		if err == nil {
+			err = RenderHTML(&b, ent, opts)
+		}
+		expect, got := normalizeHTML(test.sections["html"]), normalizeHTML(b.String())
+		ok := err == nil && expect == got
+		if ok {
+			passed++
+			t.Logf("PASS %s", test.name)
+		} else {
+			failed++
+			t.Logf("FAIL %s", test.name)
+		}
+
+		switch {
+		case ok && known[test.name]:
+			t.Errorf("htmlTests.txt:%d: %q passes, remove it from the known failures", test.line, test.name)
+		case !ok && !known[test.name] && err != nil:
+			t.Errorf("htmlTests.txt:%d: %q: %v", test.line, test.name, err)
+		case !ok && !known[test.name]:
+			t.Errorf("htmlTests.txt:%d: %q:\nexpect: %s\n   got: %s", test.line, test.name, expect, got)
+		}
+	}
+	t.Logf("%d passed, %d failed, %d skipped", passed, failed, skipped)
+}
@@ -0,0 +1,199 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The letters right after an internal link are the link trail, which is a
+// part of the link label, e.g. [[bus]]es is a link to "Bus" labeled "buses".
+// The trail is absorbed into the link as a WikiEntityLinkInternalTrail child
+// after scanning, the letters of a trail are of the language of the wiki,
+// see LinkTrails.
+//
+// The pipe trick is a link with an empty label, e.g. [[Help:Contents|]],
+// the label is the title without the namespace and the disambiguation, e.g.
+// "Contents". PreSaveTransform writes the labels into the wikitext the same
+// way as the pre-save transform of MediaWiki.
+
+// LinkTrails are the link trails of the languages, the linkTrail of
+// MediaWiki's Messages*.php. A link trail must match at the beginning of the
+// text after a link, languages without link trails match nothing, e.g. ja.
+var LinkTrails = map[string]*regexp.Regexp{
+	"en": regexp.MustCompile(`^[a-z]+`),
+	"de": regexp.MustCompile(`^[äöüßa-z]+`),
+	"es": regexp.MustCompile(`^[a-záéíóúñ]+`),
+	"fr": regexp.MustCompile(`^[a-zàâçéèêîôûäëïöüùÇÉÂÊÎÔÛÄËÏÖÜÀÈÙ]+`),
+	"it": regexp.MustCompile(`^[a-zàéèíîìóòúù]+`),
+	"nl": regexp.MustCompile(`^[a-zäöüïëéèà]+`),
+	"pl": regexp.MustCompile(`^[a-zęóąśłżźćńĘÓĄŚŁŻŹĆŃ]+`),
+	"pt": regexp.MustCompile(`^[áâãàéêẽçíòóôõq̃úüűũa-z]+`),
+	"ru": regexp.MustCompile(`^[a-zабвгдеёжзийклмнопрстуфхцчшщъыьэюя]+`),
+	"sv": regexp.MustCompile(`^[a-zåäöéÅÄÖÉ]+`),
+	"ja": regexp.MustCompile(`^()`),
+	"zh": regexp.MustCompile(`^()`),
+}
+
+// trailSize returns the size of the link trail at the beginning of s.
+func trailSize(s []byte, trail *regexp.Regexp) int {
+	if trail == nil || len(s) == 0 {
+		return 0
+	}
+	if m := trail.FindIndex(s); m != nil && m[0] == 0 {
+		return m[1]
+	}
+	return 0
+}
+
+// hasTrail tells if the internal link e takes a link trail, the links of
+// categories and files don't.
+func hasTrail(e *Entity) bool {
+	if e.Type != WikiEntityLinkInternal || linkTrail(e) != nil {
+		return false
+	}
+	name, _ := linkParts(e)
+	if i := strings.IndexByte(name, ':'); 0 < i {
+		switch strings.ToLower(strings.TrimSpace(name[0:i])) {
+		case "category", "file", "image", "media":
+			return false
+		}
+	}
+	return true
+}
+
+// linkTrail returns the trail of the internal link e, or nil.
+func linkTrail(e *Entity) *Entity {
+	for _, child := range e.Entities {
+		if child.Type == WikiEntityLinkInternalTrail {
+			return child
+		}
+	}
+	return nil
+}
+
+// addTrail makes raw the Raw of the internal link e, which is the Raw of e
+// followed by the trail of n bytes.
+func addTrail(e *Entity, raw []byte, n int) {
+	e.Raw = raw
+	e.Entities = append(e.Entities, &Entity{
+		Type: WikiEntityLinkInternalTrail,
+		Pos: len(raw) - n,
+		Raw: raw[len(raw)-n:],
+		Text: string(raw[len(raw)-n:]),
+	})
+}
+
+// linkTrails absorbs the link trails in the tree of e.
+func linkTrails(e *Entity, trail *regexp.Regexp) {
+	_, end := innerRange(e)
+	for i, child := range e.Entities {
+		linkTrails(child, trail)
+		off := rawOffset(e, child)
+		if off < 0 || !hasTrail(child) {
+			continue
+		}
+		a, b := off + len(child.Raw), end
+		if i + 1 < len(e.Entities) {
+			if next := rawOffset(e, e.Entities[i+1]); a <= next && next < b {
+				b = next
+			}
+		}
+		if a < b {
+			if n := trailSize(e.Raw[a:b], trail); 0 < n {
+				addTrail(child, e.Raw[off:a+n], n)
+			}
+		}
+	}
+}
+
+// lineTrails absorbs the link trails of the internal links in the line of
+// top-level entities, which are the beginning of the texts after them.
+func lineTrails(line []*Entity, trail *regexp.Regexp) []*Entity {
+	res := line[0:0]
+	for i := 0; i < len(line); i++ {
+		e := line[i]
+		res = append(res, e)
+		if i + 1 == len(line) || !hasTrail(e) {
+			continue
+		}
+		t := line[i+1]
+		if t.Type != WikiEntityText || t.Pos != e.Pos + len(e.Raw) {
+			continue
+		}
+		n := trailSize(t.Raw, trail)
+		if n == 0 {
+			continue
+		}
+		if a, b := e.Raw, t.Raw; cap(b) == cap(a) - len(a) && &a[0:cap(a)][cap(a)-1] == &b[0:cap(b)][cap(b)-1] {
+			addTrail(e, a[0:len(a)+n], n)
+		} else {
+			raw := append(append([]byte(nil), a...), b[0:n]...)
+			remapRaw(e, a, raw[0:len(a)])
+			addTrail(e, raw, n)
+		}
+		if t.Raw = t.Raw[n:]; len(t.Raw) == 0 {
+			i++ // the whole text is the trail
+		} else {
+			t.Pos += n
+			t.Text = string(t.Raw)
+		}
+	}
+	return res
+}
+
+// pipeTrickRegexp matches the page name of a pipe trick, the label is the
+// second submatch, the pstPass2 of MediaWiki's Parser.php.
+var pipeTrickRegexp = regexp.MustCompile(`^(:?[ _0-9A-Za-z\x{80}-\x{10FFFF}-]+:|:|)(.+?)( ?\(.+\)|（.+）|)((?:, |，).+|)$`)
+
+// isPipeTrick tells if the internal link e has an empty label and no other
+// properties, e.g. [[Help:Contents|]].
+func isPipeTrick(e *Entity) bool {
+	props := 0
+	var prop *Entity
+	for _, child := range e.Entities {
+		if child.Type == WikiEntityLinkInternalProp {
+			props, prop = props + 1, child
+		}
+	}
+	return props == 1 && string(prop.Raw) == "|"
+}
+
+// pipeTrickLabel returns the label of the pipe trick of the page name, e.g.
+// "Contents" of "Help:Contents", "Boston" of "Boston, Massachusetts".
+func pipeTrickLabel(name string) string {
+	if m := pipeTrickRegexp.FindStringSubmatch(name); m != nil {
+		return m[2]
+	}
+	return name
+}
+
+// PreSaveTransform returns the wikitext data with the labels of the pipe
+// tricks written, e.g. [[Help:Contents|Contents]] of [[Help:Contents|]].
+// The data is parsed with opts, e.g. the Site of it, opts may be nil.
+func PreSaveTransform(data []byte, opts *ParseOptions) ([]byte, error) {
+	wiki, err := ParseWithOptions(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	var res []byte
+	at := 0
+	var walk func(e *Entity)
+	walk = func(e *Entity) {
+		for _, child := range e.Entities {
+			walk(child)
+		}
+		if e.Type != WikiEntityLinkInternal || !isPipeTrick(e) {
+			return
+		}
+		name, prop := linkParts(e)
+		if off := cap(data) - cap(prop.Raw) + len(prop.Raw); at <= off && off <= len(data) {
+			res = append(append(res, data[at:off]...), pipeTrickLabel(name)...)
+			at = off
+		}
+	}
+	walk(wiki)
+	return append(res, data[at:]...), nil
+}
@@ -0,0 +1,115 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// trailsOf returns the Raw of the internal links in the tree of e, with the
+// trails in parentheses.
+func trailsOf(e *Entity) (res []string) {
+	for _, child := range e.Entities {
+		if child.Type == WikiEntityLinkInternal {
+			s := string(child.Raw)
+			if trail := linkTrail(child); trail != nil {
+				s += "(" + trail.Text + ")"
+			}
+			res = append(res, s)
+		}
+		res = append(res, trailsOf(child)...)
+	}
+	return
+}
+
+func TestLinkTrails(t *testing.T) {
+	tests := []struct{
+		src string
+		links []string
+	}{
+		{ `[[bus]]es`, []string{ `[[bus]]es(es)` } },
+		{ `[[Foo]]s and [[foo]]bar.`, []string{ `[[Foo]]s(s)`, `[[foo]]bar(bar)` } },
+		{ `[[a]]b[[c]]D [[e]] f`, []string{ `[[a]]b(b)`, `[[c]]` , `[[e]]` } },
+		{ `[[a|b]]c''d''`, []string{ `[[a|b]]c(c)` } },
+		{ `'''[[a]]b''' ''[[c]]d''`, []string{ `[[a]]b(b)`, `[[c]]d(d)` } },
+		{ "* [[a]]b\n== [[c]]d ==", []string{ `[[a]]b(b)`, `[[c]]d(d)` } },
+		{ `[[Category:A]]b [[File:C.png]]d [[:Category:E]]f`, []string{ `[[Category:A]]`, `[[File:C.png]]`, `[[:Category:E]]f(f)` } },
+		{ `[[a|[[b]]c]]d`, []string{ `[[a|[[b]]c]]d(d)`, `[[b]]c(c)` } },
+		{ `[[a]]über`, []string{ `[[a]]` } },
+	}
+	for i, test := range tests {
+		data := []byte(test.src)
+		wiki, err := Parse(data)
+		if err != nil {
+			t.Errorf("TestLinkTrails: [%d] %v", i, err)
+			continue
+		}
+		if links := trailsOf(wiki); fmt.Sprintf("%q", links) != fmt.Sprintf("%q", test.links) {
+			t.Errorf("TestLinkTrails: [%d] expect %q, got %q", i, test.links, links)
+		}
+		checkEntityRanges(t, data, wiki)
+
+		res, err := ParseReader(iotest.OneByteReader(strings.NewReader(test.src)), &ParseOptions{ ChunkSize: 1, CopyRaw: true })
+		if err != nil {
+			t.Errorf("TestLinkTrails: [%d] ParseReader: %v", i, err)
+			continue
+		}
+		sameEntity(t, fmt.Sprintf("TestLinkTrails: [%d]", i), wiki, res)
+	}
+
+	wiki, err := ParseWithOptions([]byte(`[[a]]über [[b]]c`), &ParseOptions{ LinkTrail: LinkTrails["de"] })
+	if err != nil {
+		t.Fatalf("TestLinkTrails: %v", err)
+	}
+	if links, expect := trailsOf(wiki), []string{ `[[a]]über(über)`, `[[b]]c(c)` }; fmt.Sprintf("%q", links) != fmt.Sprintf("%q", expect) {
+		t.Errorf("TestLinkTrails: de: expect %q, got %q", expect, links)
+	}
+	wiki, err = ParseWithOptions([]byte(`[[a]]b`), &ParseOptions{ LinkTrail: LinkTrails["ja"] })
+	if err != nil {
+		t.Fatalf("TestLinkTrails: %v", err)
+	}
+	if links, expect := trailsOf(wiki), []string{ `[[a]]` }; fmt.Sprintf("%q", links) != fmt.Sprintf("%q", expect) {
+		t.Errorf("TestLinkTrails: ja: expect %q, got %q", expect, links)
+	}
+}
+
+func TestPipeTrick(t *testing.T) {
+	labels := []struct{
+		name, label string
+	}{
+		{ `Help:Contents`, `Contents` },
+		{ `Pipe (computing)`, `Pipe` },
+		{ `Boston, Massachusetts`, `Boston` },
+		{ `Wikipedia:Manual of Style (links)`, `Manual of Style` },
+		{ `:Category:Foo`, `Foo` },
+		{ `w:en:Foo`, `en:Foo` },
+		{ `Foo`, `Foo` },
+	}
+	for i, test := range labels {
+		if s := pipeTrickLabel(test.name); s != test.label {
+			t.Errorf("TestPipeTrick: [%d] expect %q, got %q", i, test.label, s)
+		}
+	}
+
+	tests := []struct{
+		src, res string
+	}{
+		{ `[[Help:Contents|]]`, `[[Help:Contents|Contents]]` },
+		{ `a [[b (c)|]]s, '''[[d, e|]]''' [[f| ]] [[g|h|]]`, `a [[b (c)|b]]s, '''[[d, e|d]]''' [[f| ]] [[g|h|]]` },
+		{ "== [[a:b|]] ==\n* [[c|[[d:e|]]]]", "== [[a:b|b]] ==\n* [[c|[[d:e|e]]]]" },
+	}
+	for i, test := range tests {
+		res, err := PreSaveTransform([]byte(test.src), nil)
+		if err != nil {
+			t.Errorf("TestPipeTrick: [%d] %v", i, err)
+			continue
+		}
+		if string(res) != test.res {
+			t.Errorf("TestPipeTrick: [%d] expect %q, got %q", i, test.res, res)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The bare URLs (free external links) and the magic links are made after
+// scanning, in the texts out of the links, templates and tags, the same way
+// as doMagicLinks of MediaWiki's Parser.php:
+//
+//	http://www.example.org		WikiEntityLinkURL
+//	ISBN 978-0-306-40615-7		WikiEntityLinkISBN
+//	RFC 822				WikiEntityLinkRFC
+//	PMID 1234			WikiEntityLinkPMID
+//
+// The punctuations at the end of a bare URL, e.g. the period of a sentence,
+// are not a part of the URL. The behavior switches (see MagicWords) and the
+// character references are made in the texts the same way.
+
+// DefaultProtocols is the default ParseOptions.Protocols, the $wgUrlProtocols
+// of MediaWiki. The protocol-relative "//" is only for external links in
+// brackets, it's not recognized in bare URLs.
+var DefaultProtocols = []string{
+	"bitcoin:", "ftp://", "ftps://", "geo:", "git://", "gopher://",
+	"http://", "https://", "irc://", "ircs://", "magnet:", "mailto:",
+	"matrix:", "mms://", "news:", "nntp://", "redis://", "sftp://", "sip:",
+	"sips:", "sms:", "ssh://", "svn://", "tel:", "telnet://", "urn:",
+	"worldwind://", "xmpp:", "//",
+}
+
+// urlChars is the characters of URLs, the EXT_LINK_URL_CLASS of MediaWiki.
+const urlChars = `[^\]\[<>"\x00-\x20\x7F\p{Zs}\x{FFFD}]`
+
+// magicSpace is a space between the name and the number of a magic link.
+const magicSpace = `(?:[ \t\p{Zs}]|&nbsp;|&#0*160;|&#[xX]0*[aA]0;)`
+
+// magicLinkRegexp matches the magic links, the name and the id of RFC and
+// PMID, or the number of ISBN.
+var magicLinkRegexp = regexp.MustCompile(`\b(?:(?P<name>RFC|PMID)` + magicSpace + `+(?P<id>[0-9]+)\b|ISBN` + magicSpace + `+(?P<isbn>(?:97[89](?:-|` + magicSpace + `)?)?(?:[0-9](?:-|` + magicSpace + `)?){9}[0-9Xx])\b)`)
+
+// the groups of magicLinkRegexp
+var (
+	magicLinkName = magicLinkRegexp.SubexpIndex("name")
+	magicLinkID = magicLinkRegexp.SubexpIndex("id")
+	magicLinkISBN = magicLinkRegexp.SubexpIndex("isbn")
+)
+
+var defaultURLRegexp = urlRegexp(DefaultProtocols)
+
+// urlRegexp returns the regexp of the bare URLs of the protocols, of which
+// the group "protocol" is the protocol, or nil if there are none.
+func urlRegexp(protocols []string) *regexp.Regexp {
+	var prots []string
+	for _, s := range protocols {
+		if s != "" && s != "//" {
+			prots = append(prots, regexp.QuoteMeta(s))
+		}
+	}
+	if len(prots) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(?P<protocol>` + strings.Join(prots, "|") + `)(?:\[[0-9a-fA-F:.]+\]|` + urlChars + `)` + urlChars + `*`)
+}
+
+var entityTailRegexp = regexp.MustCompile(`&(?:[A-Za-z0-9]+|#x[0-9A-Fa-f]+|#[0-9]+)$`)
+
+var ltgtRegexp = regexp.MustCompile(`&(?:lt|gt);`)
+
+// trimURL returns the size of the bare URL u without the punctuations at the
+// end, the ')' is kept if there's a '(' in the URL, and the ';' of an entity
+// reference, e.g. &amp;. The URL stops at &lt; and &gt;.
+func trimURL(u []byte) int {
+	if m := ltgtRegexp.FindIndex(u); m != nil {
+		u = u[0:m[0]]
+	}
+	sep := ",;.:!?"
+	if bytes.IndexByte(u, '(') < 0 {
+		sep += ")"
+	}
+	n := len(u)
+	for 0 < n && strings.IndexByte(sep, u[n-1]) >= 0 {
+		n--
+	}
+	if n < len(u) && u[n] == ';' && entityTailRegexp.Match(u[0:n]) {
+		n++
+	}
+	return n
+}
+
+// linker makes the bare URLs, the magic links and the magic words of the
+// top-level entities before passing them to fn.
+type linker struct {
+	fn func(e *Entity) error
+	urls *regexp.Regexp // nil if bare URLs are disabled
+	words *magicWords
+	skip string // the tag of the text not linked, e.g. nowiki
+}
+
+// noLinkTags are the tags of which the content is not linked.
+var noLinkTags = map[string]bool{ "nowiki": true, "pre": true }
+
+// tag starts or ends the text not linked if e is a tag of noLinkTags.
+func (l *linker) tag(e *Entity) {
+	switch e.Type {
+	case WikiEntityTagBeg:
+		if name, _ := tagName(e.Text); l.skip == "" && noLinkTags[name] {
+			l.skip = name
+		}
+	case WikiEntityTagEnd:
+		if name, _ := tagName(strings.TrimPrefix(e.Text, "/")); name == l.skip {
+			l.skip = ""
+		}
+	}
+}
+
+func (l *linker) entity(e *Entity) error {
+	l.tag(e)
+	if e.Type != WikiEntityText {
+		if l.skip == "" {
+			l.words.templates(e)
+			l.links(e, false)
+		}
+		return l.fn(e)
+	}
+
+	c := &Entity{ Type: WikiEntityWiki, Pos: e.Pos, Raw: e.Raw }
+	if !l.add(c, 0, len(c.Raw), false) {
+		return l.fn(e)
+	}
+	for _, child := range c.Entities {
+		child.Pos += c.Pos
+	}
+	for _, e := range pieces(nil, c, []*Entity{ e }, 0, len(c.Raw)) {
+		if err := l.fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// links makes the bare URLs, the magic links and the character references in
+// the tree of e, only the character references if refsOnly is true, e.g. in
+// the labels of links.
+func (l *linker) links(e *Entity, refsOnly bool) {
+	switch e.Type {
+	case WikiEntityText, WikiEntityTemplate, WikiEntityTag, WikiEntityTagBeg, WikiEntityTagEnd,
+		WikiEntityLinkURL, WikiEntityLinkISBN, WikiEntityLinkRFC, WikiEntityLinkPMID,
+		WikiEntityBehaviorSwitch, WikiEntityMagicWord, WikiEntityCharRef,
+		WikiEntityLinkInternalName, WikiEntityLinkExternalURL, WikiEntityLinkInternalTrail:
+		return
+	case WikiEntityLinkInternal, WikiEntityLinkExternal:
+		refsOnly = true
+	}
+	for _, child := range e.Entities {
+		l.links(child, refsOnly)
+	}
+	a, b := innerRange(e)
+	l.add(e, a, b, refsOnly)
+}
+
+// add adds the links in the texts of e.Raw[a:b] to the children of e, it
+// returns false if nothing is found.
+func (l *linker) add(e *Entity, a, b int, refsOnly bool) bool {
+	var found []*Entity
+	skip := l.skip
+	segments(e, a, b, func(text []byte, child *Entity) {
+		if child != nil {
+			l.tag(child)
+		} else {
+			found = l.find(found, e, cap(e.Raw) - cap(text), len(text), refsOnly || l.skip != "")
+		}
+	})
+	l.skip = skip // the tags not closed in e
+	if len(found) == 0 {
+		return false
+	}
+	e.Entities = append(e.Entities, found...)
+	sort.SliceStable(e.Entities, func(i, j int) bool {
+		return rawOffset(e, e.Entities[i]) < rawOffset(e, e.Entities[j])
+	})
+	return true
+}
+
+// magicMatch is a match of the text of linker.find, t is the type of the
+// entity, m is the indexes of the submatches of the regexp of t.
+type magicMatch struct {
+	t EntityType
+	m []int
+}
+
+// find appends the links, the behavior switches and the character
+// references found in e.Raw[off:off+n] to res, only the character references
+// if refsOnly is true.
+func (l *linker) find(res []*Entity, e *Entity, off, n int, refsOnly bool) []*Entity {
+	text := e.Raw[off:off+n]
+	var found []magicMatch
+	add := func(t EntityType, matches [][]int) {
+		for _, m := range matches {
+			found = append(found, magicMatch{ t, m })
+		}
+	}
+	if bytes.IndexByte(text, '&') >= 0 {
+		for _, m := range charRefRegexp.FindAllIndex(text, -1) {
+			if _, ok := decodeCharRef(text[m[0]:m[1]]); ok {
+				add(WikiEntityCharRef, [][]int{ m })
+			}
+		}
+	}
+	if !refsOnly {
+		if l.urls != nil && bytes.IndexByte(text, ':') >= 0 {
+			add(WikiEntityLinkURL, l.urls.FindAllSubmatchIndex(text, -1))
+		}
+		if bytes.Contains(text, []byte("ISBN")) || bytes.Contains(text, []byte("RFC")) || bytes.Contains(text, []byte("PMID")) {
+			add(WikiEntityLinkISBN, magicLinkRegexp.FindAllSubmatchIndex(text, -1))
+		}
+		if l.words != nil {
+			add(WikiEntityBehaviorSwitch, l.words.find(text))
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].m[0] < found[j].m[0] })
+	at := 0
+	for _, f := range found {
+		m := f.m
+		if m[0] < at {
+			continue // overlapped
+		}
+
+		ent := &Entity{ Type: f.t, Pos: off + m[0], Raw: e.Raw[off+m[0]:off+m[1]] }
+		switch f.t {
+		case WikiEntityCharRef:
+			ent.Text, _ = decodeCharRef(ent.Raw)
+		case WikiEntityBehaviorSwitch:
+			ent.Text = l.words.switchID(ent.Raw)
+		case WikiEntityLinkURL:
+			end := m[0] + trimURL(text[m[0]:m[1]])
+			if p := 2*l.urls.SubexpIndex("protocol"); end <= m[p+1] {
+				continue // the protocol only
+			}
+			ent.Raw = e.Raw[off+m[0]:off+end]
+			ent.Text = string(ent.Raw)
+		case WikiEntityLinkISBN: // or RFC, PMID
+			if name := 2*magicLinkName; 0 <= m[name] {
+				if string(text[m[name]:m[name+1]]) == "RFC" {
+					ent.Type = WikiEntityLinkRFC
+				} else {
+					ent.Type = WikiEntityLinkPMID
+				}
+				ent.Text = string(text[m[2*magicLinkID]:m[2*magicLinkID+1]])
+			} else {
+				ent.Text = string(text[m[2*magicLinkISBN]:m[2*magicLinkISBN+1]])
+			}
+		}
+		res = append(res, ent)
+		at = ent.Pos - off + len(ent.Raw)
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// linksOf returns the Type{Raw} Text of the links in the tree of e.
+func linksOf(e *Entity) (res []string) {
+	for _, child := range e.Entities {
+		switch child.Type {
+		case WikiEntityLinkURL, WikiEntityLinkISBN, WikiEntityLinkRFC, WikiEntityLinkPMID:
+			res = append(res, fmt.Sprintf("%v %s", child, child.Text))
+		}
+		res = append(res, linksOf(child)...)
+	}
+	return
+}
+
+func TestMagicLinks(t *testing.T) {
+	tests := []struct{
+		src string
+		links []string
+	}{
+		{ `http://example.com`, []string{ `WikiEntityLinkURL{http://example.com} http://example.com` } },
+		{ `See http://example.com/foo.`, []string{ `WikiEntityLinkURL{http://example.com/foo} http://example.com/foo` } },
+		{ `(http://a.b/c), http://a.b/(c)!`, []string{
+			`WikiEntityLinkURL{http://a.b/c} http://a.b/c`,
+			`WikiEntityLinkURL{http://a.b/(c)} http://a.b/(c)`,
+		} },
+		{ `http://a.b/?x&amp; http://a.b/?x;`, []string{
+			`WikiEntityLinkURL{http://a.b/?x&amp;} http://a.b/?x&amp;`,
+			`WikiEntityLinkURL{http://a.b/?x} http://a.b/?x`,
+		} },
+		{ `http://a.b/c&lt;d http://a.b.&gt;`, []string{
+			`WikiEntityLinkURL{http://a.b/c} http://a.b/c`,
+			`WikiEntityLinkURL{http://a.b} http://a.b`,
+		} },
+		{ `xhttp://a.b http:// mailto:a@b.c HTTPS://A.B<br>`, []string{
+			`WikiEntityLinkURL{mailto:a@b.c} mailto:a@b.c`,
+			`WikiEntityLinkURL{HTTPS://A.B} HTTPS://A.B`,
+		} },
+		{ `//a.b [http://a.b] [[http://a.b]] {{t|http://a.b}}`, nil },
+		{ `<nowiki>http://a.b</nowiki> http://c.d`, []string{ `WikiEntityLinkURL{http://c.d} http://c.d` } },
+		{ `'''http://a.b''' ''x ISBN 0-306-40615-2''`, []string{
+			`WikiEntityLinkURL{http://a.b} http://a.b`,
+			`WikiEntityLinkISBN{ISBN 0-306-40615-2} 0-306-40615-2`,
+		} },
+		{ "* RFC 822\n== PMID 1234 ==", []string{
+			`WikiEntityLinkRFC{RFC 822} 822`,
+			`WikiEntityLinkPMID{PMID 1234} 1234`,
+		} },
+		{ `ISBN 978-0-306-40615-7 ISBN 978 0 306 40615 7 ISBN 123 xISBN 0306406152 rfc 822`, []string{
+			`WikiEntityLinkISBN{ISBN 978-0-306-40615-7} 978-0-306-40615-7`,
+			`WikiEntityLinkISBN{ISBN 978 0 306 40615 7} 978 0 306 40615 7`,
+		} },
+		{ `[[a|http://a.b]] http://a.b/ISBN 0306406152`, []string{
+			`WikiEntityLinkURL{http://a.b/ISBN} http://a.b/ISBN`,
+		} },
+	}
+	for i, test := range tests {
+		data := []byte(test.src)
+		wiki, err := Parse(data)
+		if err != nil {
+			t.Errorf("TestMagicLinks: [%d] %v", i, err)
+			continue
+		}
+		if links := linksOf(wiki); fmt.Sprintf("%q", links) != fmt.Sprintf("%q", test.links) {
+			t.Errorf("TestMagicLinks: [%d] expect %q, got %q", i, test.links, links)
+		}
+		checkEntityRanges(t, data, wiki)
+
+		res, err := ParseReader(iotest.OneByteReader(strings.NewReader(test.src)), &ParseOptions{ ChunkSize: 1, CopyRaw: true })
+		if err != nil {
+			t.Errorf("TestMagicLinks: [%d] ParseReader: %v", i, err)
+			continue
+		}
+		sameEntity(t, fmt.Sprintf("TestMagicLinks: [%d]", i), wiki, res)
+	}
+}
+
+func TestMagicLinksProtocols(t *testing.T) {
+	src := `http://a.b ftp://c.d RFC 1`
+	tests := []struct{
+		protocols []string
+		links []string
+	}{
+		{ nil, []string{ `WikiEntityLinkURL{http://a.b} http://a.b`, `WikiEntityLinkURL{ftp://c.d} ftp://c.d`, `WikiEntityLinkRFC{RFC 1} 1` } },
+		{ []string{ "ftp://" }, []string{ `WikiEntityLinkURL{ftp://c.d} ftp://c.d`, `WikiEntityLinkRFC{RFC 1} 1` } },
+		{ []string{}, []string{ `WikiEntityLinkRFC{RFC 1} 1` } },
+	}
+	for i, test := range tests {
+		wiki, err := ParseWithOptions([]byte(src), &ParseOptions{ Protocols: test.protocols })
+		if err != nil {
+			t.Errorf("TestMagicLinksProtocols: [%d] %v", i, err)
+			continue
+		}
+		if links := linksOf(wiki); fmt.Sprintf("%q", links) != fmt.Sprintf("%q", test.links) {
+			t.Errorf("TestMagicLinksProtocols: [%d] expect %q, got %q", i, test.links, links)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The magic words are made after scanning, the same way as MediaWiki's
+// MagicWord.php:
+//
+//	__NOTOC__			WikiEntityBehaviorSwitch
+//	{{PAGENAME}}			WikiEntityMagicWord (variable)
+//	{{DEFAULTSORT:Sort key}}	WikiEntityMagicWord (parser function)
+//
+// The Text of them is the ID of the magic word, e.g. "NOTOC" of __NOTOC__
+// and of __KEIN_INHALTSVERZEICHNIS__ (de). A magic word in braces is a
+// template turned into WikiEntityMagicWord, of which the children are still
+// the name and the properties of the template. The behavior switches are
+// case-insensitive, the others are not.
+//
+// The behavior switches and the parser functions are the properties of the
+// page (see PageProperties), they're not rendered in place. The variables
+// are evaluated by PageContext.
+
+// MagicWords are the aliases of the magic words of the languages by the IDs,
+// the magicWords of MediaWiki's Messages*.php. The aliases of the behavior
+// switches are in underscores, e.g. "__NOTOC__", the aliases of the parser
+// functions end with a colon, e.g. "DEFAULTSORT:". The "REDIRECT" is the
+// redirect of a redirect page, e.g. "#REDIRECT".
+var MagicWords = map[string]map[string][]string{
+	"en": {
+		"REDIRECT": {"#REDIRECT"},
+
+		"NOTOC": {"__NOTOC__"},
+		"FORCETOC": {"__FORCETOC__"},
+		"TOC": {"__TOC__"},
+		"NOEDITSECTION": {"__NOEDITSECTION__"},
+		"NEWSECTIONLINK": {"__NEWSECTIONLINK__"},
+		"NONEWSECTIONLINK": {"__NONEWSECTIONLINK__"},
+		"NOGALLERY": {"__NOGALLERY__"},
+		"HIDDENCAT": {"__HIDDENCAT__"},
+		"EXPECTUNUSEDCATEGORY": {"__EXPECTUNUSEDCATEGORY__"},
+		"NOCONTENTCONVERT": {"__NOCONTENTCONVERT__", "__NOCC__"},
+		"NOTITLECONVERT": {"__NOTITLECONVERT__", "__NOTC__"},
+		"INDEX": {"__INDEX__"},
+		"NOINDEX": {"__NOINDEX__"},
+		"STATICREDIRECT": {"__STATICREDIRECT__"},
+
+		"CURRENTYEAR": {"CURRENTYEAR"},
+		"CURRENTMONTH": {"CURRENTMONTH", "CURRENTMONTH2"},
+		"CURRENTMONTH1": {"CURRENTMONTH1"},
+		"CURRENTMONTHNAME": {"CURRENTMONTHNAME"},
+		"CURRENTMONTHNAMEGEN": {"CURRENTMONTHNAMEGEN"},
+		"CURRENTMONTHABBREV": {"CURRENTMONTHABBREV"},
+		"CURRENTDAY": {"CURRENTDAY"},
+		"CURRENTDAY2": {"CURRENTDAY2"},
+		"CURRENTDAYNAME": {"CURRENTDAYNAME"},
+		"CURRENTDOW": {"CURRENTDOW"},
+		"CURRENTWEEK": {"CURRENTWEEK"},
+		"CURRENTTIME": {"CURRENTTIME"},
+		"CURRENTHOUR": {"CURRENTHOUR"},
+		"CURRENTTIMESTAMP": {"CURRENTTIMESTAMP"},
+		"LOCALYEAR": {"LOCALYEAR"},
+		"LOCALMONTH": {"LOCALMONTH", "LOCALMONTH2"},
+		"LOCALMONTH1": {"LOCALMONTH1"},
+		"LOCALMONTHNAME": {"LOCALMONTHNAME"},
+		"LOCALMONTHNAMEGEN": {"LOCALMONTHNAMEGEN"},
+		"LOCALMONTHABBREV": {"LOCALMONTHABBREV"},
+		"LOCALDAY": {"LOCALDAY"},
+		"LOCALDAY2": {"LOCALDAY2"},
+		"LOCALDAYNAME": {"LOCALDAYNAME"},
+		"LOCALDOW": {"LOCALDOW"},
+		"LOCALWEEK": {"LOCALWEEK"},
+		"LOCALTIME": {"LOCALTIME"},
+		"LOCALHOUR": {"LOCALHOUR"},
+		"LOCALTIMESTAMP": {"LOCALTIMESTAMP"},
+		"SITENAME": {"SITENAME"},
+		"SERVER": {"SERVER"},
+		"SERVERNAME": {"SERVERNAME"},
+		"PAGENAME": {"PAGENAME"},
+		"PAGENAMEE": {"PAGENAMEE"},
+		"FULLPAGENAME": {"FULLPAGENAME"},
+		"FULLPAGENAMEE": {"FULLPAGENAMEE"},
+		"BASEPAGENAME": {"BASEPAGENAME"},
+		"BASEPAGENAMEE": {"BASEPAGENAMEE"},
+		"ROOTPAGENAME": {"ROOTPAGENAME"},
+		"ROOTPAGENAMEE": {"ROOTPAGENAMEE"},
+		"SUBPAGENAME": {"SUBPAGENAME"},
+		"SUBPAGENAMEE": {"SUBPAGENAMEE"},
+		"NAMESPACE": {"NAMESPACE"},
+		"NAMESPACEE": {"NAMESPACEE"},
+		"TALKSPACE": {"TALKSPACE"},
+		"TALKSPACEE": {"TALKSPACEE"},
+		"SUBJECTSPACE": {"SUBJECTSPACE", "ARTICLESPACE"},
+		"SUBJECTSPACEE": {"SUBJECTSPACEE", "ARTICLESPACEE"},
+		"TALKPAGENAME": {"TALKPAGENAME"},
+		"TALKPAGENAMEE": {"TALKPAGENAMEE"},
+		"SUBJECTPAGENAME": {"SUBJECTPAGENAME", "ARTICLEPAGENAME"},
+		"SUBJECTPAGENAMEE": {"SUBJECTPAGENAMEE", "ARTICLEPAGENAMEE"},
+
+		"DEFAULTSORT": {"DEFAULTSORT:", "DEFAULTSORTKEY:", "DEFAULTCATEGORYSORT:"},
+		"DISPLAYTITLE": {"DISPLAYTITLE:"},
+	},
+	"de": {
+		"REDIRECT": {"#WEITERLEITUNG"},
+		"NOTOC": {"__KEIN_INHALTSVERZEICHNIS__", "__KEININHALTSVERZEICHNIS__"},
+		"FORCETOC": {"__INHALTSVERZEICHNIS_ERZWINGEN__"},
+		"TOC": {"__INHALTSVERZEICHNIS__"},
+		"NOEDITSECTION": {"__ABSCHNITTE_NICHT_BEARBEITEN__"},
+		"CURRENTYEAR": {"JETZIGES_JAHR"},
+		"CURRENTMONTH": {"JETZIGER_MONAT", "JETZIGER_MONAT_2"},
+		"CURRENTDAY": {"JETZIGER_KALENDERTAG", "JETZIGER_TAG"},
+		"SITENAME": {"PROJEKTNAME"},
+		"PAGENAME": {"SEITENNAME"},
+		"FULLPAGENAME": {"VOLLER_SEITENNAME"},
+		"NAMESPACE": {"NAMENSRAUM"},
+		"DEFAULTSORT": {"SORTIERUNG:"},
+		"DISPLAYTITLE": {"SEITENTITEL:"},
+	},
+	"fr": {
+		"REDIRECT": {"#REDIRECTION"},
+		"NOTOC": {"__AUCUNSOMMAIRE__", "__AUCUNETDM__"},
+		"FORCETOC": {"__FORCERSOMMAIRE__", "__FORCERTDM__"},
+		"TOC": {"__SOMMAIRE__", "__TDM__"},
+		"NOEDITSECTION": {"__SECTIONNONEDITABLE__"},
+		"CURRENTYEAR": {"ANNEEACTUELLE", "ANNÉEACTUELLE"},
+		"CURRENTMONTH": {"MOISACTUEL", "MOISACTUEL2"},
+		"CURRENTDAY": {"JOURACTUEL", "JOURACTUEL1"},
+		"SITENAME": {"NOMSITE"},
+		"PAGENAME": {"NOMPAGE"},
+		"FULLPAGENAME": {"NOMPAGECOMPLET"},
+		"NAMESPACE": {"ESPACENOMMAGE"},
+		"DEFAULTSORT": {"CLEFDETRI:", "CLEDETRI:"},
+		"DISPLAYTITLE": {"AFFICHERTITRE:"},
+	},
+}
+
+// isSwitch tells if the magic word id is a behavior switch.
+func isSwitch(id string) bool {
+	aliases := MagicWords["en"][id]
+	return 0 < len(aliases) && strings.HasPrefix(aliases[0], "__")
+}
+
+// isFunction tells if the magic word id is a parser function.
+func isFunction(id string) bool {
+	aliases := MagicWords["en"][id]
+	return 0 < len(aliases) && strings.HasSuffix(aliases[0], ":")
+}
+
+// isVariable tells if the magic word id is a variable, e.g. "PAGENAME".
+func isVariable(id string) bool {
+	aliases := MagicWords["en"][id]
+	return 0 < len(aliases) && id != "REDIRECT" && !isSwitch(id) && !isFunction(id)
+}
+
+// isPageProp tells if e is a behavior switch or a parser function, which
+// sets a property of the page and is not rendered in place.
+func isPageProp(e *Entity) bool {
+	switch e.Type {
+	case WikiEntityBehaviorSwitch:
+		return true
+	case WikiEntityMagicWord:
+		return isFunction(e.Text)
+	}
+	return false
+}
+
+// magicWords recognizes the magic words of the aliases.
+type magicWords struct {
+	switches *regexp.Regexp
+	redirect *regexp.Regexp // the redirect with the spaces around
+	ids map[string]string // IDs by the aliases, lower-case for switches
+}
+
+var defaultMagicWords = newMagicWords(nil)
+
+// newMagicWords returns the magic words of the English aliases and the
+// localized aliases local.
+func newMagicWords(local map[string][]string) *magicWords {
+	w := &magicWords{ ids: make(map[string]string) }
+	var switches, redirects []string
+	for _, words := range []map[string][]string{ MagicWords["en"], local } {
+		for id, aliases := range words {
+			for _, s := range aliases {
+				switch {
+				case isSwitch(id):
+					s = strings.ToLower(s)
+					switches = append(switches, regexp.QuoteMeta(s))
+				case id == "REDIRECT":
+					redirects = append(redirects, regexp.QuoteMeta(s))
+				}
+				w.ids[s] = id
+			}
+		}
+	}
+	sort.Slice(switches, func(i, j int) bool {
+		if len(switches[i]) != len(switches[j]) {
+			return len(switches[i]) > len(switches[j])
+		}
+		return switches[i] < switches[j]
+	})
+	w.switches = regexp.MustCompile(`(?i)` + strings.Join(switches, "|"))
+	w.redirect = regexp.MustCompile(`(?i)^\s*(?:` + strings.Join(redirects, "|") + `)\s*:?\s*`)
+	return w
+}
+
+// find returns the ranges of the behavior switches in text.
+func (w *magicWords) find(text []byte) [][]int {
+	if bytes.Index(text, []byte("__")) < 0 {
+		return nil
+	}
+	return w.switches.FindAllIndex(text, -1)
+}
+
+// switchID returns the ID of the behavior switch s, e.g. "NOTOC" of
+// "__notoc__".
+func (w *magicWords) switchID(s []byte) string {
+	return w.ids[strings.ToLower(string(s))]
+}
+
+// templates turns the templates of magic words in the tree of e into
+// WikiEntityMagicWord.
+func (w *magicWords) templates(e *Entity) {
+	for _, child := range e.Entities {
+		w.templates(child)
+	}
+	if e.Type != WikiEntityTemplate {
+		return
+	}
+	if id := w.template(e); id != "" {
+		e.Type, e.Text = WikiEntityMagicWord, id
+	}
+}
+
+// template returns the ID of the magic word of the template e, or "". A
+// variable has no properties unless it takes an argument, e.g.
+// {{PAGENAME:Title}}.
+func (w *magicWords) template(e *Entity) string {
+	name, props := templateParts(e)
+	if name == nil {
+		return ""
+	}
+	s := strings.TrimSpace(name.Text)
+	if i := strings.IndexByte(s, ':'); 0 < i {
+		if id := w.ids[strings.TrimSpace(s[0:i]) + ":"]; isFunction(id) {
+			return id
+		}
+		if id := w.ids[strings.TrimSpace(s[0:i])]; isVariable(id) {
+			return id
+		}
+		return ""
+	}
+	if id := w.ids[s]; len(props) == 0 && isVariable(id) {
+		return id
+	}
+	return ""
+}
+
+// templateParts returns the name and the properties of a template.
+func templateParts(e *Entity) (name *Entity, props []*Entity) {
+	for _, child := range e.Entities {
+		switch child.Type {
+		case WikiEntityTemplateName:
+			name = child
+		case WikiEntityTemplateProp:
+			props = append(props, child)
+		}
+	}
+	return
+}
+
+// magicWordArgs returns the argument after the colon of the magic word e and
+// the properties, e.g. "Sort key" and ["noreplace"] of
+// {{DEFAULTSORT:Sort key|noreplace}}.
+func magicWordArgs(e *Entity) (arg string, props []string) {
+	name, ents := templateParts(e)
+	if name != nil {
+		if i := strings.IndexByte(name.Text, ':'); 0 <= i {
+			arg = strings.TrimSpace(name.Text[i+1:])
+		}
+	}
+	for _, prop := range ents {
+		props = append(props, strings.TrimSpace(prop.Text))
+	}
+	return
+}
+
+// PageProps are the properties of a page set by the behavior switches, the
+// parser functions and the redirect.
+type PageProps struct {
+	Switches map[string]bool // IDs of the behavior switches, e.g. "NOTOC"
+	DefaultSort string // the sort key of {{DEFAULTSORT:...}}
+	DisplayTitle string // the wikitext of {{DISPLAYTITLE:...}}
+
+	// The target of a redirect page, e.g. "Target page" and "Section" of
+	// #REDIRECT [[Target page#Section]].
+	Redirect, RedirectFragment string
+}
+
+// PageProperties returns the properties of the page of the tree of wiki. The
+// last of the same parser functions wins, unless it's "noreplace", e.g.
+// {{DEFAULTSORT:Sort key|noreplace}}.
+func PageProperties(wiki *Entity) *PageProps {
+	props := &PageProps{ Switches: make(map[string]bool) }
+	set := func(v *string, e *Entity) {
+		arg, opts := magicWordArgs(e)
+		if *v != "" && 0 < len(opts) && opts[0] == "noreplace" {
+			return
+		}
+		*v = arg
+	}
+	var walk func(e *Entity)
+	walk = func(e *Entity) {
+		switch {
+		case e.Type == WikiEntityRedirect && props.Redirect == "":
+			props.Redirect, props.RedirectFragment = redirectTarget(e, nil)
+		case e.Type == WikiEntityBehaviorSwitch:
+			props.Switches[e.Text] = true
+		case e.Type == WikiEntityMagicWord && e.Text == "DEFAULTSORT":
+			set(&props.DefaultSort, e)
+		case e.Type == WikiEntityMagicWord && e.Text == "DISPLAYTITLE":
+			set(&props.DisplayTitle, e)
+		}
+		for _, child := range e.Entities {
+			walk(child)
+		}
+	}
+	walk(wiki)
+	return props
+}
+
+// DefaultNamespaces are the canonical namespaces of MediaWiki, the default
+// PageContext.Namespaces.
+var DefaultNamespaces = []string{
+	"Media", "Special", "Talk", "User", "User talk", "Project",
+	"Project talk", "File", "File talk", "MediaWiki", "MediaWiki talk",
+	"Template", "Template talk", "Help", "Help talk", "Category",
+	"Category talk",
+}
+
+// PageContext is the page of which the variables are evaluated, e.g.
+// {{PAGENAME}}.
+type PageContext struct {
+	Title string // the full title of the page, e.g. "Help:Magic words"
+	Namespaces []string // the namespaces of titles, DefaultNamespaces if nil
+	SiteName string // {{SITENAME}}
+	Server string // {{SERVER}}, e.g. "https://en.wikipedia.org"
+
+	// Time is the current time, it's time.Now() if zero. The CURRENT*
+	// variables are in UTC, the LOCAL* variables are in the location of
+	// the time.
+	Time time.Time
+}
+
+// Eval returns the value of the variable of the magic word e, it returns
+// false if e is not a variable.
+func (c *PageContext) Eval(e *Entity) (string, bool) {
+	if e.Type != WikiEntityMagicWord || isFunction(e.Text) {
+		return "", false
+	}
+	arg, _ := magicWordArgs(e)
+	return c.Variable(e.Text, arg)
+}
+
+// Variable returns the value of the variable id, e.g. "PAGENAME". The arg
+// is the title of the variables of page names if it's not empty, e.g. "Foo"
+// of {{PAGENAME:Foo}}. It returns false for unknown variables.
+func (c *PageContext) Variable(id, arg string) (string, bool) {
+	switch id {
+	case "SITENAME":
+		return c.SiteName, true
+	case "SERVER":
+		return c.Server, true
+	case "SERVERNAME":
+		s := c.Server
+		if i := strings.Index(s, "//"); 0 <= i {
+			s = s[i+2:]
+		}
+		if i := strings.IndexAny(s, ":/"); 0 <= i {
+			s = s[0:i]
+		}
+		return s, true
+	}
+	if strings.HasPrefix(id, "CURRENT") || strings.HasPrefix(id, "LOCAL") {
+		return c.date(id)
+	}
+	if arg == "" {
+		arg = c.Title
+	}
+	if s, ok := c.title(id, arg); ok {
+		return s, true
+	}
+	if s, ok := c.title(strings.TrimSuffix(id, "E"), arg); ok && strings.HasSuffix(id, "E") {
+		return urlencode(s), true
+	}
+	return "", false
+}
+
+// date returns the value of the variable id of the current time.
+func (c *PageContext) date(id string) (string, bool) {
+	t := c.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	if strings.HasPrefix(id, "CURRENT") {
+		t, id = t.UTC(), id[len("CURRENT"):]
+	} else {
+		id = id[len("LOCAL"):]
+	}
+	switch id {
+	case "YEAR":			return t.Format("2006"), true
+	case "MONTH", "MONTH2":		return t.Format("01"), true
+	case "MONTH1":			return t.Format("1"), true
+	case "MONTHNAME", "MONTHNAMEGEN":	return t.Format("January"), true
+	case "MONTHABBREV":		return t.Format("Jan"), true
+	case "DAY":			return t.Format("2"), true
+	case "DAY2":			return t.Format("02"), true
+	case "DAYNAME":			return t.Format("Monday"), true
+	case "DOW":			return strconv.Itoa(int(t.Weekday())), true
+	case "TIME":			return t.Format("15:04"), true
+	case "HOUR":			return t.Format("15"), true
+	case "TIMESTAMP":		return t.Format("20060102150405"), true
+	case "WEEK":
+		_, week := t.ISOWeek()
+		return strconv.Itoa(week), true
+	}
+	return "", false
+}
+
+// splitTitle returns the namespace and the name of the title, e.g. "Help"
+// and "Magic words" of "help:magic_words".
+func (c *PageContext) splitTitle(title string) (ns, name string) {
+	t, err := ParseTitle(title, &TitleOptions{ Namespaces: c.Namespaces })
+	if err != nil {
+		return "", foldTitle(title)
+	}
+	return t.Namespace, t.Name
+}
+
+// talkSpace returns the talk namespace of the namespace ns, or "" if it has
+// none, e.g. "Special".
+func talkSpace(ns string) string {
+	switch {
+	case ns == "":
+		return "Talk"
+	case ns == "Media", ns == "Special":
+		return ""
+	case ns == "Talk", strings.HasSuffix(ns, " talk"):
+		return ns
+	}
+	return ns + " talk"
+}
+
+// subjectSpace returns the subject namespace of the namespace ns.
+func subjectSpace(ns string) string {
+	if ns == "Talk" {
+		return ""
+	}
+	return strings.TrimSuffix(ns, " talk")
+}
+
+// fullTitle returns the title of the name in the namespace ns.
+func fullTitle(ns, name string) string {
+	if ns == "" {
+		return name
+	}
+	return ns + ":" + name
+}
+
+// title returns the value of the variable id of page names of the title.
+// The main namespace has no subpages.
+func (c *PageContext) title(id, title string) (string, bool) {
+	ns, name := c.splitTitle(title)
+	base, root, sub := name, name, name
+	if ns != "" {
+		if i := strings.LastIndexByte(name, '/'); 0 < i {
+			base, sub = name[0:i], name[i+1:]
+		}
+		if i := strings.IndexByte(name, '/'); 0 < i {
+			root = name[0:i]
+		}
+	}
+	switch id {
+	case "PAGENAME":	return name, true
+	case "FULLPAGENAME":	return fullTitle(ns, name), true
+	case "BASEPAGENAME":	return base, true
+	case "ROOTPAGENAME":	return root, true
+	case "SUBPAGENAME":	return sub, true
+	case "NAMESPACE":	return ns, true
+	case "TALKSPACE":	return talkSpace(ns), true
+	case "SUBJECTSPACE":	return subjectSpace(ns), true
+	case "TALKPAGENAME":
+		if talk := talkSpace(ns); talk != "" {
+			return fullTitle(talk, name), true
+		}
+		return "", true
+	case "SUBJECTPAGENAME":
+		return fullTitle(subjectSpace(ns), name), true
+	}
+	return "", false
+}
@@ -0,0 +1,193 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestMagicWords(t *testing.T) {
+	tests := []struct{
+		src string
+		entities []*entityTestResult
+	}{
+		/***** 0 *****/
+		{`__NOTOC__ a __toc__ b__NOTOC_`,
+			[]*entityTestResult{
+				{WikiEntityBehaviorSwitch, `NOTOC`, []*entityTestResult{}},
+				{WikiEntityText, ` a `, []*entityTestResult{}},
+				{WikiEntityBehaviorSwitch, `TOC`, []*entityTestResult{}},
+				{WikiEntityText, ` b__NOTOC_`, []*entityTestResult{}},
+			}},
+		/***** 1 *****/
+		{`{{PAGENAME}} {{ DEFAULTSORT:a, b|noreplace}} {{PAGENAME|c}} {{pagename}}`,
+			[]*entityTestResult{
+				{WikiEntityMagicWord, `PAGENAME`, []*entityTestResult{
+					{WikiEntityTemplateName, `PAGENAME`, nil},
+				}},
+				{WikiEntityText, ` `, []*entityTestResult{}},
+				{WikiEntityMagicWord, `DEFAULTSORT`, []*entityTestResult{
+					{WikiEntityTemplateName, ` DEFAULTSORT:a, b`, nil},
+					{WikiEntityTemplateProp, `noreplace`, nil},
+				}},
+				{WikiEntityText, ` `, []*entityTestResult{}},
+				{WikiEntityTemplate, `PAGENAME|c`, nil},
+				{WikiEntityText, ` `, []*entityTestResult{}},
+				{WikiEntityTemplate, `pagename`, nil},
+			}},
+		/***** 2 *****/
+		{"== a __NOEDITSECTION__ ==\n* {{b|{{NAMESPACE}}}} <nowiki>__TOC__</nowiki>",
+			[]*entityTestResult{
+				{WikiEntityHeading2, ` a __NOEDITSECTION__ `, []*entityTestResult{
+					{WikiEntityBehaviorSwitch, `NOEDITSECTION`, []*entityTestResult{}},
+					{WikiEntityListBulleted, "* {{b|{{NAMESPACE}}}} <nowiki>__TOC__</nowiki>", []*entityTestResult{
+						{WikiEntityTemplate, `b|{{NAMESPACE}}`, []*entityTestResult{
+							{WikiEntityTemplateName, `b`, nil},
+							{WikiEntityTemplateProp, `{{NAMESPACE}}`, []*entityTestResult{
+								{WikiEntityMagicWord, `NAMESPACE`, nil},
+							}},
+						}},
+						{WikiEntityTagBeg, `nowiki`, nil},
+						{WikiEntityTagEnd, `nowiki`, nil},
+					}},
+				}},
+			}},
+	}
+	for i, tc := range tests {
+		data := []byte(tc.src)
+		wiki, err := Parse(data)
+		if err != nil {
+			t.Errorf("TestMagicWords: [%d] %v", i, err)
+			continue
+		}
+		checkEntityResults(t, i, "TestMagicWords", data, tc.src, wiki, tc.entities, true)
+		checkEntityRanges(t, data, wiki)
+
+		opts := &ParseOptions{ ChunkSize: 1, CopyRaw: true }
+		res, err := ParseReader(iotest.OneByteReader(strings.NewReader(tc.src)), opts)
+		if err != nil {
+			t.Errorf("TestMagicWords: [%d] ParseReader: %v", i, err)
+			continue
+		}
+		sameEntity(t, fmt.Sprintf("TestMagicWords: [%d]", i), wiki, res)
+	}
+
+	wiki, err := ParseWithOptions([]byte(`__KEIN_INHALTSVERZEICHNIS__ __NOTOC__ {{SEITENNAME}} {{SORTIERUNG:a}}`), &ParseOptions{ MagicWords: MagicWords["de"] })
+	if err != nil {
+		t.Fatalf("TestMagicWords: de: %v", err)
+	}
+	var ids []string
+	for _, e := range wiki.Entities {
+		if e.Type == WikiEntityBehaviorSwitch || e.Type == WikiEntityMagicWord {
+			ids = append(ids, e.Text)
+		}
+	}
+	if s, expect := strings.Join(ids, " "), "NOTOC NOTOC PAGENAME DEFAULTSORT"; s != expect {
+		t.Errorf("TestMagicWords: de: expect %q, got %q", expect, s)
+	}
+}
+
+func TestPageProperties(t *testing.T) {
+	tests := []struct{
+		src string
+		switches []string
+		sort, title string
+	}{
+		{ `text`, nil, ``, `` },
+		{ "__NOTOC__\n== a ==\n__NOEDITSECTION__ __notoc__", []string{ "NOEDITSECTION", "NOTOC" }, ``, `` },
+		{ `{{DEFAULTSORT:a}} {{DEFAULTSORT:b}} {{DISPLAYTITLE:''c''}}`, nil, `b`, `''c''` },
+		{ `{{DEFAULTSORTKEY: a }} {{DEFAULTSORT:b|noreplace}}`, nil, `a`, `` },
+	}
+	for i, test := range tests {
+		wiki, err := ParseString(test.src)
+		if err != nil {
+			t.Errorf("TestPageProperties: [%d] %v", i, err)
+			continue
+		}
+		props := PageProperties(wiki)
+		var switches []string
+		for _, id := range []string{ "NOEDITSECTION", "NOTOC", "TOC" } {
+			if props.Switches[id] {
+				switches = append(switches, id)
+			}
+		}
+		if fmt.Sprint(switches) != fmt.Sprint(test.switches) || len(props.Switches) != len(test.switches) {
+			t.Errorf("TestPageProperties: [%d] expect switches %v, got %v", i, test.switches, props.Switches)
+		}
+		if props.DefaultSort != test.sort || props.DisplayTitle != test.title {
+			t.Errorf("TestPageProperties: [%d] expect %q %q, got %q %q", i, test.sort, test.title, props.DefaultSort, props.DisplayTitle)
+		}
+	}
+}
+
+func TestPageContext(t *testing.T) {
+	c := &PageContext{
+		Title: "user_talk:foo bar/baz/qux",
+		SiteName: "Wikipedia",
+		Server: "https://en.wikipedia.org",
+		Time: time.Date(2013, time.March, 5, 21, 7, 9, 0, time.FixedZone("X", 3600 * 5)),
+	}
+	tests := []struct{
+		id, arg, value string
+	}{
+		{ "PAGENAME", "", "Foo bar/baz/qux" },
+		{ "PAGENAMEE", "", "Foo_bar/baz/qux" },
+		{ "FULLPAGENAME", "", "User talk:Foo bar/baz/qux" },
+		{ "BASEPAGENAME", "", "Foo bar/baz" },
+		{ "ROOTPAGENAME", "", "Foo bar" },
+		{ "SUBPAGENAME", "", "qux" },
+		{ "NAMESPACE", "", "User talk" },
+		{ "NAMESPACEE", "", "User_talk" },
+		{ "TALKSPACE", "", "User talk" },
+		{ "SUBJECTSPACE", "", "User" },
+		{ "SUBJECTPAGENAME", "", "User:Foo bar/baz/qux" },
+		{ "PAGENAME", "AC/DC", "AC/DC" },
+		{ "SUBPAGENAME", "AC/DC", "AC/DC" },
+		{ "NAMESPACE", "AC/DC", "" },
+		{ "TALKPAGENAME", "AC/DC", "Talk:AC/DC" },
+		{ "TALKPAGENAME", "Special:X", "" },
+		{ "FULLPAGENAME", "help:a b", "Help:A b" },
+		{ "SITENAME", "", "Wikipedia" },
+		{ "SERVERNAME", "", "en.wikipedia.org" },
+		{ "CURRENTYEAR", "", "2013" },
+		{ "CURRENTMONTH", "", "03" },
+		{ "CURRENTMONTH1", "", "3" },
+		{ "CURRENTMONTHNAME", "", "March" },
+		{ "CURRENTDAY", "", "5" },
+		{ "CURRENTDAY2", "", "05" },
+		{ "CURRENTDAYNAME", "", "Tuesday" },
+		{ "CURRENTDOW", "", "2" },
+		{ "CURRENTTIME", "", "16:07" },
+		{ "CURRENTTIMESTAMP", "", "20130305160709" },
+		{ "LOCALTIME", "", "21:07" },
+		{ "LOCALHOUR", "", "21" },
+		{ "CURRENTWEEK", "", "10" },
+	}
+	for i, test := range tests {
+		if s, ok := c.Variable(test.id, test.arg); !ok || s != test.value {
+			t.Errorf("TestPageContext: [%d] %s: expect %q, got %q (%v)", i, test.id, test.value, s, ok)
+		}
+	}
+	if s, ok := c.Variable("DEFAULTSORT", ""); ok {
+		t.Errorf("TestPageContext: DEFAULTSORT is not a variable, got %q", s)
+	}
+
+	wiki, err := ParseString(`{{PAGENAME}} {{NAMESPACE:Help:x}} {{DISPLAYTITLE:y}} {{z}}`)
+	if err != nil {
+		t.Fatalf("TestPageContext: %v", err)
+	}
+	var values []string
+	for _, e := range wiki.Entities {
+		if s, ok := c.Eval(e); ok {
+			values = append(values, s)
+		}
+	}
+	if s, expect := strings.Join(values, ","), "Foo bar/baz/qux,Help"; s != expect {
+		t.Errorf("TestPageContext: expect %q, got %q", expect, s)
+	}
+}
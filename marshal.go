@@ -0,0 +1,381 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The arguments of a template are the fields of a struct by the tags, e.g.
+//
+//	type Letter struct {
+//		Page string `wiki:"page"`
+//		NATO string `wiki:"NATO,omitempty"`
+//		Forms []string `wiki:"1"`
+//		Image *Entity `wiki:"image"`
+//	}
+//
+// The name of an argument is the field name if there's no tag, a field of
+// the tag "-" is skipped. The names are matched exactly as MediaWiki does,
+// or case-insensitively with the option "fold", e.g. `wiki:"name,fold"`. A
+// field of a slice is of the arguments of the name followed by numbers, e.g.
+// "alt", "alt2", "alt3", or of the positional arguments from the number,
+// e.g. "2", "3", "4". The values are the wikitext, they're converted to the
+// strings, the numbers, the bools and the entities (*Entity) of the fields.
+// A bool is false if the value is empty, "0", "no", "n", "false" or "off",
+// it's true otherwise.
+
+var entityPtrType = reflect.TypeOf((*Entity)(nil))
+
+// templateField is a field of a struct of template arguments.
+type templateField struct {
+	index int
+	name string
+	omitEmpty bool
+	fold bool
+}
+
+// templateFields returns the fields of the struct type t.
+func templateFields(t reflect.Type) (fields []templateField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		tag := f.Tag.Get("wiki")
+		if tag == "-" {
+			continue
+		}
+		field := templateField{ index: i, name: f.Name }
+		if parts := strings.Split(tag, ","); parts[0] != "" {
+			field.name = parts[0]
+		}
+		for _, opt := range strings.Split(tag, ",")[1:] {
+			switch opt {
+			case "omitempty":
+				field.omitEmpty = true
+			case "fold":
+				field.fold = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return
+}
+
+// fieldArgName returns the name of the n-th argument of the slice field of
+// the name, n starts from 0, e.g. "alt3" of "alt" and 2, "4" of "2" and 2.
+func fieldArgName(name string, n int) string {
+	if num, err := strconv.Atoi(name); err == nil {
+		return strconv.Itoa(num + n)
+	}
+	if n == 0 {
+		return name
+	}
+	return name + strconv.Itoa(n + 1)
+}
+
+// lookup returns the argument of the name, the names are matched
+// case-insensitively if fold is true and there's no exact one.
+func (a *TemplateArgs) lookup(name string, fold bool) *TemplateArg {
+	if arg := a.args[name]; arg != nil || !fold {
+		return arg
+	}
+	for i := len(a.List) - 1; 0 <= i; i-- {
+		if strings.EqualFold(a.List[i].Name, name) {
+			return a.List[i]
+		}
+	}
+	return nil
+}
+
+// setValue converts the argument arg to v.
+func setValue(v reflect.Value, arg *TemplateArg) error {
+	if v.Type() == entityPtrType {
+		v.Set(reflect.ValueOf(arg.Value))
+		return nil
+	}
+	s := arg.Value.Text
+	switch v.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), arg); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "", "0", "no", "n", "false", "off":
+			v.SetBool(false)
+		default:
+			v.SetBool(true)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+// UnmarshalTemplate stores the arguments of the template e in the struct
+// pointed to by v, the fields of the missing arguments are not changed.
+func UnmarshalTemplate(e *Entity, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("wiki: UnmarshalTemplate of %T, not a pointer to struct", v)
+	}
+	args, err := e.TemplateArgs()
+	if err != nil {
+		return err
+	}
+	rv = rv.Elem()
+	for _, field := range templateFields(rv.Type()) {
+		fv := rv.Field(field.index)
+		if fv.Kind() != reflect.Slice {
+			if arg := args.lookup(field.name, field.fold); arg != nil {
+				if err := setValue(fv, arg); err != nil {
+					return fmt.Errorf("wiki: argument %q of %s: %v", arg.Name, rv.Type().Field(field.index).Name, err)
+				}
+			}
+			continue
+		}
+		var values []reflect.Value
+		for n := 0; ; n++ {
+			arg := args.lookup(fieldArgName(field.name, n), field.fold)
+			if arg == nil {
+				break
+			}
+			value := reflect.New(fv.Type().Elem()).Elem()
+			if err := setValue(value, arg); err != nil {
+				return fmt.Errorf("wiki: argument %q of %s: %v", arg.Name, rv.Type().Field(field.index).Name, err)
+			}
+			values = append(values, value)
+		}
+		if values != nil {
+			fv.Set(reflect.Append(reflect.MakeSlice(fv.Type(), 0, len(values)), values...))
+		}
+	}
+	return nil
+}
+
+// escapeArg escapes the '|' of the value s of an argument out of the links
+// and the templates, e.g. "a{{!}}b" of "a|b", and the brackets not closed in
+// s by the character references, e.g. "x=&#125;&#125;y" of "x=}}y", so is a
+// '}' at the end.
+func escapeArg(s string) string {
+	// the brackets closed in s, by the offsets
+	closed := make(map[int]bool)
+	var opens []int
+	for i := 0; i + 1 < len(s); i++ {
+		switch p := s[i:i+2]; p {
+		case "[[", "{{":
+			opens = append(opens, i)
+			i++
+		case "]]", "}}":
+			if n := len(opens); 0 < n && (p == "]]") == (s[opens[n-1]] == '[') {
+				closed[opens[n-1]], closed[i] = true, true
+				opens = opens[0:n-1]
+				i++
+			}
+		}
+	}
+	var b bytes.Buffer
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		if i + 1 < len(s) {
+			switch p := s[i:i+2]; p {
+			case "[[", "{{", "]]", "}}":
+				if !closed[i] {
+					b.WriteString(strings.Repeat(fmt.Sprintf("&#%d;", p[0]), 2))
+				} else if b.WriteString(p); p[0] == '[' || p[0] == '{' {
+					depth++
+				} else {
+					depth--
+				}
+				i++
+				continue
+			}
+		}
+		switch {
+		case s[i] == '|' && depth == 0:
+			b.WriteString("{{!}}")
+		case s[i] == '}' && i == len(s) - 1:
+			b.WriteString("&#125;")
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// escapeArgText escapes all the brackets and the '|' of the value s of an
+// argument, the links and the tags of s are the text.
+func escapeArgText(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '|', '[', ']', '{', '}', '<', '>':
+			fmt.Fprintf(&b, "&#%d;", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// argValue returns the escaped value s of an argument, it's escapeArgText
+// of s if the links and the templates of it can't be kept.
+func argValue(s string) string {
+	v := escapeArg(s)
+	if _, err := parseTemplate("{{t|" + v + "}}", 1); err != nil {
+		return escapeArgText(s)
+	}
+	return v
+}
+
+// escapeSpaces escapes the spaces at the ends of the value s of a named
+// argument by the character references, which are trimmed otherwise, e.g.
+// "&#32;a b&#32;" of " a b ".
+func escapeSpaces(s string) string {
+	a, b := 0, len(s)
+	for a < b && isSpace(rune(s[a])) {
+		a++
+	}
+	for a < b && isSpace(rune(s[b-1])) {
+		b--
+	}
+	if a == 0 && b == len(s) {
+		return s
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if i < a || b <= i {
+			fmt.Fprintf(&buf, "&#%d;", s[i])
+		} else {
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
+
+// parseTemplate returns the template of the wikitext s, or an error if it's
+// not a template of n arguments.
+func parseTemplate(s string, n int) (*Entity, error) {
+	for _, t := range []EntityType{ WikiEntityTemplate, WikiEntityMagicWord } {
+		if e, err := newEntity(s, t); err == nil {
+			if _, props := templateParts(e); len(props) == n {
+				return e, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("wiki: %q is not a template of %d arguments", s, n)
+}
+
+// formatValue returns the wikitext of v, or false if it's empty.
+func formatValue(v reflect.Value) (string, bool, error) {
+	if v.Type() == entityPtrType {
+		if v.IsNil() {
+			return "", false, nil
+		}
+		e := v.Interface().(*Entity)
+		return string(e.Raw), 0 < len(e.Raw), nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "", false, nil
+		}
+		s, _, err := formatValue(v.Elem())
+		return s, true, err
+	case reflect.String:
+		return argValue(v.String()), v.Len() != 0, nil
+	case reflect.Bool:
+		if v.Bool() {
+			return "yes", true, nil
+		}
+		return "", false, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), v.Int() != 0, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), v.Uint() != 0, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), v.Float() != 0, nil
+	}
+	return "", false, fmt.Errorf("unsupported type %v", v.Type())
+}
+
+// MarshalTemplate returns the wikitext of the template of the name with the
+// arguments of the fields of the struct v, e.g. {{Letter|page=A|NATO=Alpha}}.
+// The positional arguments are written without the names if possible, the
+// spaces at the ends of the values of the named ones are escaped. The
+// fields of nil pointers are omitted.
+func MarshalTemplate(name string, v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("wiki: MarshalTemplate of %T, not a struct", v)
+	}
+	var b bytes.Buffer
+	b.WriteString("{{" + name)
+	positional, count := 0, 0
+	write := func(key, s string) {
+		count++
+		if n, err := strconv.Atoi(key); err == nil && n == positional + 1 && strings.IndexByte(s, '=') < 0 {
+			positional = n
+			b.WriteString("|" + s)
+		} else {
+			b.WriteString("|" + key + "=" + escapeSpaces(s))
+		}
+	}
+	for _, field := range templateFields(rv.Type()) {
+		fv := rv.Field(field.index)
+		if fv.Kind() != reflect.Slice {
+			s, ok, err := formatValue(fv)
+			if err != nil {
+				return nil, fmt.Errorf("wiki: field %s: %v", rv.Type().Field(field.index).Name, err)
+			}
+			if ok || !field.omitEmpty && !(fv.Kind() == reflect.Ptr && fv.IsNil()) {
+				write(field.name, s)
+			}
+			continue
+		}
+		for n := 0; n < fv.Len(); n++ {
+			s, _, err := formatValue(fv.Index(n))
+			if err != nil {
+				return nil, fmt.Errorf("wiki: field %s: %v", rv.Type().Field(field.index).Name, err)
+			}
+			write(fieldArgName(field.name, n), s)
+		}
+	}
+	b.WriteString("}}")
+	if _, err := parseTemplate(b.String(), count); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,159 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"testing"
+)
+
+type testCharacterInfo struct {
+	Previous string `wiki:"previous"`
+	Next string `wiki:"next"`
+	Image *Entity `wiki:"image"`
+	Hex int `wiki:"hex"`
+	Name string `wiki:",fold"`
+	Missing string `wiki:"missing"`
+	Skipped string `wiki:"-"`
+}
+
+type testLetter struct {
+	Page string `wiki:"page"`
+	NATO string
+	Morse string
+	Braille *string
+}
+
+type testTerm struct {
+	Lang string `wiki:"lang,omitempty"`
+	Terms []string `wiki:"1"`
+	Alt []string `wiki:"alt,omitempty"`
+	Count uint8 `wiki:"n,omitempty"`
+	Bold bool `wiki:"b,omitempty"`
+	Score float64 `wiki:"score,omitempty"`
+}
+
+// findTemplate returns the first template of the name in the tree of e.
+func findTemplate(e *Entity, name string) *Entity {
+	if target, err := e.TemplateTarget(); err == nil && target.Title == "Template:" + name {
+		return e
+	}
+	for _, child := range e.Entities {
+		if res := findTemplate(child, name); res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
+func TestUnmarshalTemplate(t *testing.T) {
+	data, err := readTestData("testdata/a.wiki.gz")
+	if err != nil {
+		t.Fatalf("TestUnmarshalTemplate: %v", err)
+	}
+	wiki, err := Parse(data)
+	if err != nil {
+		t.Fatalf("TestUnmarshalTemplate: %v", err)
+	}
+
+	info := testCharacterInfo{ Missing: "x", Skipped: "y" }
+	if err := UnmarshalTemplate(findTemplate(wiki, "Basic Latin character info"), &info); err != nil {
+		t.Fatalf("TestUnmarshalTemplate: %v", err)
+	}
+	if info.Previous != "`" || info.Next != "b" || info.Hex != 61 || info.Name != "LATIN SMALL LETTER A" || info.Missing != "x" || info.Skipped != "y" {
+		t.Errorf("TestUnmarshalTemplate: unexpected %+v", info)
+	}
+	if info.Image == nil || len(info.Image.Entities) != 1 || info.Image.Entities[0].Type != WikiEntityLinkInternal {
+		t.Errorf("TestUnmarshalTemplate: unexpected image %v", info.Image)
+	}
+
+	var letter testLetter
+	if err := UnmarshalTemplate(findTemplate(wiki, "Letter"), &letter); err != nil {
+		t.Fatalf("TestUnmarshalTemplate: %v", err)
+	}
+	if letter.Page != "A" || letter.NATO != "Alpha" || letter.Morse != "·–" || letter.Braille == nil || *letter.Braille != "⠁" {
+		t.Errorf("TestUnmarshalTemplate: unexpected %+v", letter)
+	}
+
+	wiki, _ = ParseString("{{t|a|b|c|lang=en|alt=x|alt2=y|alt4=z|n=7|b=yes|score=1.5}}")
+	var term testTerm
+	if err := UnmarshalTemplate(wiki.Entities[0], &term); err != nil {
+		t.Fatalf("TestUnmarshalTemplate: %v", err)
+	}
+	if term.Lang != "en" || len(term.Terms) != 3 || term.Terms[2] != "c" || len(term.Alt) != 2 || term.Alt[1] != "y" || term.Count != 7 || !term.Bold || term.Score != 1.5 {
+		t.Errorf("TestUnmarshalTemplate: unexpected %+v", term)
+	}
+
+	wiki, _ = ParseString("{{t|Lang=en|ALT=x|1=a}}")
+	term = testTerm{}
+	if err := UnmarshalTemplate(wiki.Entities[0], &term); err != nil {
+		t.Fatalf("TestUnmarshalTemplate: %v", err)
+	}
+	if term.Lang != "" || term.Alt != nil || len(term.Terms) != 1 {
+		t.Errorf("TestUnmarshalTemplate: names are case-sensitive, got %+v", term)
+	}
+
+	wiki, _ = ParseString("{{t|n=many}}")
+	if err := UnmarshalTemplate(wiki.Entities[0], &term); err == nil {
+		t.Errorf("TestUnmarshalTemplate: expect an error of n=many")
+	}
+	if err := UnmarshalTemplate(wiki.Entities[0], term); err == nil {
+		t.Errorf("TestUnmarshalTemplate: expect an error of non-pointer")
+	}
+}
+
+func TestMarshalTemplate(t *testing.T) {
+	tests := []struct{
+		name string
+		v interface{}
+		wikitext string
+	}{
+		{ "Letter", &testLetter{ Page: "A", NATO: "Alpha" }, `{{Letter|page=A|NATO=Alpha|Morse=}}` },
+		{ "t", testTerm{ Terms: []string{ "a", "b=c", "[[d|e]]|f" }, Alt: []string{ "x", "y" }, Bold: true }, `{{t|a|2=b=c|3=[[d|e]]{{!}}f|alt=x|alt2=y|b=yes}}` },
+		{ "t", testTerm{ Lang: "en", Count: 2, Score: 0.5 }, `{{t|lang=en|n=2|score=0.5}}` },
+		{ "t", testTerm{ Lang: "}}", Terms: []string{ "a}", "{{b" } }, `{{t|lang=&#125;&#125;|a&#125;|&#123;&#123;b}}` },
+		{ "t", testTerm{ Lang: " en", Terms: []string{ " a=b\n", " c " } }, "{{t|lang=&#32;en|1=&#32;a=b&#10;|2=&#32;c&#32;}}" },
+	}
+	for i, test := range tests {
+		s, err := MarshalTemplate(test.name, test.v)
+		if err != nil {
+			t.Errorf("TestMarshalTemplate: [%d] %v", i, err)
+			continue
+		}
+		if string(s) != test.wikitext {
+			t.Errorf("TestMarshalTemplate: [%d] expect %q, got %q", i, test.wikitext, string(s))
+		}
+	}
+
+	term := testTerm{ Lang: "en", Terms: []string{ "a", " b " }, Alt: []string{ "x" }, Count: 3 }
+	s, err := MarshalTemplate("t", term)
+	if err != nil {
+		t.Fatalf("TestMarshalTemplate: %v", err)
+	}
+	wiki, _ := Parse(s)
+	var res testTerm
+	if err := UnmarshalTemplate(wiki.Entities[0], &res); err != nil {
+		t.Fatalf("TestMarshalTemplate: %v", err)
+	}
+	if res.Lang != "en" || len(res.Terms) != 2 || res.Terms[1] != " b " || len(res.Alt) != 1 || res.Count != 3 {
+		t.Errorf("TestMarshalTemplate: unexpected %+v of %s", res, s)
+	}
+	term = testTerm{ Lang: " en ", Terms: []string{ " a=b " } }
+	if s, err = MarshalTemplate("t", term); err != nil {
+		t.Fatalf("TestMarshalTemplate: %v", err)
+	}
+	wiki, _ = Parse(s)
+	res = testTerm{}
+	if err := UnmarshalTemplate(wiki.Entities[0], &res); err != nil {
+		t.Fatalf("TestMarshalTemplate: %v", err)
+	}
+	if decodeCharRefs([]byte(res.Lang)) != " en " || len(res.Terms) != 1 || decodeCharRefs([]byte(res.Terms[0])) != " a=b " {
+		t.Errorf("TestMarshalTemplate: unexpected %+v of %s", res, s)
+	}
+	if _, err := MarshalTemplate("c", testCharacterInfo{ Image: NewText("}}") }); err == nil {
+		t.Errorf("TestMarshalTemplate: expect an error of the image \"}}\"")
+	}
+	if _, err := MarshalTemplate("t", 1); err == nil {
+		t.Errorf("TestMarshalTemplate: expect an error of non-struct")
+	}
+}
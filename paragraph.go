@@ -0,0 +1,125 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import "strings"
+
+// blockTags are the HTML tags breaking paragraphs, a line containing any of
+// them is not a part of a paragraph.
+var blockTags = map[string]bool{
+	"blockquote": true, "center": true, "dd": true, "div": true, "dl": true,
+	"dt": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "hr": true, "li": true, "ol": true, "p": true, "pre": true,
+	"table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+func isBlockTag(e *Entity) bool {
+	switch e.Type {
+	case WikiEntityTag, WikiEntityTagBeg, WikiEntityTagEnd:
+		name, _ := tagName(strings.TrimPrefix(e.Text, "/"))
+		return blockTags[name]
+	}
+	return false
+}
+
+// paragrapher groups the lines of the inline top-level entities into
+// paragraphs before passing them to fn, the paragraphs are broken by blank
+// lines and block entities.
+type paragrapher struct {
+	fn func(e *Entity) error
+	run []*Entity
+}
+
+func (g *paragrapher) entity(e *Entity) error {
+	if !isBlock(e) {
+		g.run = append(g.run, e)
+		return nil
+	}
+	if err := g.flush(); err != nil {
+		return err
+	}
+	return g.fn(e)
+}
+
+func (g *paragrapher) flush() (err error) {
+	run := g.run
+	g.run = g.run[0:0]
+	if len(run) == 0 {
+		return
+	}
+	for _, e := range paragraphs(run) {
+		if err = g.fn(e); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// paragraphLine is a line of the top-level entities, content is false for
+// blank lines.
+type paragraphLine struct {
+	a, b int
+	content, block bool
+}
+
+// paragraphs returns the top-level entities of run with the lines of text
+// grouped into WikiEntityParagraph entities.
+func paragraphs(run []*Entity) (res []*Entity) {
+	c, texts := container(run)
+	raw := c.Raw
+
+	var lines []paragraphLine
+	l := paragraphLine{}
+	scan := func(a, b int) {
+		for i := a; i < b; i++ {
+			switch raw[i] {
+			case '\n':
+				l.b = i
+				lines = append(lines, l)
+				l = paragraphLine{ a: i + 1 }
+			case ' ', '\t', '\r':
+			default:
+				l.content = true
+			}
+		}
+	}
+	at := 0
+	for _, e := range c.Entities {
+		off := rawOffset(c, e)
+		if off < at {
+			continue
+		}
+		scan(at, off)
+		if !isCategoryLink(e) && !isPageProp(e) {
+			l.content = true // not rendered in place
+		}
+		l.block = l.block || isBlockTag(e)
+		at = off + len(e.Raw)
+	}
+	scan(at, len(raw))
+	l.b = len(raw)
+	lines = append(lines, l)
+
+	at = 0
+	for i := 0; i < len(lines); {
+		if !lines[i].content || lines[i].block {
+			i++
+			continue
+		}
+		n := i + 1
+		for n < len(lines) && lines[n].content && !lines[n].block {
+			n++
+		}
+		a, b := lines[i].a, lines[n-1].b
+		p := &Entity{ Type: WikiEntityParagraph, Pos: c.Pos + a, Raw: raw[a:b], Text: string(raw[a:b]) }
+		for _, e := range pieces(nil, c, nil, a, b) {
+			e.Pos = rawOffset(p, e)
+			p.Entities = append(p.Entities, e)
+		}
+		res = pieces(res, c, texts, at, a)
+		res = append(res, p)
+		at, i = b, n
+	}
+	return pieces(res, c, texts, at, len(raw))
+}
@@ -0,0 +1,91 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParagraphs(t *testing.T) {
+	tests := []struct{
+		src string
+		entities []string // Type{Raw} of the top-level entities
+	}{
+		{ `a`, []string{ `WikiEntityParagraph{a}` } },
+		{ "a\nb\n", []string{ "WikiEntityParagraph{a\nb}", "WikiEntityText{\n}" } },
+		{ "a\n\nb", []string{ "WikiEntityParagraph{a}", "WikiEntityText{\n\n}", "WikiEntityParagraph{b}" } },
+		{ "a\n \t\n\nb [[c]] d", []string{ "WikiEntityParagraph{a}", "WikiEntityText{\n \t\n\n}", "WikiEntityParagraph{b [[c]] d}" } },
+		{ "a\n== h ==\nb\n* c\nd", []string{
+			"WikiEntityParagraph{a}", "WikiEntityHeading2{== h ==}",
+			"WikiEntityText{\n}", "WikiEntityParagraph{b}",
+			"WikiEntityListBulleted{* c}",
+			"WikiEntityText{\n}", "WikiEntityParagraph{d}",
+		} },
+		{ "a ''b\nc'' d", []string{ "WikiEntityParagraph{a ''b\nc'' d}" } },
+		{ "a\n<div>b</div>\nc", []string{
+			"WikiEntityParagraph{a}", "WikiEntityText{\n}",
+			"WikiEntityTagBeg{<div>}", "WikiEntityText{b}", "WikiEntityTagEnd{</div>}",
+			"WikiEntityText{\n}", "WikiEntityParagraph{c}",
+		} },
+		{ "a\n\n[[Category:B]]\n", []string{
+			"WikiEntityParagraph{a}", "WikiEntityText{\n\n}",
+			"WikiEntityLinkInternal{[[Category:B]]}", "WikiEntityText{\n}",
+		} },
+		{ "\n\n", []string{ "WikiEntityText{\n}", "WikiEntityText{\n}" } },
+	}
+	opts := &ParseOptions{ Paragraphs: true }
+	for i, test := range tests {
+		data := []byte(test.src)
+		wiki, err := ParseWithOptions(data, opts)
+		if err != nil {
+			t.Errorf("TestParagraphs: [%d] %v", i, err)
+			continue
+		}
+		var entities []string
+		for _, e := range wiki.Entities {
+			entities = append(entities, e.String())
+			if isHeading(e) {
+				for _, child := range e.Entities {
+					entities = append(entities, child.String())
+				}
+			}
+		}
+		if fmt.Sprintf("%q", entities) != fmt.Sprintf("%q", test.entities) {
+			t.Errorf("TestParagraphs: [%d] expect %q, got %q", i, test.entities, entities)
+		}
+		checkEntityRanges(t, data, wiki)
+
+		res, err := ParseReader(iotest.OneByteReader(strings.NewReader(test.src)), &ParseOptions{ Paragraphs: true, ChunkSize: 1, CopyRaw: true })
+		if err != nil {
+			t.Errorf("TestParagraphs: [%d] ParseReader: %v", i, err)
+			continue
+		}
+		sameEntity(t, fmt.Sprintf("TestParagraphs: [%d]", i), wiki, res)
+	}
+}
+
+func TestParagraphChildren(t *testing.T) {
+	wiki, err := ParseWithOptions([]byte("x\n\na '''b''' [[c]]"), &ParseOptions{ Paragraphs: true })
+	if err != nil {
+		t.Fatalf("TestParagraphChildren: %v", err)
+	}
+	p := wiki.Entities[len(wiki.Entities)-1]
+	if p.Type != WikiEntityParagraph || p.Pos != 3 || p.Text != `a '''b''' [[c]]` {
+		t.Fatalf("TestParagraphChildren: %v (pos=%v)", p, p.Pos)
+	}
+	expect := []string{ `WikiEntityTextBold{'''b'''} 2`, `WikiEntityLinkInternal{[[c]]} 10` }
+	var children []string
+	for _, e := range p.Entities {
+		children = append(children, fmt.Sprintf("%v %v", e, e.Pos))
+	}
+	if fmt.Sprintf("%q", children) != fmt.Sprintf("%q", expect) {
+		t.Errorf("TestParagraphChildren: expect %q, got %q", expect, children)
+	}
+	if s, expect := HTML(wiki), "<p>x\n</p>\n\n<p>a <b>b</b> <a href=\"/wiki/C\" title=\"C\">c</a>\n</p>"; s != expect {
+		t.Errorf("TestParagraphChildren: expect %q, got %q", expect, s)
+	}
+}
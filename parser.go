@@ -4,7 +4,10 @@
 package wiki
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"regexp"
 	//"strings"
 )
 
@@ -119,6 +122,22 @@ const (
 	/*		      */// 
 	WikiEntityHR		// ----
 	/*		      */// 
+	WikiEntityParagraph	// Lines of text separated by blank lines
+	/*		      */// (ParseOptions.Paragraphs)
+	WikiEntityLinkURL	// http://www.example.org (bare URL)
+	WikiEntityLinkISBN	// ISBN 978-0-306-40615-7
+	WikiEntityLinkRFC	// RFC 822
+	WikiEntityLinkPMID	// PMID 1234
+	WikiEntityLinkExternalURL
+	/*		      */// http://www.example.org of [http://www.example.org Link label]
+	WikiEntityLinkExternalLabel
+	/*		      */// Link label of [http://www.example.org Link label]
+	WikiEntityLinkInternalTrail
+	/*		      */// es of [[bus]]es
+	WikiEntityBehaviorSwitch// __NOTOC__, __TOC__
+	WikiEntityMagicWord	// {{PAGENAME}}, {{DEFAULTSORT:Sort key}}
+	WikiEntityRedirect	// #REDIRECT [[Target page]]
+	WikiEntityCharRef	// &amp;, &#x3B1;
 )
 
 var entityTypeNames = []string{
@@ -148,6 +167,18 @@ var entityTypeNames = []string{
 	WikiEntitySignatureTimestamp:           "WikiEntitySignatureTimestamp",
 	WikiEntityIndent:			"WikiEntityIndent",
 	WikiEntityHR:				"WikiEntityHR",
+	WikiEntityParagraph:			"WikiEntityParagraph",
+	WikiEntityLinkURL:			"WikiEntityLinkURL",
+	WikiEntityLinkISBN:			"WikiEntityLinkISBN",
+	WikiEntityLinkRFC:			"WikiEntityLinkRFC",
+	WikiEntityLinkPMID:			"WikiEntityLinkPMID",
+	WikiEntityLinkExternalURL:		"WikiEntityLinkExternalURL",
+	WikiEntityLinkExternalLabel:		"WikiEntityLinkExternalLabel",
+	WikiEntityLinkInternalTrail:		"WikiEntityLinkInternalTrail",
+	WikiEntityBehaviorSwitch:		"WikiEntityBehaviorSwitch",
+	WikiEntityMagicWord:			"WikiEntityMagicWord",
+	WikiEntityRedirect:			"WikiEntityRedirect",
+	WikiEntityCharRef:			"WikiEntityCharRef",
 }
 
 type EntityType int8
@@ -157,6 +188,18 @@ type Entity struct {
 	Raw []byte
 	Text string
 	Entities []*Entity // all child entities
+
+	// parent is not exported, so that the tree is written without cycles,
+	// e.g. by encoding/json, see Parent.
+	parent *Entity
+
+	// titles are the options of the titles of ParseOptions.Site, which are
+	// set to the root and the top-level entities by the parser.
+	titles *TitleOptions
+
+	// tags are the extension tags of the parser, which are set the same
+	// way as titles, see ParseOptions.ExtensionTags.
+	tags ExtensionTags
 }
 
 func (e Entity) String() string {
@@ -178,69 +221,136 @@ type parser struct {
 	entities []*Entity // parsed entity stack (parents)
 
 	entity *Entity
+
+	// limits, zero values mean unlimited
+	count int // number of entities made
+	maxEntities int
+	maxInputSize int
+
+	paragraphs bool
+	urls *regexp.Regexp // bare URLs, nil if disabled
+	protocols []string
+	linkTrail *regexp.Regexp
+	words *magicWords
+	tags ExtensionTags
+	titles *TitleOptions // nil if there's no site
+}
+
+func newParser(opts *ParseOptions) (p *parser) {
+	p = new(parser)
+	p.scan = new(scanner)
+	p.urls, p.protocols = defaultURLRegexp, DefaultProtocols
+	p.linkTrail = LinkTrails["en"]
+	p.words = defaultMagicWords
+	if opts != nil {
+		p.scan.maxDepth = opts.MaxDepth
+		p.scan.ctx = opts.Context
+		p.maxEntities = opts.MaxEntities
+		p.maxInputSize = opts.MaxInputSize
+		p.paragraphs = opts.Paragraphs
+		if site := opts.Site; site != nil {
+			if site.Protocols != nil {
+				p.urls, p.protocols = urlRegexp(site.Protocols), site.Protocols
+			}
+			if site.LinkTrail != nil {
+				p.linkTrail = site.LinkTrail
+			}
+			if site.MagicWords != nil {
+				p.words = newMagicWords(site.MagicWords)
+			}
+			if site.ExtensionTags != nil {
+				p.tags = NewExtensionTags(site.ExtensionTags...)
+			}
+			p.titles = site.TitleOptions()
+		}
+		if opts.Protocols != nil {
+			p.urls, p.protocols = urlRegexp(opts.Protocols), opts.Protocols
+		}
+		if opts.LinkTrail != nil {
+			p.linkTrail = opts.LinkTrail
+		}
+		if opts.MagicWords != nil {
+			p.words = newMagicWords(opts.MagicWords)
+		}
+		if p.tags == nil && opts.ExtensionTags != nil {
+			p.tags = make(ExtensionTags)
+		}
+		// The tags of the options override the ones of the site.
+		p.tags.merge(opts.ExtensionTags)
+		if p.tags != nil {
+			p.scan.rawTags = p.tags.raw
+		}
+	}
+	return
+}
+
+// newEntity counts the entities made, the scanner is stopped if too many.
+func (p *parser) newEntity() *Entity {
+	if p.count++; 0 < p.maxEntities && p.maxEntities < p.count && p.scan.err == nil {
+		p.scan.err = &LimitError{ "MaxEntities", p.maxEntities, int64(p.scan.pos()) }
+	}
+	return new(Entity)
 }
 
 func (p *parser) push(state EntityType) {
 	if 0 < len(p.state) {
 		//fmt.Printf("push: [stack=%v, state=%v, entities=%v]\n", p.state, state, p.entities)
 		p.entities = append(p.entities, p.entity)
-		p.entity = new(Entity)
-		p.entity.Pos = p.scan.pos()
+		p.entity = p.newEntity()
 		//p.entity.Type = state
 	}
 	p.state = append(p.state, state)
 }
 
+// slice returns p.data[a:b], or nil with the error of the scanner set if
+// it's out of the data, which is a bug of the scanner.
+func (p *parser) slice(a, b int) []byte {
+	if a < 0 || b < a || len(p.data) < b {
+		if p.scan.err == nil {
+			p.scan.err = &SyntaxError{ fmt.Sprintf("wiki: range [%d:%d] out of %d bytes", a, b, len(p.data)), int64(a) }
+		}
+		return nil
+	}
+	return p.data[a:b]
+}
+
+// offset returns the offset of raw in p.data, or -1 if raw is not a slice
+// of it.
+func (p *parser) offset(raw []byte) int {
+	if raw == nil {
+		return -1
+	}
+	if off := cap(p.data) - cap(raw); 0 <= off && off + len(raw) <= len(p.data) {
+		return off
+	}
+	return -1
+}
+
+// rebasePos makes Pos of the children of e, which are offsets in p.data,
+// relative to the offset base of e.
+func rebasePos(e *Entity, base int) {
+	for _, child := range e.Entities {
+		child.Pos -= base
+	}
+}
+
 func (p *parser) pop(state EntityType, pos1, pos2, off1, off2 int) {
 	//fmt.Printf("pop: [stack=%v, state=%v, off=[%v, %v], entities=%v] %s, %s\n", p.state, state, off1, off2, p.entities, string(p.data[pos1:pos2]), string(p.data[pos2:]))
 
 	top := len(p.state) - 1
 	if top < 0 {
-		//fmt.Printf("pop: %s [stack=%v, state=%v, pos-stack=%v, off=[%v, %v], empty]\n", p.entity.Text, p.state, state, p.pos, off1, off2)
-		fmt.Printf("pop: %s [stack=%v, state=%v, off=[%v, %v], empty]\n", p.entity.Text, p.state, state, off1, off2)
 		return
 	}
 
-	//pos1, pos2 := pos0 /*p.pos[top]*/, p.scan.pos() - p.scan.rewind
-	doPush, doPop := false, true
-	switch {
-	case p.state[top] == state && pos1 == 0 && pos2 == 0 && off1 == 0 && off2 == 0:
+	if p.state[top] == state && pos1 == 0 && pos2 == 0 && off1 == 0 && off2 == 0 {
 		p.state = p.state[0:top]
 		if l := len(p.entities); 0 < l {
 			p.entity = p.entities[l-1]
 			p.entities = p.entities[0:l-1]
-			//fmt.Printf("pop: %v [stack=%v]\n", p.entities, p.state)
 		}
 		return
-	case p.state[top] == WikiEntityTextBoldItalic:
-		switch state {
-		case WikiEntityTextBold:
-			if 0 < top && p.state[top-1] == WikiEntityTextItalic {
-				// in case of: ''a'''''A'''
-				fmt.Printf("pop-error: %s [stack=%v, state=%v, pos1=%v, pos2=%v, off=[%v, %v]]\n", p.entity, p.state, state, pos1, pos2, off1, off2)
-			} else {
-				p.state[top] = WikiEntityTextItalic
-				//p.pos[top] -= off1 // step back for '''
-				doPush = true
-			}
-		case WikiEntityTextItalic:
-			if 0 < top && p.state[top-1] == WikiEntityTextBold {
-				// in case of: '''A'''''a''
-				fmt.Printf("pop-error: %s [stack=%v, state=%v, pos1=%v, pos2=%v, off=[%v, %v]]\n", p.entity, p.state, state, pos1, pos2, off1, off2)
-			} else {
-				p.state[top] = WikiEntityTextBold
-				//p.pos[top] -= off1 // step back for ''
-				doPush = true
-			}
-		}
-	}
-	if doPush {
-		p.entities = append(p.entities, p.entity)
-		p.entity = new(Entity)
-	} else if doPop {
-		//p.pos, p.state = p.pos[0:top], p.state[0:top]
-		p.state = p.state[0:top]
 	}
+	p.state = p.state[0:top]
 
 	switch state {
 	case WikiEntityLinkInternalProp, WikiEntityTemplateProp:
@@ -249,7 +359,7 @@ func (p *parser) pop(state EntityType, pos1, pos2, off1, off2 int) {
 
 	// make p.entity
 	p.entity.Type = state
-	p.entity.Text = string(p.data[pos1 : pos2-off2])
+	p.entity.Text = string(p.slice(pos1, pos2-off2))
 
 	if top = len(p.entities)-1; top < 0 {
 		//fmt.Printf("pop: %v [stack=%v, state=%v, entities=%v] (no parents)\n", p.entity, p.state, state, p.entities)
@@ -257,27 +367,21 @@ func (p *parser) pop(state EntityType, pos1, pos2, off1, off2 int) {
 	}
 
 	parent := p.entities[top]
-	p.entity.Pos = pos1 - off1 - parent.Pos
-
-	// Entity.Pos
-	switch state {
-	case WikiEntityTemplateName, WikiEntityTemplateProp:
-		if 0 < top { p.entity.Pos += 2 } // FIXME: ...
-	}
 
 	// Entity.Raw
 	switch state {
 	default:
-		if l := len(p.data); pos1-off1 < 0 || l < pos1-off1 || l < pos2 {
-			fmt.Printf("pop: out-of-range: %s [state=%v, stack=%v, pos1=%v, pos2=%v, off=[%v, %v], len=%v]\n", p.entity.Text, state, p.state, pos1, pos2, off1, off2, l)
-			//panic(s)
-		} else {
-			p.entity.Raw = p.data[pos1-off1 : pos2]
-		}
-	case WikiEntityLinkInternalProp, WikiEntityTemplateProp:
-		p.entity.Raw = p.data[pos1-off1 : pos2-off2]
+		p.entity.Raw = p.slice(pos1-off1, pos2)
+	case WikiEntityLinkInternalName, WikiEntityLinkInternalProp,
+		WikiEntityTemplateName, WikiEntityTemplateProp:
+		p.entity.Raw = p.slice(pos1-off1, pos2-off2)
 	}
 
+	// Entity.Pos is the offset in p.data until the parent is popped,
+	// the children are then made relative to it.
+	p.entity.Pos = p.offset(p.entity.Raw)
+	rebasePos(p.entity, p.entity.Pos)
+
 	//fmt.Printf("pop: %v %v\n", p.entity, p.entities)
 	//for k, e := range p.entity.Entities { fmt.Printf("\t%v: %v\n", k, e) }
 
@@ -287,107 +391,254 @@ func (p *parser) pop(state EntityType, pos1, pos2, off1, off2 int) {
 	//fmt.Printf("pop: %v [stack=%v, state=%v, parents=%v, parent=%v%v]\n", p.entity, p.state, state, p.entities, parent, parent.Entities)
 }
 
-func (p *parser) parse(wiki *Entity, data []byte) (err error) {
+// next scans the next top-level entity in p.data, pos is the offset of
+// p.data in the whole document.
+func (p *parser) next(pos int) (entity *Entity, rest []byte, err error) {
+	// The stacks may be left unbalanced by broken wikitext, entities of
+	// the previous scan must not be touched.
+	p.state, p.entities = p.state[0:0], p.entities[0:0]
+	p.entity = new(Entity)
+	if p.scan.ctx != nil {
+		// The scanner checks the context every checkInterval steps
+		// of an entity, small entities are checked here.
+		if err = p.scan.ctx.Err(); err != nil {
+			return
+		}
+	}
+	if p.count++; 0 < p.maxEntities && p.maxEntities < p.count {
+		err = &LimitError{ "MaxEntities", p.maxEntities, int64(pos) }
+		return
+	}
+
+	ent, rest, err := p.scan.next(p.data)
+	if e, ok := err.(*LimitError); ok {
+		e.Offset += int64(pos)
+	}
+	if err != nil {
+		return
+	}
+
+	l := len(ent)
+	state, shift := p.scan.state, p.scan.shift
+	p.entity.Raw = ent
+	p.entity.Pos = pos
+
+	if state != WikiEntityText && 0 < l && ent[0] == '\n' {
+		p.entity.Raw = p.entity.Raw[1:]
+		p.entity.Pos++
+	}
+
+	switch state {
+	case WikiEntityIndent, WikiEntityListBulleted, WikiEntityListNumbered:
+		// the spaces before the markup of the first line, p.scan.indent
+		// is of the last line
+		n := len(p.entity.Raw) - len(bytes.TrimLeft(p.entity.Raw, " \t"))
+		p.entity.Raw = p.entity.Raw[n:]
+		p.entity.Pos += n
+	}
+
+	rebasePos(p.entity, p.entity.Pos - pos)
+
+	//fmt.Printf("scan: %v (state=%v, shift=%v)\n", string(ent), state, shift)
+
+	if shift[0] < l && shift[1] < l {
+		a, b := shift[0], l - shift[1]
+		if a <= b {
+			switch state {
+			case WikiEntityIndent, WikiEntityListBulleted, WikiEntityListNumbered:
+				if a < b { a++ } // skip ':', '*', '#'
+			}
+			p.entity.Type = state
+			p.entity.Text = string(ent[a:b])
+			shift[0] = a
+		}
+	}
+
+	//fmt.Printf("scan: %v (%v, stack=%v, state=%v, shift=%v, children=%v)\n", string(ent), p.entity.Text, p.state, state, shift, len(p.entity.Entities))
+	/* for k, e := range p.entity.Entities {
+		fmt.Printf("scan: %v: %v: %v\n", k, e.Type, e.Text)
+	} */
+
+	externalLinks(p.entity, p.protocols)
+
+	entity = p.entity
+	return
+}
+
+// pipeline returns the functions passing the top-level entities to fn
+// through the redirect, the bold and italic, the links and magic words, and
+// the paragraphs if enabled, then the extension tags are parsed and the
+// parents of the entities are set. The flush function must be called at the
+// end of the document.
+func (p *parser) pipeline(fn func(e *Entity) error) (entity func(e *Entity) error, flush func() error) {
+	done := fn
+	fn = func(e *Entity) error {
+		if err := p.tags.parse(e); err != nil {
+			return err
+		}
+		e.SetParents()
+		e.titles, e.tags = p.titles, p.tags
+		return done(e)
+	}
+	l := &linker{ fn: fn, urls: p.urls, words: p.words }
+	q := &quoter{ fn: l.entity, trail: p.linkTrail }
+	r := &redirecter{ fn: q.entity, words: p.words }
+	flushes := []func() error{ r.flush, q.flush }
+	if p.paragraphs {
+		g := &paragrapher{ fn: fn }
+		l.fn = g.entity
+		flushes = append(flushes, g.flush)
+	}
+	return r.entity, func() error {
+		for _, flush := range flushes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// each scans data into top-level entities and passes them to fn one by one.
+func (p *parser) each(data []byte, fn func(e *Entity) error) (err error) {
+	if 0 < p.maxInputSize && p.maxInputSize < len(data) {
+		return &LimitError{ "MaxInputSize", p.maxInputSize, int64(len(data)) }
+	}
 	p.data = data
 	p.scan.push = p.push
 	p.scan.pop = p.pop
-	pos, parent := 0, wiki
-	parents := make([]*Entity, WikiEntityHeading5 - WikiEntityHeading2 + 1)
-	//tags := make([]*Entity, 0, 5) // all WikiEntityTagBeg except tags[0]
-	for i, _ := range parents {
-		// Default parents are the root entity 'wiki'
-		parents[i] = wiki
-	}
+	emit, flush := p.pipeline(fn)
+	pos := 0
 	for {
-		p.entity = new(Entity)
-
-		ent, rest, e := p.scan.next(p.data)
+		entity, rest, e := p.next(pos)
 		if e != nil {
 			err = e
 			return
 		}
-
-		l := len(ent)
-		state, shift := p.scan.state, p.scan.shift
-		p.entity.Raw = ent
-		p.entity.Pos = pos
-
-		if state != WikiEntityText && 0 < l && ent[0] == '\n' {
-			p.entity.Raw = p.entity.Raw[1:]
-			p.entity.Pos++
-		}
-
-		switch state {
-		case WikiEntityIndent, WikiEntityListBulleted, WikiEntityListNumbered:
-			p.entity.Raw = p.entity.Raw[p.scan.indent:]
-			p.entity.Pos += p.scan.indent
+		if err = emit(entity); err != nil {
+			return
 		}
+		pos += len(p.data) - len(rest)
 
-		//fmt.Printf("scan: %v (state=%v, shift=%v)\n", string(ent), state, shift)
-
-		if shift[0] < l && shift[1] < l {
-			a, b := shift[0], l - shift[1]
-			if a <= b {
-				switch state {
-				case WikiEntityIndent, WikiEntityListBulleted, WikiEntityListNumbered:
-					a++ // skip ':', '*', '#'
-				}
-				p.entity.Type = state
-				p.entity.Text = string(ent[a:b])
-				shift[0] = a
-			}
+		if p.data = rest; len(p.data) <= 0 {
+			break
 		}
+	}
+	return flush()
+}
 
-		//fmt.Printf("scan: %v (%v, stack=%v, state=%v, shift=%v, children=%v)\n", string(ent), p.entity.Text, p.state, state, shift, len(p.entity.Entities))
-		/* for k, e := range p.entity.Entities {
-			fmt.Printf("scan: %v: %v: %v\n", k, e.Type, e.Text)
-		} */
-
+// builder returns a function adding top-level entities into the tree of wiki.
+func builder(wiki *Entity) func(e *Entity) error {
+	parent := wiki
+	parents := make([]*Entity, WikiEntityHeading5 - WikiEntityHeading2 + 1)
+	//tags := make([]*Entity, 0, 5) // all WikiEntityTagBeg except tags[0]
+	for i, _ := range parents {
+		// Default parents are the root entity 'wiki'
+		parents[i] = wiki
+	}
+	return func(e *Entity) error {
 		// If the header level is less or equaled to the parent,
 		// we need to reset the parent.
-		isHeading := WikiEntityHeading2 <= p.entity.Type && p.entity.Type <= WikiEntityHeading5
-		if isHeading && p.entity.Type <= parent.Type {
-			i := p.entity.Type - WikiEntityHeading2
+		isHeading := WikiEntityHeading2 <= e.Type && e.Type <= WikiEntityHeading5
+		if isHeading && e.Type <= parent.Type {
+			i := e.Type - WikiEntityHeading2
 			parent = parents[i]
 		}
 
-		// Add p.entity to the current 'parent'
-		parent.Entities = append(parent.Entities, p.entity)
-		pos += l
+		// Add e to the current 'parent'
+		parent.Entities = append(parent.Entities, e)
+		e.parent = parent
 
 		// Select new parent
 		switch {
 		case isHeading:
 			// Change parent for all other entities and sub-levels.
-			parent = p.entity
-			i := p.entity.Type - WikiEntityHeading2
+			parent = e
+			i := e.Type - WikiEntityHeading2
 			for i++; int(i) < len(parents); i++ {
 				parents[i] = parent
 			}
 			/*
 		case state == WikiEntityTagBeg:
 			tags = append(tags, parent)
-			parent = p.entity
+			parent = e
 		case state == WikiEntityTagEnd:
 			if n := len(tags); 0 < n {
 				parent = tags[n-1]
 				tags = tags[0:n-1]
 			} */
 		}
-
-		if p.data = rest; len(p.data) <= 0 {
-			break
-		}
+		return nil
 	}
-	return
+}
+
+func (p *parser) parse(wiki *Entity, data []byte) (err error) {
+	return p.each(data, builder(wiki))
+}
+
+// ParseOptions controls the parsing of a document.
+type ParseOptions struct {
+	// ChunkSize is the number of bytes ParseReader reads at a time, it's
+	// DefaultChunkSize if not positive.
+	ChunkSize int
+
+	// CopyRaw makes Entity.Raw a copy of the input instead of a slice of
+	// it, so that the input buffer can be released or reused.
+	CopyRaw bool
+
+	// Limits for untrusted input, a *LimitError is returned if any is
+	// exceeded. Zero means unlimited.
+	MaxDepth int // nesting depth of entities being scanned
+	MaxEntities int // number of entities
+	MaxInputSize int // size of the input in bytes
+
+	// Paragraphs groups the lines of text into WikiEntityParagraph
+	// entities, the paragraphs are separated by blank lines and broken by
+	// block entities (headings, lists, block-level tags etc.), which are
+	// the <p> of MediaWiki. The blank lines are left as text.
+	Paragraphs bool
+
+	// Protocols are the URL protocols of the external links and the bare
+	// URLs, e.g. "http://", they're DefaultProtocols if nil. No URLs are
+	// valid if it's empty but not nil.
+	Protocols []string
+
+	// LinkTrail matches the link trail at the beginning of the text after
+	// an internal link, e.g. LinkTrails["de"], it's LinkTrails["en"] if nil.
+	LinkTrail *regexp.Regexp
+
+	// MagicWords are the localized aliases of the magic words by the IDs,
+	// e.g. MagicWords["de"], the English aliases are always recognized.
+	MagicWords map[string][]string
+
+	// ExtensionTags are the extension tags of which the bodies are not
+	// parsed as wikitext, e.g. <math>, the Parse handlers of them make the
+	// children of the tags.
+	ExtensionTags ExtensionTags
+
+	// Site is the configuration of the site of the wikitext, the protocols,
+	// the link trail and the magic words of it are used unless the options
+	// above are set, the extension tags of it are merged with the above.
+	// The namespaces of it are of the titles of the templates and the
+	// links, see Entity.TemplateTarget and Entity.Title.
+	Site *SiteConfig
+
+	// Context cancels the parsing when it's done, the error of the
+	// context is returned. It may be nil.
+	Context context.Context
 }
 
 func Parse(data []byte) (wiki *Entity, err error) {
-	p := new(parser)
-	p.scan = new(scanner)
+	return ParseWithOptions(data, nil)
+}
+
+// ParseWithOptions is the same as Parse but with options, opts may be nil.
+func ParseWithOptions(data []byte, opts *ParseOptions) (wiki *Entity, err error) {
+	p := newParser(opts)
 
 	wiki = new(Entity)
 	wiki.Type = WikiEntityWiki
+	wiki.titles, wiki.tags = p.titles, p.tags
 	err = p.parse(wiki, data)
 	return
 }
@@ -5,10 +5,13 @@ package wiki
 
 import (
 	"compress/gzip"
+	"context"
 	"testing"
 	"os"
 	"io/ioutil"
 	"fmt"
+	"path/filepath"
+	"strings"
 )
 
 type entityTest struct{
@@ -67,7 +70,7 @@ func checkEntityResults(t *testing.T, i int, tag string, raw []byte, text string
 			t.Errorf("%s: [%d] len: %v != %v (%s)", tag, i, n1, n2, string(raw))
 			for n := 0; n < n1 && n < n2; n++ {
 				if string(ents[n].Text) != reses[n].s {
-					t.Errorf("%s: [%d, %d, %d] %v != %v (raw: %v) (%v, %v)", tag, i, ci, n, string(ents[n].Text), reses[n].s, string(ents[n].Raw), reses[n].t, string(ents[n].Type))
+					t.Errorf("%s: [%d, %d, %d] %v != %v (raw: %v) (%v, %v)", tag, i, ci, n, string(ents[n].Text), reses[n].s, string(ents[n].Raw), reses[n].t, ents[n].Type)
 					break
 				}
 			}
@@ -152,6 +155,7 @@ func TestParse(t *testing.T) {
 							{WikiEntityLinkInternalName, `link''italic''link`, []*entityTestResult{
 								{WikiEntityTextItalic, `italic`, []*entityTestResult{}},
 							}},
+							{WikiEntityLinkInternalTrail, `bold`, []*entityTestResult{}},
 						}},
 					}},
 				}},
@@ -886,7 +890,7 @@ Modification of capital letter
 	for i, tc := range tests {
 		//if i != 16 && i != 17 { continue }
 		//if i != 18 && i != 19 { continue }
-		if i != 26 { continue }
+		//if i != 26 { continue }
 
 		//t.Logf("TestParse: %d", i)
 		//t.Logf("TestParse: %d: %v", i, tc.src)
@@ -2417,24 +2421,14 @@ func TestParseData(t *testing.T) {
 	for i, s := range files {
 		//if i != 1 { continue }
 
-		file, err := os.Open(s.file)
+		b, err := readTestData(s.file)
 		if err != nil {
-			t.Error("os.Open(%s): %v", s.file, err)
-			continue
-		}
-		gz, err := gzip.NewReader(file)
-		if err != nil {
-			t.Error("gzip.NewReader(%s): %v", s.file, err)
-			continue
-		}
-		b, err := ioutil.ReadAll(gz)
-		if err != nil {
-			t.Error("ioutil.ReadAll(%s): %v", s.file, err)
+			t.Errorf("readTestData(%s): %v", s.file, err)
 			continue
 		}
 		wiki, err :=  Parse(b)
 		if err != nil {
-			t.Error("Parse(%s): %v", s.file, err)
+			t.Errorf("Parse(%s): %v", s.file, err)
 			continue
 		}
 
@@ -2447,3 +2441,214 @@ func TestParseData(t *testing.T) {
 		} /**/
 	}
 }
+
+func readTestData(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(gz)
+}
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct{
+		src string
+		opts ParseOptions
+		limit string
+	}{
+		{strings.Repeat("[[", 5000), ParseOptions{ MaxDepth: 100 }, "MaxDepth"},
+		{strings.Repeat("{{", 5000), ParseOptions{ MaxDepth: 100 }, "MaxDepth"},
+		{strings.Repeat("{{a|", 5000), ParseOptions{ MaxDepth: 100 }, "MaxDepth"},
+		{strings.Repeat("''a'''", 5000), ParseOptions{ MaxDepth: 100 }, ""},
+		{"[[a]] {{b|c}} ''d''", ParseOptions{ MaxDepth: 3 }, ""},
+		{strings.Repeat("{{a|b}}", 5000), ParseOptions{ MaxEntities: 1000 }, "MaxEntities"},
+		{strings.Repeat("text\n", 5000), ParseOptions{ MaxEntities: 1000 }, ""},
+		{strings.Repeat("* a\n", 5000), ParseOptions{ MaxEntities: 1000 }, "MaxEntities"},
+		{strings.Repeat("[[", 5000), ParseOptions{ MaxInputSize: 1000 }, "MaxInputSize"},
+		{"[[a]]", ParseOptions{ MaxInputSize: 5 }, ""},
+	}
+	for i, tc := range tests {
+		for _, reader := range []bool{ false, true } {
+			var err error
+			if reader {
+				opts := tc.opts
+				opts.ChunkSize = 64
+				_, err = ParseReader(strings.NewReader(tc.src), &opts)
+			} else {
+				_, err = ParseWithOptions([]byte(tc.src), &tc.opts)
+			}
+			if tc.limit == "" {
+				if err != nil {
+					t.Errorf("TestParseLimits: [%d, %v] %v", i, reader, err)
+				}
+				continue
+			}
+			e, ok := err.(*LimitError)
+			if !ok {
+				t.Errorf("TestParseLimits: [%d, %v] not a LimitError: %v", i, reader, err)
+				continue
+			}
+			if e.Limit != tc.limit || e.Offset < 0 || int64(len(tc.src)) < e.Offset {
+				t.Errorf("TestParseLimits: [%d, %v] %v", i, reader, e)
+			} else if e.Limit == "MaxInputSize" && e.Offset <= int64(e.Max) {
+				t.Errorf("TestParseLimits: [%d, %v] %v", i, reader, e)
+			}
+		}
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	src := []byte(strings.Repeat("{{a|", 100000))
+	if _, err := ParseWithOptions(src, &ParseOptions{ Context: ctx }); err != context.Canceled {
+		t.Errorf("TestParseContext: %v", err)
+	}
+	_, err := ParseReader(strings.NewReader(string(src)), &ParseOptions{ Context: ctx })
+	if err != context.Canceled {
+		t.Errorf("TestParseContext: reader: %v", err)
+	}
+	if _, err := ParseWithOptions(src, &ParseOptions{ Context: context.Background() }); err != nil {
+		t.Errorf("TestParseContext: %v", err)
+	}
+
+	// Many small entities, each shorter than checkInterval.
+	small := strings.Repeat("{{a}}\n", 100000)
+	if _, err := ParseWithOptions([]byte(small), &ParseOptions{ Context: ctx }); err != context.Canceled {
+		t.Errorf("TestParseContext: small: %v", err)
+	}
+	// The whole input is read in one chunk before it's canceled.
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	h := &cancelHandler{ cancel: cancel2 }
+	items := strings.Repeat("* a\n", 100000)
+	err = ParseReaderEvents(strings.NewReader(items), h, &ParseOptions{ Context: ctx2, ChunkSize: 1 << 20 })
+	if err != context.Canceled || 10 < h.n {
+		t.Errorf("TestParseContext: small: reader: %v (%d entities)", err, h.n)
+	}
+	err = ParseEventsWithOptions([]byte(small), new(treeHandler), &ParseOptions{ Context: ctx })
+	if err != context.Canceled {
+		t.Errorf("TestParseContext: small: events: %v", err)
+	}
+}
+
+// cancelHandler cancels the parsing at the first list item.
+type cancelHandler struct {
+	cancel context.CancelFunc
+	n int
+}
+
+func (h *cancelHandler) StartEntity(e *Entity) error { return nil }
+func (h *cancelHandler) EndEntity(e *Entity) error {
+	if e.Type == WikiEntityListBulleted {
+		h.n++
+		h.cancel()
+	}
+	return nil
+}
+func (h *cancelHandler) Text(e *Entity) error { return nil }
+
+// checkEntityRanges checks that the Raw of e and its children are slices of
+// data, and that the children are in order within the range of the parent,
+// or after the heading for the section of it. Pos is the offset of Raw in
+// data for the top-level entities and the sections, or in the Raw of the
+// parent for the others.
+func checkEntityRanges(t *testing.T, data []byte, e *Entity) {
+	offset := func(raw []byte) int {
+		if len(raw) == 0 {
+			return -1
+		}
+		off := cap(data) - cap(raw)
+		if off < 0 || len(data) < off + len(raw) || &data[off] != &raw[0] {
+			t.Fatalf("checkEntityRanges: %v is not in the input", string(raw))
+		}
+		return off
+	}
+	var check func(e *Entity)
+	check = func(e *Entity) {
+		beg, end := 0, len(data)
+		if e.Type != WikiEntityWiki {
+			if beg = offset(e.Raw); beg < 0 {
+				return
+			}
+			end = beg + len(e.Raw)
+		}
+		heading := WikiEntityHeading2 <= e.Type && e.Type <= WikiEntityHeading5
+		at, section := beg, e.Type == WikiEntityWiki
+		for _, child := range e.Entities {
+			off := offset(child.Raw)
+			if off < 0 {
+				check(child)
+				continue
+			}
+			if heading && !section && end <= off {
+				at, section = end, true // the section of the heading
+			}
+			pos := off - beg
+			if section {
+				end, pos = len(data), off
+			}
+			switch {
+			case off < at || end < off + len(child.Raw):
+				t.Fatalf("checkEntityRanges: %v (%d) is out of %v (%d, %d)", child, off, e, at, end)
+			case child.Pos != pos:
+				t.Fatalf("checkEntityRanges: %v in %v: Pos %d != %d", child, e, child.Pos, pos)
+			}
+			switch child.Type {
+			case WikiEntityLinkInternalName, WikiEntityLinkInternalProp, WikiEntityTemplateName, WikiEntityTemplateProp:
+				// the closing "]]" or "}}" is not in the names and the props
+				_, b := innerRange(e)
+				if (e.Type == WikiEntityLinkInternal || e.Type == WikiEntityTemplate) && beg + b < off + len(child.Raw) {
+					t.Fatalf("checkEntityRanges: %v has the closing markup of %v", child, e)
+				}
+			}
+			at = off + len(child.Raw)
+			check(child)
+		}
+	}
+	check(e)
+}
+
+// addFuzzSeeds adds the testdata to the seed corpus of f. Files are split
+// into sections as the fuzzing engine is very slow with large inputs.
+func addFuzzSeeds(f *testing.F) {
+	files, _ := filepath.Glob("testdata/*.wiki.gz")
+	for _, file := range files {
+		data, err := readTestData(file)
+		if err != nil {
+			f.Fatalf("readTestData(%s): %v", file, err)
+		}
+		for 0 < len(data) {
+			n := strings.Index(string(data[1:]), "\n==") + 1
+			if n <= 0 {
+				n = len(data)
+			}
+			f.Add(data[0:n])
+			data = data[n:]
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Add([]byte("''a'''''A''' '''a'''''A'' [[a|b]] {{a|b=c}} <a b=\"c\">d</a>"))
+	f.Add([]byte("''\n#[0"))
+	f.Add([]byte("#0''[]\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		wiki, err := Parse(data)
+		if err != nil {
+			return
+		}
+		checkEntityRanges(t, data, wiki)
+
+		wiki, err = ParseWithOptions(data, &ParseOptions{ Paragraphs: true })
+		if err != nil {
+			t.Fatalf("Paragraphs: %v", err)
+		}
+		checkEntityRanges(t, data, wiki)
+	})
+}
@@ -0,0 +1,495 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A selector finds the entities of a tree the way of CSS selectors, e.g.
+//
+//	heading2[text=English] heading4[text=Pronunciation] template[name=IPA]
+//
+// The types are the names of the entity types without "WikiEntity", in any
+// case, e.g. "heading2" or "linkinternal", "heading" is of all headings and
+// "*" is of any type. The attributes are:
+//
+//	text	the Text without the spaces around, e.g. the title of a heading
+//	raw	the Raw
+//	name	the name of a template (e.g. "IPA"), a magic word (e.g.
+//		"PAGENAME"), an internal link (the title), a tag or an external
+//		link (the URL)
+//
+// The names of the templates and the links are compared as titles by "="
+// and "!=", e.g. [name=iPA] is of {{IPA}} unless the names of the
+// templates are case-sensitive, see TitleOptions.
+//
+// The other attributes are the arguments of templates, e.g. [lang=en] or
+// [1=en]. The operators of attributes are "=", "!=", "^=" (prefix), "$="
+// (suffix), "*=" (substring) and "~=" (a word), [attr] tells if there's
+// the attribute. The values may be quoted, e.g. [text="a b"]. The
+// combinators are " " (descendant) and ">" (child), the pseudo-classes are
+// :nth-child(an+b), :first-child and :last-child, of which the texts are
+// not counted as the text nodes of CSS. The selectors separated by commas
+// are of any of them. The sections are the children of the
+// headings, a heading of a lower level is a descendant of the heading.
+
+// A Selector is a parsed selector, see ParseSelector.
+type Selector struct {
+	groups [][]*selectorStep
+}
+
+type selectorStep struct {
+	child bool // the combinator before it is '>'
+	types []EntityType // any type if nil
+	attrs []selectorAttr
+	nth []selectorNth
+}
+
+type selectorAttr struct {
+	name, op, value string
+}
+
+// selectorNth is :nth-child(an+b), or :last-child if last is true.
+type selectorNth struct {
+	a, b int
+	last bool
+}
+
+// selectorTypes are the entity types of the selector names.
+var selectorTypes = make(map[string][]EntityType)
+
+func init() {
+	for t, name := range entityTypeNames {
+		name = strings.ToLower(strings.TrimPrefix(name, "WikiEntity"))
+		selectorTypes[name] = []EntityType{ EntityType(t) }
+	}
+	selectorTypes["heading"] = []EntityType{ WikiEntityHeading2, WikiEntityHeading3, WikiEntityHeading4, WikiEntityHeading5 }
+}
+
+// selectorParser parses a selector.
+type selectorParser struct {
+	s string
+	pos int
+}
+
+func (p *selectorParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("wiki: selector %q at %d: %s", p.s, p.pos, fmt.Sprintf(format, a...))
+}
+
+func (p *selectorParser) spaces() bool {
+	n := p.pos
+	for p.pos < len(p.s) && isSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return n < p.pos
+}
+
+func isSelectorNameChar(c byte) bool {
+	return isLetter(c) || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.'
+}
+
+func (p *selectorParser) name() string {
+	n := p.pos
+	for p.pos < len(p.s) && isSelectorNameChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[n:p.pos]
+}
+
+// value parses a value of an attribute, quoted or until ']'.
+func (p *selectorParser) value() (string, error) {
+	if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+		q := p.s[p.pos]
+		i := strings.IndexByte(p.s[p.pos+1:], q)
+		if i < 0 {
+			return "", p.errorf("unclosed quote")
+		}
+		s := p.s[p.pos+1:p.pos+1+i]
+		p.pos += i + 2
+		return s, nil
+	}
+	i := strings.IndexByte(p.s[p.pos:], ']')
+	if i < 0 {
+		return "", p.errorf("unclosed '['")
+	}
+	s := strings.TrimSpace(p.s[p.pos:p.pos+i])
+	p.pos += i
+	return s, nil
+}
+
+func (p *selectorParser) attr() (a selectorAttr, err error) {
+	p.pos++ // '['
+	p.spaces()
+	if a.name = p.name(); a.name == "" {
+		return a, p.errorf("expect an attribute name")
+	}
+	p.spaces()
+	for _, op := range []string{ "=", "!=", "^=", "$=", "*=", "~=" } {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			a.op = op
+			p.pos += len(op)
+			p.spaces()
+			if a.value, err = p.value(); err != nil {
+				return
+			}
+			p.spaces()
+			break
+		}
+	}
+	if !strings.HasPrefix(p.s[p.pos:], "]") {
+		return a, p.errorf("expect ']'")
+	}
+	p.pos++
+	return
+}
+
+// nth parses the an+b of :nth-child, e.g. "2n+1", "odd", "3".
+func (p *selectorParser) nth() (nth selectorNth, err error) {
+	i := strings.IndexByte(p.s[p.pos:], ')')
+	if !strings.HasPrefix(p.s[p.pos:], "(") || i < 0 {
+		return nth, p.errorf("expect (an+b)")
+	}
+	s := strings.ToLower(strings.Join(strings.Fields(p.s[p.pos+1:p.pos+i]), ""))
+	p.pos += i + 1
+	switch s {
+	case "odd":
+		return selectorNth{ a: 2, b: 1 }, nil
+	case "even":
+		return selectorNth{ a: 2 }, nil
+	}
+	a, b := "0", s
+	if i := strings.IndexByte(s, 'n'); 0 <= i {
+		a, b = s[0:i], s[i+1:]
+		switch a {
+		case "", "+": a = "1"
+		case "-": a = "-1"
+		}
+		if b == "" {
+			b = "0"
+		}
+	}
+	if nth.a, err = strconv.Atoi(a); err == nil {
+		nth.b, err = strconv.Atoi(b)
+	}
+	if err != nil {
+		return nth, p.errorf("bad :nth-child(%s)", s)
+	}
+	return
+}
+
+// step parses a compound selector, e.g. template[name=IPA]:first-child.
+func (p *selectorParser) step() (*selectorStep, error) {
+	step, star := new(selectorStep), false
+	if strings.HasPrefix(p.s[p.pos:], "*") {
+		p.pos, star = p.pos + 1, true
+	} else if name := p.name(); name != "" {
+		types, ok := selectorTypes[strings.ToLower(strings.TrimPrefix(name, "WikiEntity"))]
+		if !ok {
+			return nil, p.errorf("unknown type %q", name)
+		}
+		step.types = types
+	}
+	for p.pos < len(p.s) {
+		switch {
+		case p.s[p.pos] == '[':
+			a, err := p.attr()
+			if err != nil {
+				return nil, err
+			}
+			step.attrs = append(step.attrs, a)
+		case strings.HasPrefix(p.s[p.pos:], ":nth-child"):
+			p.pos += len(":nth-child")
+			nth, err := p.nth()
+			if err != nil {
+				return nil, err
+			}
+			step.nth = append(step.nth, nth)
+		case strings.HasPrefix(p.s[p.pos:], ":first-child"):
+			p.pos += len(":first-child")
+			step.nth = append(step.nth, selectorNth{ b: 1 })
+		case strings.HasPrefix(p.s[p.pos:], ":last-child"):
+			p.pos += len(":last-child")
+			step.nth = append(step.nth, selectorNth{ last: true })
+		case p.s[p.pos] == ':':
+			return nil, p.errorf("unknown pseudo-class")
+		default:
+			return step, p.check(step, star)
+		}
+	}
+	return step, p.check(step, star)
+}
+
+// check returns an error if the step is empty.
+func (p *selectorParser) check(step *selectorStep, star bool) error {
+	if !star && step.types == nil && step.attrs == nil && step.nth == nil {
+		return p.errorf("expect a selector")
+	}
+	return nil
+}
+
+// ParseSelector parses the selector s.
+func ParseSelector(s string) (*Selector, error) {
+	p := &selectorParser{ s: s }
+	sel := new(Selector)
+	var group []*selectorStep
+	child := false
+	for p.spaces(); ; {
+		if strings.HasPrefix(p.s[p.pos:], ">") {
+			p.pos++
+			p.spaces()
+			child = true
+		}
+		if p.pos == len(p.s) {
+			return nil, p.errorf("expect a selector")
+		}
+		step, err := p.step()
+		if err != nil {
+			return nil, err
+		}
+		step.child, child = child, false
+		group = append(group, step)
+
+		sp := p.spaces()
+		switch {
+		case p.pos == len(p.s):
+			sel.groups = append(sel.groups, group)
+			return sel, nil
+		case p.s[p.pos] == ',':
+			p.pos++
+			p.spaces()
+			sel.groups, group = append(sel.groups, group), nil
+		case p.s[p.pos] == '>', sp: // child or descendant
+		default:
+			return nil, p.errorf("unexpected %q", p.s[p.pos])
+		}
+	}
+}
+
+// MustParseSelector is ParseSelector, it panics if the selector s is bad.
+func MustParseSelector(s string) *Selector {
+	sel, err := ParseSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+// templateName returns the name attribute of the template of the target t.
+func templateName(t *TemplateTarget) string {
+	switch t.Kind {
+	case TemplateTransclusion:
+		return strings.TrimPrefix(t.Title, t.Namespace + ":")
+	case TemplatePage:
+		return t.Title
+	}
+	return t.Name
+}
+
+// selectorName returns the name attribute of e.
+func selectorName(e *Entity) (string, bool) {
+	switch e.Type {
+	case WikiEntityTemplate:
+		t, err := e.TemplateTarget()
+		if err != nil {
+			return "", false
+		}
+		return templateName(t), true
+	case WikiEntityMagicWord:
+		return e.Text, true
+	case WikiEntityLinkInternal:
+		t, err := e.Title(nil)
+		if err != nil {
+			return "", false
+		}
+		return t.PrefixedText(), true
+	case WikiEntityTag, WikiEntityTagBeg, WikiEntityTagEnd:
+		name, _ := tagName(strings.TrimPrefix(e.Text, "/"))
+		return name, true
+	case WikiEntityLinkExternal:
+		if u, _ := externalLinkParts(e); u != nil {
+			return string(u.Raw), true
+		}
+	case WikiEntityLinkURL:
+		return e.Text, true
+	}
+	return "", false
+}
+
+// normalName returns the name value of a selector normalized the same way
+// as the name attribute of the template or the link e, e.g. "IPA" of "iPA"
+// unless the names of the templates are case-sensitive.
+func normalName(e *Entity, value string) string {
+	switch e.Type {
+	case WikiEntityTemplate:
+		_, props := templateParts(e)
+		if t, err := templateTarget(value, len(props), e.titleOptions()); err == nil {
+			return templateName(t)
+		}
+	case WikiEntityLinkInternal:
+		if t, err := ParseTitle(value, e.titleOptions()); err == nil {
+			return t.PrefixedText()
+		}
+	}
+	return value
+}
+
+// selectorValue returns the value of the attribute name of e.
+func selectorValue(e *Entity, name string) (string, bool) {
+	switch name {
+	case "text":
+		return strings.TrimSpace(e.Text), true
+	case "raw":
+		return string(e.Raw), true
+	case "name":
+		return selectorName(e)
+	}
+	if e.Type == WikiEntityTemplate || e.Type == WikiEntityMagicWord {
+		args, _ := e.TemplateArgs()
+		if arg := args.Get(name); arg != nil {
+			return strings.TrimSpace(arg.Value.Text), true
+		}
+	}
+	return "", false
+}
+
+func (a selectorAttr) match(e *Entity) bool {
+	s, ok := selectorValue(e, a.name)
+	if !ok {
+		return false
+	}
+	value := a.value
+	if a.name == "name" && (a.op == "=" || a.op == "!=") {
+		value = normalName(e, value)
+	}
+	switch a.op {
+	case "=":	return s == value
+	case "!=":	return s != value
+	case "^=":	return strings.HasPrefix(s, value)
+	case "$=":	return strings.HasSuffix(s, value)
+	case "*=":	return strings.Contains(s, value)
+	case "~=":
+		for _, w := range strings.Fields(s) {
+			if w == value {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (n selectorNth) match(k, count int) bool {
+	switch {
+	case k == 0:
+		return false // a text
+	case n.last:
+		return k == count
+	case n.a == 0:
+		return k == n.b
+	}
+	return (k - n.b) % n.a == 0 && 0 <= (k - n.b) / n.a
+}
+
+// match tells if the entity e, the k-th of count children of the parent
+// except the texts, matches the step. k is 0 if e is a text.
+func (step *selectorStep) match(e *Entity, k, count int) bool {
+	if step.types != nil {
+		found := false
+		for _, t := range step.types {
+			found = found || e.Type == t
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, a := range step.attrs {
+		if !a.match(e) {
+			return false
+		}
+	}
+	for _, n := range step.nth {
+		if !n.match(k, count) {
+			return false
+		}
+	}
+	return true
+}
+
+// query returns the descendants of root matching the steps in document
+// order.
+func query(root *Entity, steps []*selectorStep) []*Entity {
+	matched := map[*Entity]bool{ root: true }
+	var res []*Entity
+	for _, step := range steps {
+		res = nil
+		next := make(map[*Entity]bool)
+		var walk func(e *Entity, ancestors int)
+		walk = func(e *Entity, ancestors int) {
+			if matched[e] {
+				ancestors++
+			}
+			count := 0
+			for _, child := range e.Entities {
+				if child.Type != WikiEntityText {
+					count++
+				}
+			}
+			k := 0
+			for _, child := range e.Entities {
+				n := 0
+				if child.Type != WikiEntityText {
+					k++
+					n = k
+				}
+				if (matched[e] || !step.child && 0 < ancestors) && step.match(child, n, count) {
+					next[child] = true
+					res = append(res, child)
+				}
+				walk(child, ancestors)
+			}
+		}
+		walk(root, 0)
+		matched = next
+	}
+	return res
+}
+
+// Query returns the descendants of root matching the selector in document
+// order.
+func (sel *Selector) Query(root *Entity) []*Entity {
+	if len(sel.groups) == 1 {
+		return query(root, sel.groups[0])
+	}
+	found := make(map[*Entity]bool)
+	for _, steps := range sel.groups {
+		for _, e := range query(root, steps) {
+			found[e] = true
+		}
+	}
+	var res []*Entity
+	var walk func(e *Entity)
+	walk = func(e *Entity) {
+		for _, child := range e.Entities {
+			if found[child] {
+				res = append(res, child)
+			}
+			walk(child)
+		}
+	}
+	walk(root)
+	return res
+}
+
+// Query returns the descendants of root matching the selector, e.g.
+// "heading2[text=English] template[name=IPA]", see Selector.
+func Query(root *Entity, selector string) ([]*Entity, error) {
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return sel.Query(root), nil
+}
@@ -0,0 +1,93 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	src := `{{also|A}}
+==Translingual==
+===Pronunciation===
+* {{IPA|/a/|lang=mul}}
+==English==
+===Etymology 1===
+From [[w:Latin|Latin]] {{term|a|lang=la}}.
+====Pronunciation====
+* {{a|UK}} {{IPA|/eɪ/}}
+** {{IPA|/æɪ/}} <ref>x</ref>
+===Etymology 2===
+====Pronunciation====
+* {{iPA|/ə/}}, {{IPAchar|/ɑː/}}
+====Noun====
+# [[letter]]`
+	wiki, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("TestQuery: %v", err)
+	}
+	tests := []struct{
+		selector string
+		results []string
+	}{
+		{ `heading2[text=English] heading4[text=Pronunciation] template[name=IPA]`, []string{ `IPA|/eɪ/`, `IPA|/æɪ/`, `iPA|/ə/` } },
+		{ `heading4[text=Pronunciation] > listbulleted > template[name=IPA]`, []string{ `IPA|/eɪ/`, `iPA|/ə/` } },
+		{ `WikiEntityTemplate[lang]`, []string{ `IPA|/a/|lang=mul`, `term|a|lang=la` } },
+		{ `template[lang!=mul][lang]`, []string{ `term|a|lang=la` } },
+		{ `template[1="/eɪ/"], template[1='/a/']`, []string{ `IPA|/a/|lang=mul`, `IPA|/eɪ/` } },
+		{ `template[name^=IPA]`, []string{ `IPA|/a/|lang=mul`, `IPA|/eɪ/`, `IPA|/æɪ/`, `iPA|/ə/`, `IPAchar|/ɑː/` } },
+		{ `template[name$=char] , linkinternal[name*=Lat]`, []string{ `w:Latin|Latin`, `IPAchar|/ɑː/` } },
+		{ `heading3[text~=2] > *:first-child`, []string{ `Pronunciation` } },
+		{ `heading3[text~=2] > :last-child`, []string{ `Noun` } },
+		{ `heading2:nth-child(2n+1)`, []string{ `English` } },
+		{ `heading:nth-child(1)`, []string{ `Pronunciation`, `Etymology 1`, `Pronunciation` } },
+		{ `> heading2 > heading3`, []string{ `Pronunciation`, `Etymology 1`, `Etymology 2` } },
+		{ `> heading3`, nil },
+		{ `tagbeg[name=ref]`, []string{ `ref` } },
+		{ `heading2[raw^="==E"] listnumbered linkinternal`, []string{ `letter` } },
+	}
+	for i, test := range tests {
+		res, err := Query(wiki, test.selector)
+		if err != nil {
+			t.Errorf("TestQuery: [%d] %v", i, err)
+			continue
+		}
+		var texts []string
+		for _, e := range res {
+			texts = append(texts, strings.TrimSpace(e.Text))
+		}
+		if strings.Join(texts, "\n") != strings.Join(test.results, "\n") {
+			t.Errorf("TestQuery: [%d] %s: expect %q, got %q", i, test.selector, test.results, texts)
+		}
+	}
+
+	site, err := LoadSiteConfigFile("testdata/siteinfo.json")
+	if err != nil {
+		t.Fatalf("TestQuery: %v", err)
+	}
+	sited, _ := ParseWithOptions([]byte(src), &ParseOptions{ Site: site })
+	for i, test := range []struct{
+		selector string
+		n, sited int // the number of the results without and with the site
+	}{
+		{ `template[name=IPA]`, 4, 3 },
+		{ `template[name=iPA]`, 4, 1 },
+		{ `template[name!=iPA][name^=IPA]`, 1, 4 },
+		{ `linkinternal[name=Letter]`, 1, 0 },
+		{ `linkinternal[name=letter]`, 1, 1 },
+	} {
+		res, _ := Query(wiki, test.selector)
+		res2, _ := Query(sited, test.selector)
+		if len(res) != test.n || len(res2) != test.sited {
+			t.Errorf("TestQuery: [%d] %s: expect %d and %d results, got %d and %d", i, test.selector, test.n, test.sited, len(res), len(res2))
+		}
+	}
+
+	for i, s := range []string{ ``, `heading2 >`, `foo`, `template[`, `template[name=x`, `template[name="x]`, `*:nth-child(x)`, `*:hover`, `template,`, `a|b` } {
+		if _, err := ParseSelector(s); err == nil {
+			t.Errorf("TestQuery: [%d] expect an error of %q", i, s)
+		}
+	}
+}
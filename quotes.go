@@ -0,0 +1,549 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+)
+
+// The bold and italic entities are made after scanning, line by line, the
+// same way as doQuotes of MediaWiki's Parser.php:
+//
+//	''''		an apostrophe followed by bold: ' + '''
+//	''''''...	apostrophes followed by bold italic: '... + '''''
+//	''a'''''A'''	italic + bold
+//	'''a'''''A''	bold + italic
+//	'''''a''b'''	bold containing italic
+//	'''''a'''b''	italic containing bold
+//
+// If a line has an odd number of both bold and italic markups, one of the
+// bold markups is taken as an apostrophe followed by italic, preferably the
+// one after a single-letter word, e.g. l'''italic''. Markups not closed are
+// closed at the end of the line.
+
+// quoteEvent is an apostrophe run, a child entity or a line end within the
+// range being processed.
+type quoteEvent struct {
+	beg, end int // range in the Raw of the container
+	child *Entity // the child entity if not nil
+	eol bool
+
+	// markups of the apostrophe run: Raw[mark:end], the others are text
+	mark int
+}
+
+// quoteBuilder builds the bold and italic entities of a container.
+type quoteBuilder struct {
+	raw []byte
+	root []*Entity // the new children of the container
+	stack []*Entity
+	begs, marks []int // range and markup size of the entities in stack
+
+	both int // offset of the pending ''''', or -1
+	pending []*Entity // children after the pending '''''
+}
+
+func (b *quoteBuilder) state() string {
+	s := ""
+	for _, e := range b.stack {
+		switch e.Type {
+		case WikiEntityTextBold:	s += "b"
+		case WikiEntityTextItalic:	s += "i"
+		}
+	}
+	return s
+}
+
+func (b *quoteBuilder) attach(e *Entity) {
+	if n := len(b.stack); 0 < n {
+		b.stack[n-1].Entities = append(b.stack[n-1].Entities, e)
+	} else {
+		b.root = append(b.root, e)
+	}
+}
+
+func (b *quoteBuilder) child(e *Entity) {
+	if 0 <= b.both {
+		b.pending = append(b.pending, e)
+	} else {
+		b.attach(e)
+	}
+}
+
+// open starts an entity of type t at pos with m bytes of markups.
+func (b *quoteBuilder) open(t EntityType, pos, m int) {
+	e := &Entity{ Type: t }
+	b.attach(e)
+	b.stack = append(b.stack, e)
+	b.begs, b.marks = append(b.begs, pos), append(b.marks, m)
+}
+
+// close ends the innermost entity at pos with c bytes of markups.
+func (b *quoteBuilder) close(pos, c int) {
+	n := len(b.stack) - 1
+	e, beg, m := b.stack[n], b.begs[n], b.marks[n]
+	b.stack, b.begs, b.marks = b.stack[0:n], b.begs[0:n], b.marks[0:n]
+	if a, z := beg + m, pos - c; a <= z {
+		e.Text = string(b.raw[a:z])
+	}
+	e.Raw = b.raw[beg:pos]
+}
+
+// resolve makes the entities of the pending ''''' closed at pos by a
+// markup of n apostrophes.
+func (b *quoteBuilder) resolve(pos, n int) {
+	beg := b.both
+	b.both = -1
+	switch n {
+	case 2:
+		b.open(WikiEntityTextBold, beg, 3)
+		b.open(WikiEntityTextItalic, beg + 3, 2)
+	case 3:
+		b.open(WikiEntityTextItalic, beg, 2)
+		b.open(WikiEntityTextBold, beg + 2, 3)
+	default:
+		b.open(WikiEntityTextBoldItalic, beg, 5)
+	}
+	for _, e := range b.pending {
+		b.attach(e)
+	}
+	b.pending = b.pending[0:0]
+	b.close(pos, n)
+}
+
+// markup handles the markups Raw[pos:end].
+func (b *quoteBuilder) markup(pos, end int) {
+	switch n, state := end - pos, b.state(); {
+	case 0 <= b.both:
+		b.resolve(end, n)
+	case n == 2:
+		switch state {
+		case "i", "bi":
+			b.close(end, 2)
+		case "ib":
+			b.close(pos, 0)
+			b.close(end, 2)
+			b.open(WikiEntityTextBold, end, 0)
+		default:
+			b.open(WikiEntityTextItalic, pos, 2)
+		}
+	case n == 3:
+		switch state {
+		case "b", "ib":
+			b.close(end, 3)
+		case "bi":
+			b.close(pos, 0)
+			b.close(end, 3)
+			b.open(WikiEntityTextItalic, end, 0)
+		default:
+			b.open(WikiEntityTextBold, pos, 3)
+		}
+	default:
+		switch state {
+		case "b":
+			b.close(pos + 3, 3)
+			b.open(WikiEntityTextItalic, pos + 3, 2)
+		case "i":
+			b.close(pos + 2, 2)
+			b.open(WikiEntityTextBold, pos + 2, 3)
+		case "bi":
+			b.close(pos + 2, 2)
+			b.close(end, 3)
+		case "ib":
+			b.close(pos + 3, 3)
+			b.close(end, 2)
+		default:
+			b.both = pos
+		}
+	}
+}
+
+// eol closes all entities at the end of a line.
+func (b *quoteBuilder) eol(pos int) {
+	if 0 <= b.both {
+		b.resolve(pos, 0)
+	}
+	for 0 < len(b.stack) {
+		b.close(pos, 0)
+	}
+}
+
+// adjustQuotes decides the markups of the apostrophe runs of a line.
+func adjustQuotes(raw []byte, line int, runs []*quoteEvent) {
+	bold, italic := 0, 0
+	for _, r := range runs {
+		switch n := r.end - r.beg; {
+		case n == 4:
+			r.mark = r.beg + 1
+		case 5 < n:
+			r.mark = r.end - 5
+		default:
+			r.mark = r.beg
+		}
+		switch r.end - r.mark {
+		case 2: italic++
+		case 3: bold++
+		case 5: italic++; bold++
+		}
+	}
+	if bold % 2 == 0 || italic % 2 == 0 {
+		return
+	}
+
+	singleLetter, multiLetter, space := -1, -1, -1
+	for i, r := range runs {
+		if r.end - r.mark != 3 {
+			continue
+		}
+		a := line
+		if 0 < i {
+			a = runs[i-1].end
+		}
+		before := raw[a:r.mark]
+		var x1, x2 byte
+		if n := len(before); 0 < n {
+			x1, x2 = before[n-1], before[0]
+			if 1 < n {
+				x2 = before[n-2]
+			}
+		}
+		if x1 == ' ' {
+			if space < 0 {
+				space = i
+			}
+		} else if x2 == ' ' {
+			singleLetter = i
+			break
+		} else if multiLetter < 0 {
+			multiLetter = i
+		}
+	}
+	for _, i := range []int{ singleLetter, multiLetter, space } {
+		if 0 <= i {
+			runs[i].mark++
+			break
+		}
+	}
+}
+
+// quoteEvents returns the events of the range [a, b) of e.Raw, children out
+// of the range are returned as rest.
+func quoteEvents(e *Entity, a, b int) (events []*quoteEvent, rest []*Entity, found bool) {
+	at := a
+	text := func(end int) {
+		for i := at; i < end; i++ {
+			switch e.Raw[i] {
+			case '\n':
+				events = append(events, &quoteEvent{ beg: i, end: i, eol: true })
+			case '\'':
+				n := i + 1
+				for n < end && e.Raw[n] == '\'' {
+					n++
+				}
+				if 1 < n - i {
+					events = append(events, &quoteEvent{ beg: i, end: n })
+					found = true
+				}
+				i = n - 1
+			}
+		}
+		at = end
+	}
+	for _, child := range e.Entities {
+		off := rawOffset(e, child)
+		if off < at || b < off + len(child.Raw) {
+			// the bytes of the child are not text anyway
+			if off < b && at < off + len(child.Raw) {
+				if at < off {
+					text(off)
+				}
+				at = off + len(child.Raw)
+			}
+			rest = append(rest, child)
+			continue
+		}
+		text(off)
+		events = append(events, &quoteEvent{ beg: off, end: off + len(child.Raw), child: child })
+		at = off + len(child.Raw)
+	}
+	text(b)
+	return
+}
+
+// doQuotes makes the bold and italic entities in the range [a, b) of e.Raw,
+// it returns false if there is none.
+func doQuotes(e *Entity, a, b int) bool {
+	if bytes.Index(e.Raw[a:b], []byte("''")) < 0 {
+		return false
+	}
+	events, rest, found := quoteEvents(e, a, b)
+	if !found {
+		return false
+	}
+
+	// adjust the markups line by line
+	line, runs := a, []*quoteEvent(nil)
+	for _, ev := range append(events, &quoteEvent{ beg: b, end: b, eol: true }) {
+		switch {
+		case ev.eol:
+			adjustQuotes(e.Raw, line, runs)
+			line, runs = ev.end + 1, runs[0:0]
+		case ev.child == nil:
+			runs = append(runs, ev)
+		}
+	}
+
+	qb := &quoteBuilder{ raw: e.Raw, both: -1 }
+	for _, ev := range events {
+		switch {
+		case ev.eol:
+			qb.eol(ev.beg)
+		case ev.child != nil:
+			qb.child(ev.child)
+		case ev.mark < ev.end:
+			qb.markup(ev.mark, ev.end)
+		}
+	}
+	qb.eol(b)
+
+	var before, after []*Entity
+	for _, child := range rest {
+		if rawOffset(e, child) < a {
+			before = append(before, child)
+		} else {
+			after = append(after, child)
+		}
+	}
+	e.Entities = append(append(before, qb.root...), after...)
+	for _, child := range qb.root {
+		quotePos(e, child)
+	}
+	return true
+}
+
+// quotePos sets Pos of the bold and italic entities made by doQuotes and
+// their children, which is the offset in the Raw of the parent.
+func quotePos(parent, e *Entity) {
+	switch e.Type {
+	case WikiEntityTextBold, WikiEntityTextItalic, WikiEntityTextBoldItalic:
+		if off := rawOffset(parent, e); 0 <= off {
+			e.Pos = off
+		}
+		for _, child := range e.Entities {
+			if off := rawOffset(e, child); 0 <= off {
+				child.Pos = off
+			}
+			quotePos(e, child)
+		}
+	}
+}
+
+// quotes makes the bold and italic entities in the tree of e.
+func quotes(e *Entity) {
+	for _, child := range e.Entities {
+		quotes(child)
+	}
+	switch e.Type {
+	case WikiEntityWiki, WikiEntityText:
+		return
+	}
+	a, b := innerRange(e)
+	doQuotes(e, a, b)
+}
+
+func isBlock(e *Entity) bool {
+	switch e.Type {
+	case WikiEntityHeading2, WikiEntityHeading3, WikiEntityHeading4, WikiEntityHeading5,
+		WikiEntityListBulleted, WikiEntityListNumbered, WikiEntityIndent, WikiEntityHR,
+		WikiEntityRedirect:
+		return true
+	}
+	return false
+}
+
+// quoter makes the bold and italic entities of the top-level entities being
+// parsed before passing them to fn. Inline entities are held until a line
+// is done, as the markups may be in different entities, e.g. '''[[a]]'''.
+// The link trails are absorbed before, as in MediaWiki.
+type quoter struct {
+	fn func(e *Entity) error
+	line []*Entity
+	trail *regexp.Regexp
+}
+
+func (q *quoter) entity(e *Entity) error {
+	linkTrails(e, q.trail)
+	quotes(e)
+	if !isBlock(e) {
+		q.line = append(q.line, e)
+		return nil
+	}
+	if err := q.flush(); err != nil {
+		return err
+	}
+	return q.fn(e)
+}
+
+// join returns the Raw of the entities as a whole, they are copied if not
+// slices of the same array, e.g. by ParseReader with CopyRaw.
+func join(entities []*Entity) []byte {
+	first, last := entities[0].Raw, entities[len(entities)-1].Raw
+	same := 0 < cap(first) && 0 < cap(last) && cap(last) <= cap(first) &&
+		&first[0:cap(first)][cap(first)-1] == &last[0:cap(last)][cap(last)-1]
+	for i := 1; same && i < len(entities); i++ {
+		a, b := entities[i-1].Raw, entities[i].Raw
+		same = 0 < cap(b) && cap(b) <= cap(a) - len(a) &&
+			&a[0:cap(a)][cap(a)-1] == &b[0:cap(b)][cap(b)-1]
+	}
+	if same {
+		return first[0 : cap(first) - cap(last) + len(last)]
+	}
+
+	// The copies are placed by the positions, the gaps are the newlines
+	// stripped from the entities.
+	base, at := entities[0].Pos, 0
+	for _, e := range entities {
+		if e.Pos - base < at {
+			base = -1
+			break
+		}
+		at = e.Pos - base + len(e.Raw)
+	}
+	var buf []byte
+	if 0 <= base {
+		buf = bytes.Repeat([]byte("\n"), at)
+		for _, e := range entities {
+			copy(buf[e.Pos-base:], e.Raw)
+		}
+	} else {
+		for _, e := range entities {
+			buf = append(buf, e.Raw...)
+		}
+	}
+	off := 0
+	for _, e := range entities {
+		if 0 <= base {
+			off = e.Pos - base
+		}
+		n := len(e.Raw)
+		remapRaw(e, e.Raw, buf[off:off+n])
+		off += n
+	}
+	return buf
+}
+
+// remapRaw makes the Raw of the tree of e, which are slices of from, be the
+// slices of to at the same offsets.
+func remapRaw(e *Entity, from, to []byte) {
+	if off := cap(from) - cap(e.Raw); e.Raw != nil && 0 <= off && off + len(e.Raw) <= len(from) {
+		e.Raw = to[off:off+len(e.Raw)]
+	}
+	for _, child := range e.Entities {
+		remapRaw(child, from, to)
+	}
+}
+
+func (q *quoter) flush() (err error) {
+	line := lineTrails(q.line, q.trail)
+	q.line = q.line[0:0]
+
+	found := false
+	for _, e := range line {
+		if e.Type == WikiEntityText && bytes.Contains(e.Raw, []byte("''")) {
+			found = true
+			break
+		}
+	}
+	if found {
+		line = quoteLine(line)
+	}
+	for _, e := range line {
+		if err = q.fn(e); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// container returns an entity of the top-level entities line as a whole,
+// the Text entities are returned as texts and the others are the children.
+func container(line []*Entity) (c *Entity, texts []*Entity) {
+	c = &Entity{ Type: WikiEntityWiki, Pos: line[0].Pos, Raw: join(line) }
+	for _, e := range line {
+		if e.Type == WikiEntityText {
+			texts = append(texts, e)
+		} else {
+			c.Entities = append(c.Entities, e)
+		}
+	}
+	return
+}
+
+// pieces appends the top-level entities within c.Raw[a:b] to res in order,
+// which are the children of c and the pieces of texts, c is made by
+// container.
+func pieces(res []*Entity, c *Entity, texts []*Entity, a, b int) []*Entity {
+	// The entities are in order, the search skips those before a.
+	i := sort.Search(len(texts), func(i int) bool {
+		return a < rawOffset(c, texts[i]) + len(texts[i].Raw)
+	})
+	text := func(a, b int) {
+		for ; i < len(texts); i++ {
+			t := texts[i]
+			off := rawOffset(c, t)
+			if b <= off {
+				return
+			}
+			x, y := off, off + len(t.Raw)
+			if x < a { x = a }
+			if b < y { y = b }
+			switch {
+			case off < 0 || y <= x:
+			case x == off && y == off + len(t.Raw):
+				res = append(res, t)
+			default:
+				res = append(res, &Entity{ Type: WikiEntityText, Pos: c.Pos + x, Raw: c.Raw[x:y], Text: string(c.Raw[x:y]) })
+			}
+			if b < off + len(t.Raw) {
+				return // the rest is after b
+			}
+		}
+	}
+	children := c.Entities[sort.Search(len(c.Entities), func(i int) bool {
+		return a <= rawOffset(c, c.Entities[i])
+	}):]
+	at := a
+	for _, e := range children {
+		off := rawOffset(c, e)
+		if b < off + len(e.Raw) {
+			break
+		}
+		if off < at {
+			continue
+		}
+		text(at, off)
+		res = append(res, e)
+		at = off + len(e.Raw)
+	}
+	text(at, b)
+	return res
+}
+
+// quoteLine makes the bold and italic entities of a line of top-level
+// entities.
+func quoteLine(line []*Entity) []*Entity {
+	c, texts := container(line)
+	if !doQuotes(c, 0, len(c.Raw)) {
+		return line
+	}
+	for _, e := range c.Entities {
+		switch e.Type {
+		case WikiEntityTextBold, WikiEntityTextItalic, WikiEntityTextBoldItalic:
+			e.Pos = c.Pos + rawOffset(c, e)
+		}
+	}
+	return pieces(nil, c, texts, 0, len(c.Raw))
+}
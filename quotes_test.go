@@ -0,0 +1,230 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestQuotes(t *testing.T) {
+	tests := []struct{
+		src string
+		entities []*entityTestResult
+	}{
+		/***** 0 *****/
+		{`''Italic text''`,
+			[]*entityTestResult{
+				{WikiEntityTextItalic, `Italic text`, []*entityTestResult{}},
+			}},
+		/***** 1 *****/
+		{`'''Bold text'''`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `Bold text`, []*entityTestResult{}},
+			}},
+		/***** 2 *****/
+		{`'''''Bold & italic text'''''`,
+			[]*entityTestResult{
+				{WikiEntityTextBoldItalic, `Bold & italic text`, []*entityTestResult{}},
+			}},
+		/***** 3 *****/
+		{`'''''bold italic'' bold'''`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `''bold italic'' bold`, []*entityTestResult{
+					{WikiEntityTextItalic, `bold italic`, []*entityTestResult{}},
+				}},
+			}},
+		/***** 4 *****/
+		{`'''''bold italic''' italic''`,
+			[]*entityTestResult{
+				{WikiEntityTextItalic, `'''bold italic''' italic`, []*entityTestResult{
+					{WikiEntityTextBold, `bold italic`, []*entityTestResult{}},
+				}},
+			}},
+		/***** 5 *****/
+		{`''a'''''A'''`,
+			[]*entityTestResult{
+				{WikiEntityTextItalic, `a`, []*entityTestResult{}},
+				{WikiEntityTextBold, `A`, []*entityTestResult{}},
+			}},
+		/***** 6 *****/
+		{`'''a'''''A''`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `a`, []*entityTestResult{}},
+				{WikiEntityTextItalic, `A`, []*entityTestResult{}},
+			}},
+		/***** 7 *****/
+		{`'''bold''bold-italic'''italic''`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `bold''bold-italic`, []*entityTestResult{
+					{WikiEntityTextItalic, `bold-italic`, []*entityTestResult{}},
+				}},
+				{WikiEntityTextItalic, `italic`, []*entityTestResult{}},
+			}},
+		/***** 8 *****/
+		{`''italic'''bold-italic''bold'''`,
+			[]*entityTestResult{
+				{WikiEntityTextItalic, `italic'''bold-italic`, []*entityTestResult{
+					{WikiEntityTextBold, `bold-italic`, []*entityTestResult{}},
+				}},
+				{WikiEntityTextBold, `bold`, []*entityTestResult{}},
+			}},
+		/***** 9 *****/
+		{`'''bold'''''italic''`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `bold`, []*entityTestResult{}},
+				{WikiEntityTextItalic, `italic`, []*entityTestResult{}},
+			}},
+		/***** 10 *****/
+		{`'''bold''bold-italic'''''`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `bold''bold-italic''`, []*entityTestResult{
+					{WikiEntityTextItalic, `bold-italic`, []*entityTestResult{}},
+				}},
+			}},
+		/***** 11 *****/
+		{`plain l'''italic''plain`,
+			[]*entityTestResult{
+				{WikiEntityText, `plain l'`, []*entityTestResult{}},
+				{WikiEntityTextItalic, `italic`, []*entityTestResult{}},
+				{WikiEntityText, `plain`, []*entityTestResult{}},
+			}},
+		/***** 12 *****/
+		{`plain l''''bold''' plain`,
+			[]*entityTestResult{
+				{WikiEntityText, `plain l'`, []*entityTestResult{}},
+				{WikiEntityTextBold, `bold`, []*entityTestResult{}},
+				{WikiEntityText, ` plain`, []*entityTestResult{}},
+			}},
+		/***** 13 *****/
+		{`'''This year''''s election ''should'' beat '''last year''''s.`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `This year'`, []*entityTestResult{}},
+				{WikiEntityText, `s election `, []*entityTestResult{}},
+				{WikiEntityTextItalic, `should`, []*entityTestResult{}},
+				{WikiEntityText, ` beat `, []*entityTestResult{}},
+				{WikiEntityTextBold, `last year'`, []*entityTestResult{}},
+				{WikiEntityText, `s.`, []*entityTestResult{}},
+			}},
+		/***** 14 *****/
+		{`a ''''''b'''''' c`,
+			[]*entityTestResult{
+				{WikiEntityText, `a '`, []*entityTestResult{}},
+				{WikiEntityTextBoldItalic, `b'`, []*entityTestResult{}},
+				{WikiEntityText, ` c`, []*entityTestResult{}},
+			}},
+		/***** 15 *****/
+		{"'''Bold text..\n\n..spanning'''",
+			[]*entityTestResult{
+				{WikiEntityTextBold, `Bold text..`, []*entityTestResult{}},
+				{WikiEntityText, "\n\n..spanning", []*entityTestResult{}},
+				{WikiEntityTextBold, ``, []*entityTestResult{}},
+			}},
+		/***** 16 *****/
+		{"a ''b\n'''c",
+			[]*entityTestResult{
+				{WikiEntityText, `a `, []*entityTestResult{}},
+				{WikiEntityTextItalic, `b`, []*entityTestResult{}},
+				{WikiEntityText, "\n", []*entityTestResult{}},
+				{WikiEntityTextBold, `c`, []*entityTestResult{}},
+			}},
+		/***** 17 *****/
+		{`'''[[link]]''' and ''{{tpl}}''`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `[[link]]`, []*entityTestResult{
+					{WikiEntityLinkInternal, `link`, nil},
+				}},
+				{WikiEntityText, ` and `, []*entityTestResult{}},
+				{WikiEntityTextItalic, `{{tpl}}`, []*entityTestResult{
+					{WikiEntityTemplate, `tpl`, nil},
+				}},
+			}},
+		/***** 18 *****/
+		{`'''''a [[b]]'' c'''`,
+			[]*entityTestResult{
+				{WikiEntityTextBold, `''a [[b]]'' c`, []*entityTestResult{
+					{WikiEntityTextItalic, `a [[b]]`, []*entityTestResult{
+						{WikiEntityLinkInternal, `b`, nil},
+					}},
+				}},
+			}},
+		/***** 19 *****/
+		{`[[a|''b'''c''']]`,
+			[]*entityTestResult{
+				{WikiEntityLinkInternal, `a|''b'''c'''`, []*entityTestResult{
+					{WikiEntityLinkInternalName, `a`, []*entityTestResult{}},
+					{WikiEntityLinkInternalProp, `''b'''c'''`, []*entityTestResult{
+						{WikiEntityTextItalic, `b'''c'''`, []*entityTestResult{
+							{WikiEntityTextBold, `c`, []*entityTestResult{}},
+						}},
+					}},
+				}},
+			}},
+		/***** 20 *****/
+		{"* a ''b\nc'' d",
+			[]*entityTestResult{
+				{WikiEntityListBulleted, ` a ''b`, []*entityTestResult{
+					{WikiEntityTextItalic, `b`, []*entityTestResult{}},
+				}},
+				{WikiEntityText, "\nc", []*entityTestResult{}},
+				{WikiEntityTextItalic, ` d`, []*entityTestResult{}},
+			}},
+		/***** 21 *****/
+		{"== a ''b'' ==",
+			[]*entityTestResult{
+				{WikiEntityHeading2, ` a ''b'' `, []*entityTestResult{
+					{WikiEntityTextItalic, `b`, []*entityTestResult{}},
+				}},
+			}},
+	}
+	for i, tc := range tests {
+		data := []byte(tc.src)
+		wiki, err := Parse(data)
+		if err != nil {
+			t.Errorf("TestQuotes: [%d] %v", i, err)
+			continue
+		}
+		checkEntityResults(t, i, "TestQuotes", data, tc.src, wiki, tc.entities, true)
+		checkEntityRanges(t, data, wiki)
+
+		opts := &ParseOptions{ ChunkSize: 1, CopyRaw: true }
+		res, err := ParseReader(iotest.OneByteReader(strings.NewReader(tc.src)), opts)
+		if err != nil {
+			t.Errorf("TestQuotes: [%d] ParseReader: %v", i, err)
+			continue
+		}
+		sameEntity(t, fmt.Sprintf("TestQuotes: [%d]", i), wiki, res)
+	}
+}
+
+func TestQuotesRaw(t *testing.T) {
+	tests := []struct{
+		src string
+		raw []string // Raw of the top-level entities
+	}{
+		{ `''a'''''A'''`, []string{ `''a''`, `'''A'''` } },
+		{ `'''a'''''A''`, []string{ `'''a'''`, `''A''` } },
+		{ `'''''a'' b'''`, []string{ `'''''a'' b'''` } },
+		{ `'''b''bi'''i''`, []string{ `'''b''bi'''`, `i''` } },
+		{ `l''''b'''`, []string{ `l'`, `'''b'''` } },
+		{ "''a\nb", []string{ `''a`, "\nb" } },
+		{ `x '''''`, []string{ `x `, `'''''` } },
+	}
+	for i, tc := range tests {
+		wiki, err := ParseString(tc.src)
+		if err != nil {
+			t.Errorf("TestQuotesRaw: [%d] %v", i, err)
+			continue
+		}
+		var raw []string
+		for _, e := range wiki.Entities {
+			raw = append(raw, string(e.Raw))
+		}
+		if fmt.Sprintf("%q", raw) != fmt.Sprintf("%q", tc.raw) {
+			t.Errorf("TestQuotesRaw: [%d] expect %q, got %q", i, tc.raw, raw)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import "io"
+
+// DefaultChunkSize is the default ParseOptions.ChunkSize.
+const DefaultChunkSize = 64 << 10
+
+// copyRaw makes all Raw in the tree of e refer to a copy of chunk, where
+// they were slices of.
+func copyRaw(e *Entity, chunk []byte) {
+	b := make([]byte, len(chunk))
+	copy(b, chunk)
+
+	var walk func(e *Entity)
+	walk = func(e *Entity) {
+		if off := cap(chunk) - cap(e.Raw); e.Raw != nil && 0 <= off && off+len(e.Raw) <= len(chunk) {
+			e.Raw = b[off : off+len(e.Raw)]
+		} else if e.Raw != nil {
+			e.Raw = append([]byte(nil), e.Raw...)
+		}
+		for _, child := range e.Entities {
+			walk(child)
+		}
+	}
+	walk(e)
+}
+
+// eachReader is the same as each but reads the data from r in chunks, only
+// the entity being scanned is buffered.
+func (p *parser) eachReader(r io.Reader, opts *ParseOptions, fn func(e *Entity) error) (err error) {
+	size, copying := DefaultChunkSize, false
+	if opts != nil {
+		if 0 < opts.ChunkSize {
+			size = opts.ChunkSize
+		}
+		copying = opts.CopyRaw
+	}
+
+	p.scan.push = p.push
+	p.scan.pop = p.pop
+
+	emit, flush := p.pipeline(fn)
+	var buf []byte
+	pos, want, eof := 0, size, false
+	for {
+		for !eof && len(buf) < want {
+			if cap(buf) - len(buf) < size {
+				b := make([]byte, len(buf), 2*cap(buf) + size)
+				copy(b, buf)
+				buf = b
+			}
+			if opts != nil && opts.Context != nil {
+				if err = opts.Context.Err(); err != nil {
+					return
+				}
+			}
+			n, e := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[0:len(buf)+n]
+			if e == io.EOF {
+				eof = true
+			} else if e != nil {
+				return e
+			}
+			if 0 < p.maxInputSize && p.maxInputSize < pos + len(buf) {
+				return &LimitError{ "MaxInputSize", p.maxInputSize, int64(pos + len(buf)) }
+			}
+		}
+
+		p.data = buf
+		p.scan.partial = !eof
+		count := p.count
+		entity, rest, e := p.next(pos)
+		if e != nil {
+			return e
+		}
+
+		if len(rest) == 0 && !eof {
+			// The entity may continue in the unread data, scan it
+			// again when more data is buffered.
+			p.count = count
+			want = 2*len(buf) + size
+			continue
+		}
+
+		n := len(buf) - len(rest)
+		if copying {
+			copyRaw(entity, buf[0:n])
+		}
+		if err = emit(entity); err != nil {
+			return
+		}
+		pos += n
+
+		if copying {
+			// Nothing refers to buf now, reuse it.
+			buf = buf[0:copy(buf, rest)]
+		} else {
+			buf = rest
+		}
+		want = size
+
+		if eof && len(buf) == 0 {
+			break
+		}
+	}
+	return flush()
+}
+
+// ParseReader parses the document read from r. The data is read and scanned
+// in chunks of opts.ChunkSize bytes, opts may be nil for the defaults.
+func ParseReader(r io.Reader, opts *ParseOptions) (wiki *Entity, err error) {
+	p := newParser(opts)
+
+	wiki = new(Entity)
+	wiki.Type = WikiEntityWiki
+	wiki.titles, wiki.tags = p.titles, p.tags
+	err = p.eachReader(r, opts, builder(wiki))
+	return
+}
+
+// ParseReaderEvents is the same as ParseEvents but reads the document from r
+// like ParseReader. With opts.CopyRaw, the memory used is bounded by the
+// largest run of the entities buffered, see ParseEvents.
+func ParseReaderEvents(r io.Reader, h Handler, opts *ParseOptions) error {
+	p := newParser(opts)
+	return (&eventParser{ h: h }).run(func(fn func(e *Entity) error) error {
+		return p.eachReader(r, opts, fn)
+	})
+}
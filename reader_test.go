@@ -0,0 +1,104 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseReader(t *testing.T) {
+	sources := []string{
+		``,
+		`Normal text.`,
+		`normal '''bold ''italic'' bold''' normal`,
+		"== A ==\nhello [[foo|bar]] {{t|a=b|c\n|d}}\n* item\n=== B ===\ntext\n==== C ====\n== D ==\nend",
+	}
+	files, _ := filepath.Glob("testdata/*.wiki.gz")
+	for _, file := range files {
+		data, err := readTestData(file)
+		if err != nil {
+			t.Errorf("TestParseReader: %s: %v", file, err)
+			continue
+		}
+		sources = append(sources, string(data))
+	}
+	options := []*ParseOptions{
+		nil,
+		{ ChunkSize: 1 },
+		{ ChunkSize: 7, CopyRaw: true },
+		{ ChunkSize: 256, CopyRaw: true },
+	}
+	for i, src := range sources {
+		wiki, err := ParseString(src)
+		if err != nil {
+			t.Errorf("TestParseReader: [%d] Parse: %v", i, err)
+			continue
+		}
+		for _, opts := range options {
+			tag := "TestParseReader"
+			if opts != nil {
+				tag = fmt.Sprintf("TestParseReader: [%d, %d, %v]", i, opts.ChunkSize, opts.CopyRaw)
+			}
+			res, err := ParseReader(iotest.HalfReader(strings.NewReader(src)), opts)
+			if err != nil {
+				t.Errorf("%s: %v", tag, err)
+				continue
+			}
+			sameEntity(t, tag, wiki, res)
+		}
+
+		h := new(treeHandler)
+		opts := &ParseOptions{ ChunkSize: 16, CopyRaw: true }
+		if err := ParseReaderEvents(strings.NewReader(src), h, opts); err != nil {
+			t.Errorf("TestParseReader: [%d] ParseReaderEvents: %v", i, err)
+			continue
+		}
+		sameEntity(t, "TestParseReader: events", wiki, h.root)
+	}
+}
+
+// sameArray tells if x and y are slices of the same array.
+func sameArray(x, y []byte) bool {
+	return 0 < cap(x) && 0 < cap(y) && &x[0:cap(x)][cap(x)-1] == &y[0:cap(y)][cap(y)-1]
+}
+
+func TestParseReaderCopyRaw(t *testing.T) {
+	src := "text {{name|prop1|prop2{{name|prop}}}} text\n== A ==\n[[link|label]]"
+	for _, copying := range []bool{ false, true } {
+		wiki, err := ParseReader(strings.NewReader(src), &ParseOptions{ CopyRaw: copying })
+		if err != nil {
+			t.Errorf("TestParseReaderCopyRaw: %v", err)
+			return
+		}
+		var tops []*Entity
+		for _, e := range wiki.Entities {
+			tops = append(tops, e)
+			if e.Type == WikiEntityHeading2 {
+				tops = append(tops, e.Entities...)
+			}
+		}
+		for i, e := range tops {
+			if 0 < i && sameArray(tops[i-1].Raw, e.Raw) == copying {
+				t.Errorf("TestParseReaderCopyRaw: [%v] %v, %v", copying, tops[i-1], e)
+			}
+			for _, child := range e.Entities {
+				if e.Type != WikiEntityHeading2 && !sameArray(child.Raw, e.Raw) {
+					t.Errorf("TestParseReaderCopyRaw: [%v] %v in %v", copying, child, e)
+				}
+			}
+		}
+	}
+}
+
+func TestParseReaderError(t *testing.T) {
+	r := iotest.TimeoutReader(strings.NewReader("text {{name|prop}} text"))
+	_, err := ParseReader(r, &ParseOptions{ ChunkSize: 4 })
+	if err != iotest.ErrTimeout {
+		t.Errorf("TestParseReaderError: %v", err)
+	}
+}
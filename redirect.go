@@ -0,0 +1,210 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A redirect page starts with the redirect (see MagicWords) followed by an
+// internal link to the target, after any blank lines, e.g.
+//
+//	#REDIRECT [[Target page#Section]]
+//
+// The line is made a WikiEntityRedirect instead of a numbered list, the link
+// is the child of it, the Text is the Raw without the '#'. There may be
+// spaces and newlines between the redirect and the link. Redirects
+// elsewhere in the document are not redirects of the page. The target is a
+// property of the page (see PageProperties), RedirectMap resolves the
+// redirects of many pages, e.g. of a dump.
+
+// redirecter makes the redirect at the beginning of the document before
+// passing the top-level entities to fn.
+type redirecter struct {
+	fn func(e *Entity) error
+	words *magicWords
+	head []*Entity // the blank texts before the redirect
+	text []*Entity // the redirect before the link and the spaces after it, e.g. "  #REDIRECT", "\n"
+	done bool
+}
+
+// next tells if e is right after the text of the redirect.
+func (r *redirecter) next(e *Entity) bool {
+	last := r.text[len(r.text)-1]
+	return last.Pos + len(last.Raw) == e.Pos
+}
+
+func (r *redirecter) entity(e *Entity) error {
+	if r.done {
+		return r.fn(e)
+	}
+	switch {
+	case r.text == nil && e.Type == WikiEntityText:
+		if strings.TrimSpace(e.Text) == "" {
+			r.head = append(r.head, e)
+			return nil
+		}
+		if m := r.words.redirect.FindIndex(e.Raw); m != nil && m[1] == len(e.Raw) {
+			r.text = []*Entity{ e }
+			return nil
+		}
+	case r.text == nil && e.Type == WikiEntityListNumbered:
+		m := r.words.redirect.FindIndex(e.Raw)
+		switch {
+		case m == nil:
+		case len(e.Entities) == 0 && m[1] == len(e.Raw):
+			r.text = []*Entity{ e } // the link is on the next lines
+			return nil
+		case 0 < len(e.Entities) && e.Entities[0].Type == WikiEntityLinkInternal && rawOffset(e, e.Entities[0]) == m[1]:
+			e.Type, e.Text = WikiEntityRedirect, string(e.Raw[1:])
+		}
+	case r.text != nil && e.Type == WikiEntityText && strings.TrimSpace(e.Text) == "" && r.next(e):
+		r.text = append(r.text, e)
+		return nil
+	case r.text != nil && e.Type == WikiEntityLinkInternal && r.next(e):
+		text, t := r.text, r.text[0]
+		if i := bytes.IndexByte(t.Raw, '#'); 0 < i {
+			r.head = append(r.head, &Entity{ Type: WikiEntityText, Pos: t.Pos, Raw: t.Raw[0:i], Text: string(t.Raw[0:i]) })
+			t = &Entity{ Type: WikiEntityText, Pos: t.Pos + i, Raw: t.Raw[i:], Text: string(t.Raw[i:]) }
+			text = append([]*Entity{ t }, text[1:]...)
+		}
+		raw := join(append(text, e))
+		redirect := &Entity{ Type: WikiEntityRedirect, Pos: text[0].Pos, Raw: raw, Text: string(raw[1:]) }
+		e.Pos = rawOffset(redirect, e)
+		redirect.Entities = []*Entity{ e }
+		r.text, e = nil, redirect
+	}
+	if r.text != nil {
+		r.head, r.text = append(r.head, r.text...), nil
+	}
+	r.done = true
+	r.head = append(r.head, e)
+	return r.flush()
+}
+
+func (r *redirecter) flush() (err error) {
+	if r.text != nil {
+		r.head = append(r.head, r.text...)
+	}
+	head := r.head
+	r.head, r.text = nil, nil
+	for _, e := range head {
+		if err = r.fn(e); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// redirectTarget returns the title and the fragment of the target of the
+// redirect e, e.g. "Target page" and "Section" of [[target_page#Section]],
+// the title is normalized with opts, or the options of the tree of e if opts
+// is nil. The title is "" if the target is not a valid title.
+func redirectTarget(e *Entity, opts *TitleOptions) (title, fragment string) {
+	for _, child := range e.Entities {
+		if child.Type != WikiEntityLinkInternal {
+			continue
+		}
+		t, err := child.Title(opts)
+		if err != nil || t.Name == "" {
+			return "", ""
+		}
+		return t.PrefixedText(), t.Fragment
+	}
+	return
+}
+
+// RedirectTarget is the target page of a redirect.
+type RedirectTarget struct {
+	Title string
+	Fragment string // the section of the page, or ""
+}
+
+// RedirectMap maps the titles of the redirect pages to the targets, e.g. of
+// the pages read from a dump. The titles are normalized with the options of
+// the titles, e.g. "Foo bar" of "foo_bar", and "File:A" of "Image:A".
+type RedirectMap struct {
+	Titles *TitleOptions // e.g. SiteConfig.TitleOptions, it may be nil
+	Targets map[string]RedirectTarget // by the prefixed titles, see Title.PrefixedText
+}
+
+// NewRedirectMap returns an empty map of the redirects of the pages of which
+// the titles are normalized with opts, opts may be nil.
+func NewRedirectMap(opts *TitleOptions) *RedirectMap {
+	return &RedirectMap{ Titles: opts, Targets: make(map[string]RedirectTarget) }
+}
+
+// key returns the normalized title of the page title.
+func (m *RedirectMap) key(title string) (string, error) {
+	t, err := ParseTitle(title, m.Titles)
+	if err != nil {
+		return "", err
+	}
+	return t.PrefixedText(), nil
+}
+
+// Add adds the redirect of the page title if wiki is a redirect page, it
+// returns false if it's not, or if the title or the target is invalid.
+func (m *RedirectMap) Add(title string, wiki *Entity) bool {
+	for _, e := range wiki.Entities {
+		if e.Type == WikiEntityText && strings.TrimSpace(e.Text) == "" {
+			continue
+		}
+		if e.Type != WikiEntityRedirect {
+			return false
+		}
+		opts := m.Titles
+		if opts == nil {
+			opts = &TitleOptions{} // the defaults, not the site of wiki
+		}
+		t, fragment := redirectTarget(e, opts)
+		if t == "" {
+			return false
+		}
+		key, err := m.key(title)
+		if err != nil {
+			return false
+		}
+		m.Targets[key] = RedirectTarget{ t, fragment }
+		return true
+	}
+	return false
+}
+
+// RedirectLoopError is returned by RedirectMap.Resolve if the redirects
+// loop, e.g. A to B and B to A.
+type RedirectLoopError struct {
+	Titles []string // the titles of the redirects in the loop, in order
+}
+
+func (e *RedirectLoopError) Error() string {
+	return fmt.Sprintf("wiki: redirect loop: %s", strings.Join(e.Titles, " -> "))
+}
+
+// Resolve returns the final target of the title following the redirects,
+// which is the title itself if it's not a redirect. The fragment is of the
+// last redirect, as the others are sections of the redirect pages.
+func (m *RedirectMap) Resolve(title string) (RedirectTarget, error) {
+	key, err := m.key(title)
+	if err != nil {
+		return RedirectTarget{}, err
+	}
+	target := RedirectTarget{ Title: key }
+	var chain []string
+	seen := make(map[string]int)
+	for {
+		next, ok := m.Targets[target.Title]
+		if !ok {
+			return target, nil
+		}
+		if i, ok := seen[target.Title]; ok {
+			return RedirectTarget{}, &RedirectLoopError{ append(chain[i:], target.Title) }
+		}
+		seen[target.Title] = len(chain)
+		chain = append(chain, target.Title)
+		target = next
+	}
+}
@@ -0,0 +1,201 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRedirects(t *testing.T) {
+	tests := []struct{
+		src string
+		entities []*entityTestResult
+		title, fragment string
+	}{
+		/***** 0 *****/
+		{"#REDIRECT [[foo_bar#Sec|x]]\n{{R}}",
+			[]*entityTestResult{
+				{WikiEntityRedirect, `REDIRECT [[foo_bar#Sec|x]]`, []*entityTestResult{
+					{WikiEntityLinkInternal, `foo_bar#Sec|x`, []*entityTestResult{
+						{WikiEntityLinkInternalName, `foo_bar#Sec`, []*entityTestResult{}},
+						{WikiEntityLinkInternalProp, `x`, []*entityTestResult{}},
+					}},
+				}},
+				{WikiEntityText, "\n", []*entityTestResult{}},
+				{WikiEntityTemplate, `R`, nil},
+			}, `Foo bar`, `Sec`},
+		/***** 1 *****/
+		{"\n\n#redirect:[[:Category:A]]",
+			[]*entityTestResult{
+				{WikiEntityText, "\n", []*entityTestResult{}},
+				{WikiEntityRedirect, `redirect:[[:Category:A]]`, []*entityTestResult{
+					{WikiEntityLinkInternal, `:Category:A`, []*entityTestResult{
+						{WikiEntityLinkInternalName, `:Category:A`, []*entityTestResult{}},
+					}},
+				}},
+			}, `Category:A`, ``},
+		/***** 2 *****/
+		{"text\n#REDIRECT [[a]]",
+			[]*entityTestResult{
+				{WikiEntityText, "text", []*entityTestResult{}},
+				{WikiEntityListNumbered, `#REDIRECT [[a]]`, []*entityTestResult{
+					{WikiEntityLinkInternal, `a`, []*entityTestResult{
+						{WikiEntityLinkInternalName, `a`, []*entityTestResult{}},
+					}},
+				}},
+			}, ``, ``},
+		/***** 3 *****/
+		{"#REDIRECTS [[a]]",
+			[]*entityTestResult{
+				{WikiEntityListNumbered, `REDIRECTS [[a]]`, []*entityTestResult{
+					{WikiEntityLinkInternal, `a`, []*entityTestResult{
+						{WikiEntityLinkInternalName, `a`, []*entityTestResult{}},
+					}},
+				}},
+			}, ``, ``},
+		/***** 4 *****/
+		{"#REDIRECT\n\n [[a]]\nb",
+			[]*entityTestResult{
+				{WikiEntityRedirect, "REDIRECT\n\n [[a]]", []*entityTestResult{
+					{WikiEntityLinkInternal, `a`, []*entityTestResult{
+						{WikiEntityLinkInternalName, `a`, []*entityTestResult{}},
+					}},
+				}},
+				{WikiEntityText, "\nb", []*entityTestResult{}},
+			}, `A`, ``},
+		/***** 5 *****/
+		{" #REDIRECT:\n[[a]]",
+			[]*entityTestResult{
+				{WikiEntityText, " ", []*entityTestResult{}},
+				{WikiEntityRedirect, "REDIRECT:\n[[a]]", []*entityTestResult{
+					{WikiEntityLinkInternal, `a`, []*entityTestResult{
+						{WikiEntityLinkInternalName, `a`, []*entityTestResult{}},
+					}},
+				}},
+			}, `A`, ``},
+		/***** 6 *****/
+		{"#REDIRECT\nb [[a]]",
+			[]*entityTestResult{
+				{WikiEntityListNumbered, `REDIRECT`, []*entityTestResult{}},
+				{WikiEntityText, "\nb ", []*entityTestResult{}},
+				{WikiEntityLinkInternal, `a`, []*entityTestResult{
+					{WikiEntityLinkInternalName, `a`, []*entityTestResult{}},
+				}},
+			}, ``, ``},
+	}
+	for i, tc := range tests {
+		data := []byte(tc.src)
+		wiki, err := Parse(data)
+		if err != nil {
+			t.Errorf("TestRedirects: [%d] %v", i, err)
+			continue
+		}
+		checkEntityResults(t, i, "TestRedirects", data, tc.src, wiki, tc.entities, true)
+		checkEntityRanges(t, data, wiki)
+
+		props := PageProperties(wiki)
+		if props.Redirect != tc.title || props.RedirectFragment != tc.fragment {
+			t.Errorf("TestRedirects: [%d] expect %q %q, got %q %q", i, tc.title, tc.fragment, props.Redirect, props.RedirectFragment)
+		}
+
+		opts := &ParseOptions{ ChunkSize: 1, CopyRaw: true }
+		res, err := ParseReader(iotest.OneByteReader(strings.NewReader(tc.src)), opts)
+		if err != nil {
+			t.Errorf("TestRedirects: [%d] ParseReader: %v", i, err)
+			continue
+		}
+		sameEntity(t, fmt.Sprintf("TestRedirects: [%d]", i), wiki, res)
+	}
+
+	wiki, err := ParseWithOptions([]byte(`#WEITERLEITUNG [[Ziel]]`), &ParseOptions{ MagicWords: MagicWords["de"] })
+	if err != nil {
+		t.Fatalf("TestRedirects: de: %v", err)
+	}
+	if props := PageProperties(wiki); props.Redirect != "Ziel" {
+		t.Errorf("TestRedirects: de: expect %q, got %q", "Ziel", props.Redirect)
+	}
+}
+
+func TestRedirectMap(t *testing.T) {
+	pages := []struct{
+		title, src string
+		redirect bool
+	}{
+		{ "A", "#REDIRECT [[b]]", true },
+		{ "B", "#REDIRECT [[C#Sec]]", true },
+		{ "C", "text", false },
+		{ "d_e", "#REDIRECT [[A]]", true },
+		{ "X", "#REDIRECT [[Y#Z]]", true },
+		{ "Y", "#REDIRECT [[Z]]", true },
+		{ "Z", "#REDIRECT [[x]]", true },
+		{ "Self", "#REDIRECT [[self]]", true },
+		{ "Image:P", "#REDIRECT [[image:q]]", true },
+		{ "R", "#REDIRECT [[#S]]", false },
+	}
+	m := NewRedirectMap(nil)
+	for i, page := range pages {
+		wiki, err := ParseString(page.src)
+		if err != nil {
+			t.Fatalf("TestRedirectMap: [%d] %v", i, err)
+		}
+		if ok := m.Add(page.title, wiki); ok != page.redirect {
+			t.Errorf("TestRedirectMap: [%d] %s: expect %v, got %v", i, page.title, page.redirect, ok)
+		}
+	}
+	tests := []struct{
+		title string
+		target RedirectTarget
+		loop string
+	}{
+		{ "A", RedirectTarget{ "C", "Sec" }, `` },
+		{ "d e", RedirectTarget{ "C", "Sec" }, `` },
+		{ "C", RedirectTarget{ "C", "" }, `` },
+		{ "Nowhere", RedirectTarget{ "Nowhere", "" }, `` },
+		{ "X", RedirectTarget{}, `X -> Y -> Z -> X` },
+		{ "Self", RedirectTarget{}, `Self -> Self` },
+		{ "file:P", RedirectTarget{ "File:Q", "" }, `` },
+	}
+	for i, test := range tests {
+		target, err := m.Resolve(test.title)
+		if test.loop != "" {
+			if e, ok := err.(*RedirectLoopError); !ok || strings.Join(e.Titles, " -> ") != test.loop {
+				t.Errorf("TestRedirectMap: [%d] expect loop %q, got %v", i, test.loop, err)
+			}
+			continue
+		}
+		if err != nil || target != test.target {
+			t.Errorf("TestRedirectMap: [%d] expect %v, got %v (%v)", i, test.target, target, err)
+		}
+	}
+}
+
+func TestRedirectMapSite(t *testing.T) {
+	site, err := LoadSiteConfigFile("testdata/siteinfo.json")
+	if err != nil {
+		t.Fatalf("TestRedirectMapSite: %v", err)
+	}
+	m := NewRedirectMap(site.TitleOptions())
+	for title, src := range map[string]string{
+		"a": "#REDIRECT [[b]]",
+		"WT:x": "#REDIRECT [[project:y]]",
+	} {
+		wiki, _ := ParseWithOptions([]byte(src), &ParseOptions{ Site: site })
+		if !m.Add(title, wiki) {
+			t.Errorf("TestRedirectMapSite: %s is not added", title)
+		}
+	}
+	for title, expect := range map[string]string{
+		"a": "b",
+		"A": "A",
+		"Wiktionary:x": "Wiktionary:y",
+		"Wiktionary:X": "Wiktionary:X",
+	} {
+		if target, err := m.Resolve(title); err != nil || target.Title != expect {
+			t.Errorf("TestRedirectMapSite: %s: expect %q, got %v (%v)", title, expect, target, err)
+		}
+	}
+}
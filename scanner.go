@@ -3,7 +3,11 @@
 //
 package wiki
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"strings"
+)
 
 type SyntaxError struct {
 	msg    string // description of error
@@ -12,6 +16,20 @@ type SyntaxError struct {
 
 func (e *SyntaxError) Error() string { return e.msg }
 
+// LimitError is returned when the input exceeds a limit of ParseOptions.
+type LimitError struct {
+	Limit  string // name of the limit, e.g. "MaxDepth"
+	Max    int    // value of the limit
+	Offset int64  // error occurred after reading Offset bytes
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("wiki: %s (%d) exceeded at offset %d", e.Limit, e.Max, e.Offset)
+}
+
+// checkInterval is the number of steps between two checks of scanner.ctx.
+const checkInterval = 1 << 12
+
 type scanner struct {
 	// json/scanner.go:
 	//     The step is a func to be called to execute the next transition.
@@ -41,6 +59,20 @@ type scanner struct {
 
 	err error
 
+	// limits, zero values mean unlimited
+	maxDepth int
+	ctx context.Context
+
+	// the extension tags of which the bodies are not scanned, e.g. <math>
+	rawTags func(name string) bool
+	partial bool // more data may follow, e.g. by ParseReader
+	data []byte
+	rawHead, rawEnd int // the end of the head and of the tag with the body
+
+	// the extension tags not closed in the last bytes of the data, by the
+	// names, so that the rest of the data is searched once for a name
+	rawUnclosed map[string]int
+
 	pos func() int
 	push func(state EntityType)
 	pop func(state EntityType, pos1, pos2, off1, off2 int)
@@ -62,6 +94,9 @@ func (s *scanner) reset() {
 func (s *scanner) pushParseState(state EntityType) {
 	//fmt.Printf("pushParseState: %v %v, pos=%d\n", s.parsing, state, s.pos())
 	if s.push != nil { s.push(state) }
+	if 0 < s.maxDepth && s.maxDepth <= len(s.parsing) && s.err == nil {
+		s.err = &LimitError{ "MaxDepth", s.maxDepth, int64(s.pos()) }
+	}
 	s.parsingPos = append(s.parsingPos, s.pos())
 	s.parsing = append(s.parsing, state); s.parsingTop++
 	s.parsingTopState = state
@@ -88,6 +123,15 @@ func (s *scanner) popParseState(off1, off2, offset, rewind int) {
 		s.parsingTopState = s.parsing[s.parsingTop]
 	}
 }
+// dropParseState pops a parse state off the stack without making the
+// entity of it.
+func (s *scanner) dropParseState() {
+	if s.pop != nil { s.pop(s.parsingTopState, 0, 0, 0, 0) }
+	s.parsingPos = s.parsingPos[0:s.parsingTop]
+	s.parsing = s.parsing[0:s.parsingTop]
+	s.parsingTop--
+	s.parsingTopState = s.parsing[s.parsingTop]
+}
 func (s *scanner) popStepState() {
 	if 0 <= s.stateTop {
 		s.states = s.states[0:s.stateTop]; s.stateTop--
@@ -102,16 +146,31 @@ func (s *scanner) popStepState() {
 	}
 }
 
+// charNUL is the character of a NUL byte in the data.
+const charNUL = 0x100
+
 // next splits data after the next Entity.
 func (s *scanner) next(data []byte) (entity, rest []byte, err error) {
 	i, end := 0, len(data)
 	s.pos = func() int { return i }
+	s.data = data
 	s.reset()
-	for ; i < end; i++ {
+	for n := 0; i < end; i++ {
+		if n++; s.ctx != nil && n%checkInterval == 0 {
+			if err = s.ctx.Err(); err != nil {
+				return
+			}
+		}
 		s.rewind = 0 // need to reset 'rewind' every step
-		c := data[i]
-		v := s.step(s, int(c))
+		c := int(data[i])
+		if c == 0 {
+			c = charNUL // zero is the EOF
+		}
+		v := s.step(s, c)
 		i -= s.rewind
+		if s.err != nil {
+			return nil, nil, s.err
+		}
 		//fmt.Printf("%d:%v: %v\n", i, s.parsing, string(data[i:]))
 		if scanEnd <= v {
 			switch v {
@@ -128,12 +187,21 @@ func (s *scanner) next(data []byte) (entity, rest []byte, err error) {
 	s.step(s, 0)
 
 	if 0 <= s.parsingTop {
+		// The entities not closed are dropped except the outermost,
+		// e.g. "{{b|c" of "[[a|{{b|c" is left in the link as text.
+		for 0 < s.parsingTop {
+			s.dropParseState()
+		}
 		s.end(0, 0, 0, 0, 0) // do a final end to pop as text
 		if s.state != parseEntityText && len(data) == 1 && data[0] == '\n' {
 			s.state = parseEntityText
 		}
 	}
 
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+
 	//if s.pop != nil { s.pop(s.parsing[s.parsingTop]) }
 	//fmt.Printf("next:%d:%v: %v %v %v\n", i, s.parsing, s.state, s.shift, string(data))
 	return data, nil, nil
@@ -146,9 +214,6 @@ func (s *scanner) checkSpecial(c int) bool {
 	case '\n':
 		s.step, s.indent = stateNewline, 0
 		return true
-	case '\'':
-		s.step = stateQuoteLeft1
-		return true
 	case '[':
 		s.step = stateSqL1
 		return true
@@ -301,7 +366,7 @@ const (
 func stateUnknown(s *scanner, c int) int {
 	s.step = stateError
 	s.err = &SyntaxError{
-		fmt.Sprintf("invalid character %s at unknown state", string(c)), 0,
+		fmt.Sprintf("invalid character %q at unknown state", rune(c)), 0,
 	}
 	return scanError
 }
@@ -313,220 +378,6 @@ func stateBeginWiki(s *scanner, c int) int {
 	return s.begin(stateInEntityText, parseEntityText, scanBeginText, c, 0)
 }
 
-// '
-func stateQuoteLeft1(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteLeft1: %v %v %v\n", s.pos(), string(c), s.parsing)
-
-	if c == '\'' {
-		if s.parsingTopState == parseEntityText {
-			//fmt.Printf("stateQuoteLeft1: %v %v end text\n", string(c), s.parsing)
-			s.rewind = 1;
-			return s.end(c, 0, 0, 0, 0)
-		}
-
-		s.step = stateQuoteLeft2
-		return scanContinue
-	}
-
-	if s.stateTop < 0 {
-		//fmt.Printf("stateQuoteLeftl: %v %v %v\n", s.pos(), string(c), s.parsing)
-		return s.begin(stateInEntityText, parseEntityText, scanBeginText, c, 0)
-	}
-
-	s.step = s.states[s.stateTop]
-	return s.step(s, c)
-}
-
-// ''
-func stateQuoteLeft2(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteLeft2: %v %v %v\n", s.pos(), string(c), s.parsing)
-
-	if c == '\'' {
-		s.step = stateQuoteLeft3
-		return scanContinue
-	}
-	return s.begin(stateInEntityTextItalic, parseEntityTextItalic, scanBeginTextItalic, c, 2)
-}
-
-// '''
-func stateQuoteLeft3(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteLeft3: %v %v %v\n", s.pos(), string(c), s.parsing)
-	if c == '\'' {
-		s.step = stateQuoteLeft4
-		return scanContinue
-	}
-	return s.begin(stateInEntityTextBold, parseEntityTextBold, scanBeginTextBold, c, 3)
-}
-
-// ''''
-func stateQuoteLeft4(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteLeft4: %v %v %v\n", s.pos(), string(c), s.parsing)
-	if c == '\'' {
-		s.step = stateQuoteLeft5
-		return scanContinue
-	}
-	// same as stateQuoteLeft3 in this case
-	return s.begin(stateInEntityTextBold, parseEntityTextBold, scanBeginTextBold, c, 3)
-}
-
-// '''''
-func stateQuoteLeft5(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteLeft5: %v %v %v\n", s.pos(), string(c), s.parsing)
-	// ''a'''''A'''
-	return s.begin(stateInEntityTextBoldItalic, parseEntityTextBoldItalic, scanBeginTextBoldItalic, c, 5)
-}
-
-// ' (right)
-func stateQuoteRight1(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteRight1: %v %v %v\n", s.pos(), string(c), s.parsing)
-
-	if c == '\'' {
-		s.step = stateQuoteRight2
-		return scanContinue
-	}
-
-	s.step = s.states[s.stateTop]
-	return s.step(s, c) //return scanContinue
-}
-
-// '' (right)
-func stateQuoteRight2(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteRight2: %v %v %v\n", s.pos(), string(c), s.parsing)
-
-	if c == '\'' {
-		if s.parsingTopState == parseEntityTextItalic {
-			if 0 < s.parsingTop && s.parsing[s.parsingTop-1] == parseEntityTextBold {
-				s.end(c, 2, 2, 0, 0)
-				s.step = stateQuoteRight1
-			} else /* s.parsingTop <= 0 */ {
-				// in cases like:
-				//   ''italic '''bold''' italic...''
-				//   ''a'''''A'''
-				s.step = stateQuoteLeft3
-			}
-		} else {
-			s.step = stateQuoteRight3
-		}
-		return scanContinue
-	}
-
-	switch s.parsingTopState {
-	case parseEntityTextItalic:
-		//fmt.Printf("stateQuoteRight2: end: %v %v %v\n", s.pos(), string(c), s.parsing)
-		return s.end(c, 2, 2, 0, 0)
-	case parseEntityTextBoldItalic:
-		topPos, off1, off2 := s.parsingPos[s.parsingTop], 2, 2
-		if 0 < s.parsingTop && s.parsing[s.parsingTop-1] == parseEntityTextItalic {
-			// '''a'''''b''
-			// discard BoldItalic (rewinds), go back to Bold
-			s.parsingPos = s.parsingPos[0:s.parsingTop]
-			s.parsing = s.parsing[0:s.parsingTop]; s.parsingTop--
-			s.states = s.states[0:s.stateTop]; s.stateTop--
-			s.parsingTopState = s.parsing[s.parsingTop]
-			s.rewind = s.pos() - topPos + off1 + 1
-			if s.pop != nil {
-				s.pop(parseEntityTextBoldItalic, 0, 0, 0, 0)
-			}
-			return s.end(c, off1-1, off2-1, 0, s.rewind-1)
-		} else {
-			// '''''a'''b''
-			// convert BoldItalic into Bold
-			s.parsingPos[s.parsingTop] = topPos - 2
-			s.parsing[s.parsingTop] = parseEntityTextBold
-			s.states[s.stateTop] = stateInEntityTextBold
-			s.parsingTopState = parseEntityTextBold
-			//if s.pop != nil { s.pop(s.parsing[s.parsingTop], 2, 2) }
-
-			// push new Italic
-			s.parsingPos = append(s.parsingPos, topPos)
-			s.parsing = append(s.parsing, parseEntityTextItalic); s.parsingTop++
-			s.parsingTopState = parseEntityTextItalic
-			s.states = append(s.states, stateInEntityTextItalic); s.stateTop++
-			s.step = stateInEntityTextItalic
-		}
-		return s.end(c, off1, off2, 0, 0)
-	}
-
-	//fmt.Printf("stateQuoteRight2: end: %v %v %v\n", s.pos(), string(c), s.parsing)
-
-	// in cases like:
-	//   '''bold ''italic'' bold...
-	//   '''''Any''' may apply.''
-	s.step = stateQuoteLeft1
-	return stateQuoteLeft2(s, c)
-}
-
-func endStateQR3(s *scanner, c, off1, off2 int) int {
-	if s.parsingTopState == parseEntityTextBoldItalic {
-		topPos := s.parsingPos[s.parsingTop]
-
-		//fmt.Printf("endStateQR3: %v %v %v\n", s.pos(), string(c), s.parsing)
-		if 0 < s.parsingTop && s.parsing[s.parsingTop-1] == parseEntityTextItalic {
-			// ''a'''''b'''
-			// discard BoldItalic (rewinds), go back to Italic
-			s.parsingPos = s.parsingPos[0:s.parsingTop]
-			s.parsing = s.parsing[0:s.parsingTop]; s.parsingTop--
-			s.states = s.states[0:s.stateTop]; s.stateTop--
-			s.parsingTopState = s.parsing[s.parsingTop]
-
-			//fmt.Printf("endStateQR3: %v %v %v\n", topPos, s.pos(), s.parsing)
-
-			d := 1
-			if s.parsingTop == 0 { d = 0 }
-			s.rewind = s.pos() - topPos + off1 + d
-
-			if s.pop != nil {
-				s.pop(parseEntityTextBoldItalic, 0, 0, 0, 0)
-			}
-			return s.end(c, off1-1, off2-1, 0, s.rewind-d)
-		} else {
-			// '''''a''b'''
-			// convert BoldItalic into Italic
-			s.parsingPos[s.parsingTop] = topPos - 3
-			s.parsing[s.parsingTop] = parseEntityTextItalic
-			s.states[s.stateTop] = stateInEntityTextItalic
-			s.parsingTopState = parseEntityTextItalic
-			//if s.pop != nil { s.pop(s.parsing[s.parsingTop], off1, off2) }
-
-			// push new Bold
-			s.parsingPos = append(s.parsingPos, topPos)
-			s.parsing = append(s.parsing, parseEntityTextBold); s.parsingTop++
-			s.parsingTopState = parseEntityTextBold
-			s.states = append(s.states, stateInEntityTextBold); s.stateTop++
-			s.step = stateInEntityTextBold
-		}
-		//fmt.Printf("endStateQR3: %v %v %v\n", s.pos(), string(c), s.parsing)
-	}
-	return s.end(c, off1, off2, 0, 0)
-}
-
-// ''' (right)
-func stateQuoteRight3(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteRight3: %v %v %v\n", s.pos(), string(c), s.parsing)
-	if c == '\'' && s.parsingTopState == parseEntityTextBoldItalic {
-		s.step = stateQuoteRight4
-		return scanContinue
-	}
-	return endStateQR3(s, c, 3, 3)
-}
-
-// '''' (right)
-func stateQuoteRight4(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteRight4: %v %v %v\n", s.pos(), string(c), s.parsing)
-	if c == '\'' && s.parsingTopState == parseEntityTextBoldItalic {
-		s.step = stateQuoteRight5
-		return scanContinue
-	}
-	// same as stateQuoteRight3 in this case
-	return endStateQR3(s, c, 3, 3)
-}
-
-// ''''' (right)
-func stateQuoteRight5(s *scanner, c int) int {
-	//fmt.Printf("stateQuoteRight5: %v %v %v\n", s.pos(), string(c), s.parsing)
-	return s.end(c, 5, 5, 0, 0)
-}
-
 // {
 func stateBrL1(s *scanner, c int) int {
 	//fmt.Printf("stateBrL1: %v %v %v\n", s.pos(), string(c), s.parsing)
@@ -581,6 +432,11 @@ func stateBrR1(s *scanner, c int) int {
 // }}
 func stateBrR2(s *scanner, c int) int {
 	//fmt.Printf("stateBrR2: %v %v %v %v\n", s.pos(), string(c), s.parsing, s.parsingTopState)
+	switch s.parsingTopState {
+	case parseEntityTemplate, parseEntityTemplateName, parseEntityTemplateProp:
+	default:
+		return s.unmatched(c) // e.g. [[a|}}]]
+	}
 	if s.parsingTopState == parseEntityTemplateName {
 		s.popParseState(0, 2, 0, 0)
 	} else {
@@ -637,6 +493,16 @@ func stateSqR1(s *scanner, c int) int {
 	return s.step(s, c) //return scanContinue
 }
 
+// unmatched goes on scanning the entity of which the closing markup before c
+// is not, e.g. "]]" in a template.
+func (s *scanner) unmatched(c int) int {
+	if s.stateTop < 0 {
+		return s.begin(stateInEntityText, parseEntityText, scanBeginText, c, 0)
+	}
+	s.step = s.states[s.stateTop]
+	return s.step(s, c)
+}
+
 // ]]
 func stateSqR2(s *scanner, c int) int {
 	//fmt.Printf("stateSqR2: %v %v %v\n", s.pos(), string(c), s.parsing)
@@ -646,6 +512,9 @@ func stateSqR2(s *scanner, c int) int {
 	case parseEntityLink2Prop:
 		//fmt.Printf("stateSqR2: %v %v %v\n", s.pos(), string(c), s.parsing)
 		s.popParseState(1, 2, 0, 0)
+	case parseEntityLink2:
+	default:
+		return s.unmatched(c) // e.g. {{a|]]}}
 	}
 	//fmt.Printf("stateSqR2: %v %v %v\n", s.pos(), string(c), s.parsing)
 	return s.end(c, 2, 2, 0, 0)
@@ -708,9 +577,64 @@ func stateInTagBegSlashGt(s *scanner, c int) int {
 // in-tag '>' as in "<tag>"
 func stateInTagBegGt(s *scanner, c int) int {
 	//fmt.Printf("stateInTagBegGt: %v %v %v\n", s.pos(), string(c), s.parsing)
+	if end := s.rawTagEnd(); 0 <= end {
+		s.rawHead, s.rawEnd = s.pos() - 1, end
+		s.step = stateInRawTag
+		return s.step(s, c)
+	}
 	return s.end(c, 1, 1, 0, 0)
 }
 
+// rawTagEnd returns the end of the closing tag of the extension tag being
+// scanned, e.g. </math>, or -1 if it's not an extension tag or it's not
+// closed. The tag runs to the end of the data if it's not closed in the
+// partial data.
+func (s *scanner) rawTagEnd() int {
+	i := s.pos()
+	name, _ := tagName(string(s.data[s.parsingPos[s.parsingTop]:i-1]))
+	if s.rawTags == nil || !s.rawTags(name) {
+		return -1
+	}
+	end := len(s.data)
+	if n, ok := s.rawUnclosed[name]; ok && !s.partial {
+		end -= n
+	}
+	for j := i; j < end && j + len(name) + 3 <= len(s.data); j++ {
+		if s.data[j] != '<' || s.data[j+1] != '/' || !strings.EqualFold(string(s.data[j+2:j+2+len(name)]), name) {
+			continue
+		}
+		k := j + 2 + len(name)
+		for k < len(s.data) && (s.data[k] == ' ' || s.data[k] == '\t' || s.data[k] == '\n' || s.data[k] == '\r') {
+			k++
+		}
+		if k < len(s.data) && s.data[k] == '>' {
+			return k + 1
+		}
+	}
+	if s.partial {
+		return len(s.data) + 1
+	}
+	// The data ends at the end of the document without more data, it's
+	// the same for the rest of it.
+	if s.rawUnclosed == nil {
+		s.rawUnclosed = make(map[string]int)
+	}
+	if n := len(s.data) - i; s.rawUnclosed[name] < n {
+		s.rawUnclosed[name] = n
+	}
+	return -1
+}
+
+// in the body of an extension tag, e.g. <math>x^2</math>
+func stateInRawTag(s *scanner, c int) int {
+	if s.pos() < s.rawEnd {
+		return scanContinue
+	}
+	s.parsing[s.parsingTop] = parseEntityTag
+	s.parsingTopState = parseEntityTag
+	return s.end(c, 1, s.rawEnd - s.rawHead, 0, 0)
+}
+
 // in-tag slash as in </tag>
 func stateInTagEndSlash(s *scanner, c int) int {
 	return s.begin(stateInTagEnd, parseEntityTagEnd, scanBeginTagEnd, c, 2)
@@ -832,10 +756,19 @@ func stateInLineTerminal(s *scanner, c int) int {
 			break
 		}
 		//fmt.Printf("stateInLineTerminal: %v %v %v\n", num, s.parsingTop, s.parsing)
+		nested := num < s.parsingTop
 		for n := s.parsingTop; num < n; n-- {
 			s.popParseState(s.indent + s.newlineOffset, 0, 0, 0)
 			s.popStepState()
 		}
+		switch s.parsingTopState {
+		case parseEntityListBulleted, parseEntityListNumbered, parseEntityIndent:
+		default:
+			if nested {
+				// the lists in an entity not closed, e.g. [[a\n#b
+				return s.unmatched(c)
+			}
+		}
 		//fmt.Printf("stateInLineTerminal: %v %v %v\n", num, s.parsingTop, s.parsing) /**/
 		/*
 		for n := s.parsingTop; 0 < n; n-- {
@@ -1049,58 +982,6 @@ func stateInEntityText(s *scanner, c int) int {
 	return stateInEntity(s, c)
 }
 
-func inEntityTextQ(s *scanner, c int) int {
-	switch c {
-	case '\'':
-		s.step = stateQuoteRight1
-		return scanContinue
-	case '\n':
-		/*
-		num, foundLineTerm := s.parsingTop, false
-		for ; !foundLineTerm && 0 < num; num-- {
-			switch s.parsing[num] {
-			case parseEntityListBulleted:	foundLineTerm = true
-			case parseEntityListNumbered:	foundLineTerm = true
-			case parseEntityIndent:		foundLineTerm = true
-			}
-		}
-		//fmt.Printf("inEntityTextQ: %v %v %v\n", num, s.parsingTop, s.parsing)
-		if foundLineTerm {
-			for n := s.parsingTop; num < n; n-- {
-				s.popParseState(s.indent + s.newlineOffset, 0, 0, 0)
-				s.popStepState()
-			}
-		}
-		fmt.Printf("inEntityTextQ: %v %v %v\n", num, s.parsingTop, s.parsing) */
-		if 0 < s.parsingTop {
-			foundLineTerm := false
-			switch s.parsing[s.parsingTop-1] {
-			case parseEntityListBulleted, parseEntityListNumbered, parseEntityIndent:
-				foundLineTerm = true
-			}
-			if foundLineTerm {
-				s.popParseState(s.indent + s.newlineOffset, 0, 0, 0)
-				s.popStepState()
-				//fmt.Printf("inEntityTextQ: %v %v\n", s.parsingTop, s.parsing)
-				return s.states[s.stateTop](s, c)
-			}
-		}
-	}
-	return stateInEntity(s, c)
-}
-
-func stateInEntityTextBold(s *scanner, c int) int {
-	return inEntityTextQ(s, c)
-}
-
-func stateInEntityTextItalic(s *scanner, c int) int {
-	return inEntityTextQ(s, c)
-}
-
-func stateInEntityTextBoldItalic(s *scanner, c int) int {
-	return inEntityTextQ(s, c)
-}
-
 func stateInEntityTemplateName(s *scanner, c int) int {
 	s.pushParseState(parseEntityTemplateName)
 	s.step = stateInEntityTemplate
@@ -31,79 +31,61 @@ func TestScanEntity(t *testing.T) {
 		/***** 2 *****/
 		{`''italic''`,
 			[]result{
-				{parseEntityTextItalic, `''italic''`},
+				{parseEntityText, `''italic''`},
 			},
 		},
 		/***** 3 *****/
 		{`'''bold'''`,
 			[]result{
-				{parseEntityTextBold, `'''bold'''`},
+				{parseEntityText, `'''bold'''`},
 			},
 		},
 		/***** 4 *****/
 		{`'''''bold italic'''''`,
 			[]result{
-				{parseEntityTextBoldItalic, `'''''bold italic'''''`},
+				{parseEntityText, `'''''bold italic'''''`},
 			},
 		},
 		/***** 5 *****/
 		{`normal ''italic'' normal`,
 			[]result{
-				{parseEntityText, `normal `},
-				{parseEntityTextItalic, `''italic''`},
-				{parseEntityText, ` normal`},
+				{parseEntityText, `normal ''italic'' normal`},
 			},
 		},
 		/***** 6 *****/
 		{`normal '''bold''' normal`,
 			[]result{
-				{parseEntityText, `normal `},
-				{parseEntityTextBold, `'''bold'''`},
-				{parseEntityText, ` normal`},
+				{parseEntityText, `normal '''bold''' normal`},
 			},
 		},
 		/***** 7 *****/
 		{`normal ''italic'' normal '''bold''' normal '''''bold italic''''' normal`,
 			[]result{
-				{parseEntityText, `normal `},
-				{parseEntityTextItalic, `''italic''`},
-				{parseEntityText, ` normal `},
-				{parseEntityTextBold, `'''bold'''`},
-				{parseEntityText, ` normal `},
-				{parseEntityTextBoldItalic, `'''''bold italic'''''`},
-				{parseEntityText, ` normal`},
+				{parseEntityText, `normal ''italic'' normal '''bold''' normal '''''bold italic''''' normal`},
 			},
 		},
 		/***** 8 *****/
 		{`normal '''bold ''italic'' bold''' normal`,
 			[]result{
-				{parseEntityText, `normal `},
-				{parseEntityTextBold, `'''bold ''italic'' bold'''`},
-				{parseEntityText, ` normal`},
+				{parseEntityText, `normal '''bold ''italic'' bold''' normal`},
 			},
 		},
 		/***** 9 *****/
 		{`normal ''italic '''bold''' italic '''bold''' italic'' normal`,
 			[]result{
-				{parseEntityText, `normal `},
-				{parseEntityTextItalic, `''italic '''bold''' italic '''bold''' italic''`},
-				{parseEntityText, ` normal`},
+				{parseEntityText, `normal ''italic '''bold''' italic '''bold''' italic'' normal`},
 			},
 		},
 		/***** 10 *****/
 		{`normal ''italic 'abc' italic'' normal`,
 			[]result{
-				{parseEntityText, `normal `},
-				{parseEntityTextItalic, `''italic 'abc' italic''`},
-				{parseEntityText, ` normal`},
+				{parseEntityText, `normal ''italic 'abc' italic'' normal`},
 			},
 		},
 		/***** 11 *****/
 		{`normal '''bold 'a''b''c' bold''' normal`,
 			[]result{
-				{parseEntityText, `normal `},
-				{parseEntityTextBold, `'''bold 'a''b''c' bold'''`},
-				{parseEntityText, ` normal`},
+				{parseEntityText, `normal '''bold 'a''b''c' bold''' normal`},
 			},
 		},
 
@@ -419,7 +401,9 @@ text text`},
 		/***** 37 *****/
 		{`'''bold''bold-italic[[link''italic''link]]bold-italic''bold'''`,
 			[]result{
-				{parseEntityTextBold, `'''bold''bold-italic[[link''italic''link]]bold-italic''bold'''`},
+				{parseEntityText, `'''bold''bold-italic`},
+				{parseEntityLink2, `[[link''italic''link]]`},
+				{parseEntityText, `bold-italic''bold'''`},
 			},
 		},
 		// BUG fixes:
@@ -552,9 +536,7 @@ text text text
 			[]result{
 				{parseEntityHeader3, `
 ===Pronoun===`},
-				{parseEntityText, "\n"},
-				{parseEntityTextBold, "'''any'''"},
-				{parseEntityText, "\n"},
+				{parseEntityText, "\n'''any'''\n"},
 				{parseEntityListNumbered, `
 # Any thing(s) or person(s).`},
 				{parseEntityListNumbered, `
@@ -582,35 +564,25 @@ text text text
 		/***** 46 *****/ //BUG fixing
 		{`in '''A'''''a'' out`,
 			[]result{
-				{parseEntityText, "in "},
-				{parseEntityTextBold, "'''A'''"},
-				{parseEntityTextItalic, "''a''"},
-				{parseEntityText, " out"},
+				{parseEntityText, "in '''A'''''a'' out"},
 			},
 		},
 		/***** 47 *****/ //BUG fixing
 		{`in ''a'''''A''' out`,
 			[]result{
-				{parseEntityText, "in "},
-				{parseEntityTextItalic, "''a''"},
-				{parseEntityTextBold, "'''A'''"},
-				{parseEntityText, " out"},
+				{parseEntityText, "in ''a'''''A''' out"},
 			},
 		},
 		/***** 48 *****/ //BUG fixing
 		{`in '''''a''A''' out`,
 			[]result{
-				{parseEntityText, "in "},
-				{parseEntityTextBold, "'''''a''A'''"},
-				{parseEntityText, " out"},
+				{parseEntityText, "in '''''a''A''' out"},
 			},
 		},
 		/***** 49 *****/ //BUG fixing
 		{`in '''''A'''a'' out`,
 			[]result{
-				{parseEntityText, "in "},
-				{parseEntityTextItalic, "'''''A'''a''"},
-				{parseEntityText, " out"},
+				{parseEntityText, "in '''''A'''a'' out"},
 			},
 		},
 
@@ -693,16 +665,9 @@ File:UncialA-01.svg|Approximate form of Greek upper case Α (a, “alpha”) tha
 				{parseEntityTemplate, "{{Letter|page=A\n|NATO=Alpha\n|Morse=·–\n|Character=A1\n|Braille=⠁\n}}"},
 				{parseEntityText, "\n"},
 				{parseEntityTagBeg, `<gallery caption="Letter styles" perrow=3>`},
-				{parseEntityText, "\nImage:Latin A.png|Capital and lowercase versions of "},
-				{parseEntityTextBold, "'''A'''"},
-				{parseEntityText, ", in normal and italic type\nFile:Fraktur letter A.png|Uppercase and lowercase "},
-				{parseEntityTextBold, "'''A'''"},
-				{parseEntityText, " in "},
+				{parseEntityText, "\nImage:Latin A.png|Capital and lowercase versions of '''A''', in normal and italic type\nFile:Fraktur letter A.png|Uppercase and lowercase '''A''' in "},
 				{parseEntityLink2, "[[Fraktur]]"},
-				{parseEntityText, "\nFile:UncialA-01.svg|Approximate form of Greek upper case Α (a, “alpha”) that was the source for both common variants of "},
-				{parseEntityTextItalic, "''a''"},
-				{parseEntityTextBold, "'''A'''"},
-				{parseEntityText, " in "},
+				{parseEntityText, "\nFile:UncialA-01.svg|Approximate form of Greek upper case Α (a, “alpha”) that was the source for both common variants of ''a'''''A''' in "},
 				{parseEntityLink2, "[[uncial]]"},
 				{parseEntityText, " script\n"},
 				{parseEntityTagEnd, `</gallery>`},
@@ -857,3 +822,23 @@ File:UncialA-01.svg|Approximate form of Greek upper case Α (a, “alpha”) tha
 		}
 	}
 }
+
+func FuzzScanner(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		scan := new(scanner)
+		for n := 0; 0 < len(data); n++ {
+			entity, rest, err := scan.next(data)
+			if err != nil {
+				return
+			}
+			if len(entity) + len(rest) != len(data) || string(entity) + string(rest) != string(data) {
+				t.Fatalf("FuzzScanner: %q + %q != %q", entity, rest, data)
+			}
+			if len(entity) == 0 {
+				t.Fatalf("FuzzScanner: no progress: %q", data)
+			}
+			data = rest
+		}
+	})
+}
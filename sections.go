@@ -0,0 +1,131 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The sections are numbered the same way as section=N of MediaWiki: the
+// section 0 is the text before the first heading, the others are numbered
+// from 1 by the headings in order. A section includes the subsections of
+// it, it ends at the next heading of the same or a higher level. The
+// headings within <nowiki> and <pre> are not counted, neither are the
+// headings of the templates, which are not in the wikitext of the page.
+
+// PageSection is a section of a document.
+type PageSection struct {
+	Index int // N of section=N
+	Level int // 2 for == h ==, 0 for the section 0
+	Heading *Entity // nil for the section 0
+	Title string // the text of the heading without the markups
+	Pos, End int // the range of the section in the document
+}
+
+// sectionHeadings returns the headings of the sections in order.
+func sectionHeadings(wiki *Entity) (res []*Entity) {
+	skip := ""
+	var walk func(entities []*Entity)
+	walk = func(entities []*Entity) {
+		for _, e := range entities {
+			switch {
+			case e.Type == WikiEntityTagBeg:
+				if name, _ := tagName(e.Text); skip == "" && noLinkTags[name] {
+					skip = name
+				}
+			case e.Type == WikiEntityTagEnd:
+				if name, _ := tagName(strings.TrimPrefix(e.Text, "/")); name == skip {
+					skip = ""
+				}
+			case isHeading(e):
+				if skip == "" {
+					res = append(res, e)
+				}
+				walk(headingSection(e))
+			}
+		}
+	}
+	walk(wiki.Entities)
+	return
+}
+
+// documentEnd returns the end of the document of wiki, which is the end
+// of the last entity.
+func documentEnd(wiki *Entity) (end int) {
+	entities := wiki.Entities
+	for 0 < len(entities) {
+		e := entities[len(entities)-1]
+		if end = e.Pos + len(e.Raw); !isHeading(e) {
+			break
+		}
+		entities = headingSection(e)
+	}
+	return
+}
+
+// Sections returns the sections of the document of wiki, the n-th of which
+// is the section n.
+func Sections(wiki *Entity) []*PageSection {
+	headings := sectionHeadings(wiki)
+	end := documentEnd(wiki)
+	res := []*PageSection{ { End: end } }
+	if 0 < len(headings) {
+		res[0].End = headings[0].Pos
+	}
+	for i, h := range headings {
+		s := &PageSection{
+			Index: i + 1,
+			Level: int(h.Type - WikiEntityHeading2) + 2,
+			Heading: h,
+			Title: HeadingText(h),
+			Pos: h.Pos,
+			End: end,
+		}
+		for _, next := range headings[i+1:] {
+			if next.Type <= h.Type {
+				s.End = next.Pos
+				break
+			}
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// Section returns the section n of the document of wiki, or nil if there's
+// none.
+func Section(wiki *Entity, n int) *PageSection {
+	if sections := Sections(wiki); 0 <= n && n < len(sections) {
+		return sections[n]
+	}
+	return nil
+}
+
+// ReplaceSection returns the document src with the section n replaced by
+// the text, the same as MediaWiki: two newlines are added after the text if
+// it's not empty, and the spaces at the end of the document are removed.
+func ReplaceSection(src []byte, n int, text []byte) ([]byte, error) {
+	wiki, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	s := Section(wiki, n)
+	if s == nil {
+		return nil, fmt.Errorf("wiki: no section %d", n)
+	}
+	end := s.End
+	if end == documentEnd(wiki) {
+		end = len(src) // the last one
+	}
+	var b bytes.Buffer
+	b.Write(src[0:s.Pos])
+	if 0 < len(text) {
+		b.Write(text)
+		b.WriteString("\n\n")
+	}
+	b.Write(src[end:])
+	return bytes.TrimRight(b.Bytes(), " \t\r\n"), nil
+}
@@ -0,0 +1,76 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"testing"
+)
+
+func TestSections(t *testing.T) {
+	src := "lead\n\n== A ==\na\n=== B ===\nb\n<nowiki>\n== X ==\n</nowiki>\n== ''C'' [[d|D]] ==\nc\n==== E ====\ne\n"
+	wiki, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("TestSections: %v", err)
+	}
+	sections := Sections(wiki)
+	expects := []struct{
+		level int
+		title, text string
+	}{
+		{ 0, "", "lead\n\n" },
+		{ 2, "A", "== A ==\na\n=== B ===\nb\n<nowiki>\n== X ==\n</nowiki>\n" },
+		{ 3, "B", "=== B ===\nb\n<nowiki>\n== X ==\n</nowiki>\n" },
+		{ 2, "C D", "== ''C'' [[d|D]] ==\nc\n==== E ====\ne\n" },
+		{ 4, "E", "==== E ====\ne\n" },
+	}
+	if len(sections) != len(expects) {
+		t.Fatalf("TestSections: %d sections, expect %d", len(sections), len(expects))
+	}
+	for i, expect := range expects {
+		s := sections[i]
+		if s.Index != i || s.Level != expect.level || s.Title != expect.title || src[s.Pos:s.End] != expect.text {
+			t.Errorf("TestSections: [%d] %d %d %q %q", i, s.Index, s.Level, s.Title, src[s.Pos:s.End])
+		}
+		if i == 0 && s.Heading != nil || 0 < i && s.Heading == nil {
+			t.Errorf("TestSections: [%d] heading %v", i, s.Heading)
+		}
+	}
+	if s := Section(wiki, 3); s == nil || s.Title != "C D" || s.Pos != sections[3].Pos {
+		t.Errorf("TestSections: section 3 is %v", s)
+	}
+	if s := Section(wiki, 5); s != nil {
+		t.Errorf("TestSections: section 5 is %v", s)
+	}
+
+	replaces := []struct{
+		n int
+		text, res string
+	}{
+		{ 0, "new lead", "new lead\n\n== A ==\na\n=== B ===\nb\n<nowiki>\n== X ==\n</nowiki>\n== ''C'' [[d|D]] ==\nc\n==== E ====\ne" },
+		{ 2, "=== B2 ===\nb2", "lead\n\n== A ==\na\n=== B2 ===\nb2\n\n== ''C'' [[d|D]] ==\nc\n==== E ====\ne" },
+		{ 1, "", "lead\n\n== ''C'' [[d|D]] ==\nc\n==== E ====\ne" },
+		{ 4, "==== E ====\ne2\n", "lead\n\n== A ==\na\n=== B ===\nb\n<nowiki>\n== X ==\n</nowiki>\n== ''C'' [[d|D]] ==\nc\n==== E ====\ne2" },
+	}
+	for i, test := range replaces {
+		res, err := ReplaceSection([]byte(src), test.n, []byte(test.text))
+		if err != nil || string(res) != test.res {
+			t.Errorf("TestSections: [%d] replace %d: %v %q", i, test.n, err, string(res))
+		}
+	}
+	if _, err := ReplaceSection([]byte(src), 6, nil); err == nil {
+		t.Errorf("TestSections: expect an error of section 6")
+	}
+
+	data, err := readTestData("testdata/a.wiki.gz")
+	if err != nil {
+		t.Fatalf("TestSections: %v", err)
+	}
+	wiki, _ = Parse(data)
+	sections = Sections(wiki)
+	for i, s := range sections[1:] {
+		if s.Pos < sections[i].Pos || len(data) < s.End || string(data[s.Pos:s.Pos+len(s.Heading.Raw)]) != string(s.Heading.Raw) {
+			t.Errorf("TestSections: a.wiki.gz [%d] %q at %d", i + 1, s.Title, s.Pos)
+		}
+	}
+}
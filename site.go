@@ -0,0 +1,328 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The configuration of a site is loaded from the siteinfo of MediaWiki, the
+// JSON of the API, e.g.
+//
+//	api.php?action=query&meta=siteinfo&format=json&siprop=general|namespaces|namespacealiases|interwikimap|magicwords|extensiontags|protocols
+//
+// or the XML of the <siteinfo> in the header of a dump, which has only the
+// general information and the namespaces.
+
+// SiteNamespace is a namespace of a site.
+type SiteNamespace struct {
+	ID int // e.g. 4
+	Name string // the local name, e.g. "Wiktionary", "" of the main namespace
+	Canonical string // the canonical name, e.g. "Project"
+	CaseSensitive bool // the first letters of the names are not capitalized
+}
+
+// Interwiki is a prefix of the titles of another wiki, e.g. [[w:Foo]].
+type Interwiki struct {
+	Prefix string // e.g. "w"
+	URL string // e.g. "https://en.wikipedia.org/wiki/$1"
+	Local bool // it's a wiki of the same farm
+}
+
+// SiteConfig is the configuration of a wiki site.
+type SiteConfig struct {
+	SiteName string // e.g. "Wiktionary"
+	Server string // e.g. "https://en.wiktionary.org"
+	Language string // e.g. "en"
+	Namespaces []*SiteNamespace
+
+	// NamespaceAliases maps the aliases of the namespaces in lower case to
+	// the IDs, e.g. "image" to 6.
+	NamespaceAliases map[string]int
+
+	Interwikis []*Interwiki
+
+	// MagicWords are the aliases of the magic words by the IDs, e.g.
+	// "NOTOC", see ParseOptions.MagicWords.
+	MagicWords map[string][]string
+
+	ExtensionTags []string // the names of the tags of the extensions, e.g. "ref"
+	Protocols []string // the URL protocols, see ParseOptions.Protocols
+	LinkTrail *regexp.Regexp // see ParseOptions.LinkTrail
+}
+
+// canonicalNamespace returns the canonical name of the namespace id of
+// MediaWiki, or "" if it's not a namespace of MediaWiki.
+func canonicalNamespace(id int) string {
+	switch {
+	case -2 <= id && id < 0:
+		return DefaultNamespaces[id+2]
+	case 0 < id && id < len(DefaultNamespaces) - 1:
+		return DefaultNamespaces[id+1]
+	}
+	return ""
+}
+
+// Namespace returns the namespace of the id, or nil if there's none.
+func (c *SiteConfig) Namespace(id int) *SiteNamespace {
+	for _, ns := range c.Namespaces {
+		if ns.ID == id {
+			return ns
+		}
+	}
+	return nil
+}
+
+// TitleOptions returns the options of the titles of the site. The names of
+// the namespaces of the titles are the local names, the canonical names are
+// aliases.
+func (c *SiteConfig) TitleOptions() *TitleOptions {
+	opts := &TitleOptions{ Aliases: make(map[string]string) }
+	cases := make(map[string]bool)
+	for _, ns := range c.Namespaces {
+		if ns.ID == 0 {
+			cases[""] = ns.CaseSensitive
+			continue
+		}
+		opts.Namespaces = append(opts.Namespaces, ns.Name)
+		if ns.Canonical != "" {
+			opts.Aliases[strings.ToLower(ns.Canonical)] = ns.Name
+		}
+		cases[ns.Name] = ns.CaseSensitive
+	}
+	for alias, id := range c.NamespaceAliases {
+		if ns := c.Namespace(id); ns != nil {
+			opts.Aliases[alias] = ns.Name
+		}
+	}
+	for _, iw := range c.Interwikis {
+		opts.Interwikis = append(opts.Interwikis, iw.Prefix)
+	}
+	opts.CaseSensitive = func(ns string) bool { return cases[ns] }
+	return opts
+}
+
+// PageContext returns the context of the page of the title in the site.
+func (c *SiteConfig) PageContext(title string) *PageContext {
+	ctx := &PageContext{ Title: title, SiteName: c.SiteName, Server: c.Server }
+	for _, ns := range c.Namespaces {
+		if ns.ID != 0 {
+			ctx.Namespaces = append(ctx.Namespaces, ns.Name)
+		}
+	}
+	return ctx
+}
+
+// phpRegexpRegexp matches a regular expression of PHP, e.g. the link trail
+// "/^([a-z]+)(.*)$/sD".
+var phpRegexpRegexp = regexp.MustCompile(`^/(.*)/([a-zA-Z]*)$`)
+
+// linkTrailRegexp returns the link trail of the regular expression of PHP of
+// MediaWiki's linkTrail, which matches the trail as the first group.
+func linkTrailRegexp(s string) (*regexp.Regexp, error) {
+	m := phpRegexpRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("wiki: link trail %q is not a regular expression", s)
+	}
+	expr, flags := strings.TrimSuffix(m[1], "(.*)$"), ""
+	for _, c := range m[2] {
+		if c == 'i' || c == 's' || c == 'm' {
+			flags += string(c)
+		}
+	}
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// siteFlag is a flag of the API of MediaWiki, which is "" of formatversion=1
+// or true of formatversion=2 if it's set.
+type siteFlag bool
+
+func (f *siteFlag) UnmarshalJSON(data []byte) error {
+	*f = siteFlag(string(data) != "false" && string(data) != "null")
+	return nil
+}
+
+type siteinfoJSON struct {
+	Query struct {
+		General struct {
+			SiteName string `json:"sitename"`
+			Server string `json:"server"`
+			Lang string `json:"lang"`
+			LinkTrail string `json:"linktrail"`
+		} `json:"general"`
+		Namespaces map[string]struct {
+			ID int `json:"id"`
+			Name1 string `json:"*"`
+			Name2 string `json:"name"`
+			Canonical string `json:"canonical"`
+			Case string `json:"case"`
+		} `json:"namespaces"`
+		NamespaceAliases []struct {
+			ID int `json:"id"`
+			Alias1 string `json:"*"`
+			Alias2 string `json:"alias"`
+		} `json:"namespacealiases"`
+		InterwikiMap []struct {
+			Prefix string `json:"prefix"`
+			URL string `json:"url"`
+			Local siteFlag `json:"local"`
+		} `json:"interwikimap"`
+		MagicWords []struct {
+			Name string `json:"name"`
+			Aliases []string `json:"aliases"`
+		} `json:"magicwords"`
+		ExtensionTags []string `json:"extensiontags"`
+		Protocols []string `json:"protocols"`
+	} `json:"query"`
+}
+
+func (c *SiteConfig) loadJSON(r io.Reader) error {
+	var info siteinfoJSON
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return err
+	}
+	q := &info.Query
+	c.SiteName, c.Server, c.Language = q.General.SiteName, q.General.Server, q.General.Lang
+	for _, ns := range q.Namespaces {
+		c.Namespaces = append(c.Namespaces, &SiteNamespace{
+			ID: ns.ID,
+			Name: ns.Name1 + ns.Name2,
+			Canonical: ns.Canonical,
+			CaseSensitive: ns.Case == "case-sensitive",
+		})
+	}
+	for _, a := range q.NamespaceAliases {
+		c.NamespaceAliases[strings.ToLower(a.Alias1 + a.Alias2)] = a.ID
+	}
+	for _, iw := range q.InterwikiMap {
+		c.Interwikis = append(c.Interwikis, &Interwiki{ iw.Prefix, iw.URL, bool(iw.Local) })
+	}
+	for _, w := range q.MagicWords {
+		if id := strings.ToUpper(w.Name); MagicWords["en"][id] != nil {
+			c.MagicWords[id] = w.Aliases
+		}
+	}
+	for _, s := range q.ExtensionTags {
+		c.ExtensionTags = append(c.ExtensionTags, strings.Trim(s, "<>"))
+	}
+	c.Protocols = q.Protocols
+	if q.General.LinkTrail != "" {
+		trail, err := linkTrailRegexp(q.General.LinkTrail)
+		if err != nil {
+			return err
+		}
+		c.LinkTrail = trail
+	}
+	return nil
+}
+
+type siteinfoXML struct {
+	SiteName string `xml:"sitename"`
+	Base string `xml:"base"`
+	Namespaces []struct {
+		Key int `xml:"key,attr"`
+		Case string `xml:"case,attr"`
+		Name string `xml:",chardata"`
+	} `xml:"namespaces>namespace"`
+}
+
+func (c *SiteConfig) loadXML(r io.Reader) error {
+	d := xml.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return fmt.Errorf("wiki: no siteinfo")
+		} else if err != nil {
+			return err
+		}
+		start, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		for _, a := range start.Attr {
+			if start.Name.Local == "mediawiki" && a.Name.Local == "lang" {
+				c.Language = a.Value
+			}
+		}
+		if start.Name.Local != "siteinfo" {
+			continue
+		}
+		var info siteinfoXML
+		if err := d.DecodeElement(&info, &start); err != nil {
+			return err
+		}
+		c.SiteName = info.SiteName
+		if u, err := url.Parse(info.Base); err == nil && u.Host != "" {
+			c.Server = u.Scheme + "://" + u.Host
+		}
+		for _, ns := range info.Namespaces {
+			c.Namespaces = append(c.Namespaces, &SiteNamespace{
+				ID: ns.Key,
+				Name: ns.Name,
+				Canonical: canonicalNamespace(ns.Key),
+				CaseSensitive: ns.Case == "case-sensitive",
+			})
+		}
+		return nil // the pages of the dump are not read
+	}
+}
+
+// LoadSiteConfig reads the configuration of a site from the siteinfo in JSON
+// or in XML.
+func LoadSiteConfig(r io.Reader) (*SiteConfig, error) {
+	c := &SiteConfig{
+		NamespaceAliases: make(map[string]int),
+		MagicWords: make(map[string][]string),
+	}
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+	var err error
+	if b, _ := br.Peek(1); b[0] == '{' {
+		err = c.loadJSON(br)
+	} else {
+		err = c.loadXML(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.LinkTrail == nil {
+		c.LinkTrail = LinkTrails[c.Language]
+	}
+	sort.Slice(c.Namespaces, func(i, j int) bool {
+		return c.Namespaces[i].ID < c.Namespaces[j].ID
+	})
+	return c, nil
+}
+
+// LoadSiteConfigFile reads the configuration of a site from the siteinfo
+// file, e.g. a dump.
+func LoadSiteConfigFile(name string) (*SiteConfig, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadSiteConfig(f)
+}
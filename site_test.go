@@ -0,0 +1,118 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadSiteConfig(t *testing.T) {
+	site, err := LoadSiteConfigFile("testdata/siteinfo.json")
+	if err != nil {
+		t.Fatalf("TestLoadSiteConfig: %v", err)
+	}
+	if site.SiteName != "Wiktionary" || site.Server != "//en.wiktionary.org" || site.Language != "en" {
+		t.Errorf("TestLoadSiteConfig: %q %q %q", site.SiteName, site.Server, site.Language)
+	}
+	if len(site.Namespaces) != 10 || site.Namespaces[0].ID != -2 || site.Namespace(4).Name != "Wiktionary" || site.Namespace(4).Canonical != "Project" {
+		t.Errorf("TestLoadSiteConfig: namespaces %v", site.Namespaces)
+	}
+	if site.NamespaceAliases["image"] != 6 || len(site.Interwikis) != 2 || !site.Interwikis[0].Local || site.Interwikis[1].Local {
+		t.Errorf("TestLoadSiteConfig: %v %v", site.NamespaceAliases, site.Interwikis)
+	}
+	if len(site.MagicWords) != 2 || site.MagicWords["NOTOC"][0] != "__NOTOC__" {
+		t.Errorf("TestLoadSiteConfig: magic words %v", site.MagicWords)
+	}
+	if strings.Join(site.ExtensionTags, " ") != "pre nowiki ref math" || len(site.Protocols) != 3 {
+		t.Errorf("TestLoadSiteConfig: %v %v", site.ExtensionTags, site.Protocols)
+	}
+	if site.LinkTrail.String() != "(?s)^([a-z]+)" {
+		t.Errorf("TestLoadSiteConfig: link trail %v", site.LinkTrail)
+	}
+
+	opts := site.TitleOptions()
+	for s, expect := range map[string]string{
+		"á": "á",
+		"user:bob": "User:Bob",
+		"project:about": "Wiktionary:about",
+		"wt:About": "Wiktionary:About",
+		"image:a.png": "File:a.png",
+		"appendix:Variations of \"a\"": "Appendix:Variations of \"a\"",
+		"W:open front": "w:open front",
+	} {
+		if title, err := ParseTitle(s, opts); err != nil || title.PrefixedText() != expect {
+			t.Errorf("TestLoadSiteConfig: title of %q is %v, expect %q", s, title, expect)
+		}
+	}
+
+	wiki, _ := ParseWithOptions([]byte("[[a]]bc ftp://x.y gopher://z"), &ParseOptions{ Site: site })
+	if res, _ := Query(wiki, "linkinternal, linkurl"); len(res) != 2 || string(res[0].Raw) != "[[a]]bc" {
+		t.Errorf("TestLoadSiteConfig: %v", res)
+	}
+
+	wiki, _ = ParseWithOptions([]byte("<math>''x'' [[y]]</math> <ref>[[z]]</ref>"), &ParseOptions{ Site: site })
+	if res, _ := Query(wiki, "textitalic, linkinternal"); len(res) != 0 || len(wiki.Entities) != 3 || string(wiki.Entities[0].TagBody()) != "''x'' [[y]]" {
+		t.Errorf("TestLoadSiteConfig: extension tags %v", wiki.Entities)
+	}
+	if res, err := PreSaveTransform([]byte("[[wt:a (b)|]] <math>[[c|]]</math> [[d|]]"), &ParseOptions{ Site: site }); err != nil || string(res) != "[[wt:a (b)|a]] <math>[[c|]]</math> [[d|d]]" {
+		t.Errorf("TestLoadSiteConfig: pre-save transform %q %v", res, err)
+	}
+	tags := ExtensionTags{ "score": &ExtensionTag{} }
+	wiki, _ = ParseWithOptions([]byte("<score>[[a]]</score><math>[[b]]</math>"), &ParseOptions{ Site: site, ExtensionTags: tags })
+	if res, _ := Query(wiki, "linkinternal"); len(res) != 0 || len(tags) != 1 {
+		t.Errorf("TestLoadSiteConfig: extension tags %v", res)
+	}
+
+	wiki, _ = ParseWithOptions([]byte("{{WT:About}} {{appendix:a}} {{image:b}} {{foo}} [[wt:about]]"), &ParseOptions{ Site: site })
+	res, _ := Query(wiki, "template")
+	for i, expect := range []string{ "Wiktionary:About", "Appendix:a", "File:b", "Template:foo" } {
+		if target, err := res[i].TemplateTarget(); err != nil || target.Title != expect || target.Kind == TemplateTransclusion != (i == 3) {
+			t.Errorf("TestLoadSiteConfig: [%d] template target %v, expect %q", i, target, expect)
+		}
+	}
+	links, _ := Query(wiki, "linkinternal")
+	link := links[0]
+	if title, err := link.Title(nil); err != nil || title.PrefixedText() != "Wiktionary:about" {
+		t.Errorf("TestLoadSiteConfig: link title %v", title)
+	}
+	h := &treeHandler{}
+	if err := ParseEventsWithOptions([]byte("* {{WT:About}}"), h, &ParseOptions{ Site: site }); err != nil {
+		t.Errorf("TestLoadSiteConfig: %v", err)
+	} else if res, _ := Query(h.root, "template"); len(res) != 1 {
+		t.Errorf("TestLoadSiteConfig: templates %v", res)
+	} else if target, _ := res[0].TemplateTarget(); target.Title != "Wiktionary:About" {
+		t.Errorf("TestLoadSiteConfig: template target %v of events", target)
+	}
+	wiki, _ = ParseString("{{appendix:a}}")
+	if target, _ := wiki.Entities[0].TemplateTarget(); target.Title != "Template:Appendix:a" {
+		t.Errorf("TestLoadSiteConfig: template target %v without the site", target)
+	}
+
+	site, err = LoadSiteConfigFile("testdata/siteinfo.xml")
+	if err != nil {
+		t.Fatalf("TestLoadSiteConfig: %v", err)
+	}
+	if site.SiteName != "Wikipedia" || site.Server != "https://de.wikipedia.org" || site.Language != "de" || site.LinkTrail != LinkTrails["de"] {
+		t.Errorf("TestLoadSiteConfig: %q %q %q %v", site.SiteName, site.Server, site.Language, site.LinkTrail)
+	}
+	if ns := site.Namespace(6); ns == nil || ns.Name != "Datei" || ns.Canonical != "File" || ns.CaseSensitive {
+		t.Errorf("TestLoadSiteConfig: namespace %v", ns)
+	}
+	if title, _ := ParseTitle("file:ä.png", site.TitleOptions()); title == nil || title.PrefixedText() != "Datei:Ä.png" {
+		t.Errorf("TestLoadSiteConfig: title %v", title)
+	}
+	if ctx := site.PageContext("Datei:A"); len(ctx.Namespaces) != 7 {
+		t.Errorf("TestLoadSiteConfig: namespaces %v", ctx.Namespaces)
+	}
+
+	site, err = LoadSiteConfig(strings.NewReader(`{"query":{"namespaces":{"4":{"id":4,"case":"first-letter","name":"Wikipedia","canonical":"Project"}},
+		"namespacealiases":[{"id":4,"alias":"WP"}],"interwikimap":[{"prefix":"de","local":true,"url":"https://de.wikipedia.org/wiki/$1"}]}}`))
+	if err != nil || site.Namespace(4).Name != "Wikipedia" || site.NamespaceAliases["wp"] != 4 || !site.Interwikis[0].Local {
+		t.Errorf("TestLoadSiteConfig: formatversion=2 %v", err)
+	}
+	if _, err := LoadSiteConfig(strings.NewReader("<mediawiki></mediawiki>")); err == nil {
+		t.Errorf("TestLoadSiteConfig: expect an error of no siteinfo")
+	}
+}
@@ -0,0 +1,307 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The target of a template is classified the same way as braceSubstitution
+// of MediaWiki's Parser.php:
+//
+//	{{Name}}, {{Template:Name}}	TemplateTransclusion
+//	{{:Page}}, {{Help:Page}}	TemplatePage
+//	{{PAGENAME}}			TemplateVariable
+//	{{#if:a|b}}, {{lc:A}}		TemplateFunction
+//	{{int:Message}}			TemplateMessage
+//
+// The modifiers before the name, subst:, safesubst:, msgnw:, msg: and raw:,
+// are the flags of the target.
+//
+// The arguments of a template are the properties of it, the same way as
+// PPFrame of MediaWiki: an argument is named if there's a '=' out of the
+// nested entities, the name and the value of it are trimmed, e.g. "lang"
+// and "en" of "| lang = en ". The others are positional, numbered from 1
+// skipping the named ones, the values are not trimmed. The later arguments
+// of a name override the former ones, e.g. "2" of {{t|a|2=b|c}} is "c".
+
+// TemplateKind is the kind of the target of a template.
+type TemplateKind int8
+
+const (
+	TemplateTransclusion TemplateKind = iota // a page of the Template namespace
+	TemplatePage // a page of other namespaces, e.g. {{:Page}}
+	TemplateVariable // a variable of the magic words, e.g. {{PAGENAME}}
+	TemplateFunction // a parser function, e.g. {{#if:...}}, {{DEFAULTSORT:...}}
+	TemplateMessage // an interface message, {{int:...}}
+)
+
+var templateKindNames = []string{
+	TemplateTransclusion:	"TemplateTransclusion",
+	TemplatePage:		"TemplatePage",
+	TemplateVariable:	"TemplateVariable",
+	TemplateFunction:	"TemplateFunction",
+	TemplateMessage:	"TemplateMessage",
+}
+
+func (k TemplateKind) String() string {
+	return templateKindNames[int(k)]
+}
+
+// TemplateTarget is the target of a template, e.g. {{subst:foo_bar|x}}.
+type TemplateTarget struct {
+	Kind TemplateKind
+
+	// The title of the page and the namespace of it, e.g. "Template:Foo
+	// bar" and "Template", empty if it's not a page.
+	Title, Namespace string
+
+	// The name of a variable or a parser function and the argument after
+	// the colon, e.g. "#if" and "a" of {{#if:a|b}}. The name of a magic
+	// word is the ID of it, e.g. "PAGENAME", the others are lower-case.
+	Name, Arg string
+
+	Subst bool // subst: or safesubst:
+	SafeSubst bool // safesubst:
+	NoWiki bool // msgnw:, the wikitext of the page is not parsed
+}
+
+// parserFunctions are the parser functions of MediaWiki's
+// CoreParserFunctions.php not in MagicWords, the names starting with '#'
+// are all parser functions, e.g. #if.
+var parserFunctions = map[string]bool{
+	"ns": true, "nse": true, "urlencode": true, "lcfirst": true,
+	"ucfirst": true, "lc": true, "uc": true, "localurl": true,
+	"localurle": true, "fullurl": true, "fullurle": true,
+	"canonicalurl": true, "canonicalurle": true, "formatnum": true,
+	"grammar": true, "gender": true, "plural": true, "bidi": true,
+	"padleft": true, "padright": true, "anchorencode": true,
+	"filepath": true, "pagesincategory": true, "pagesize": true,
+	"protectionlevel": true, "protectionexpiry": true,
+	"numberingroup": true, "special": true, "speciale": true,
+	"tag": true, "formatdate": true, "language": true, "int": true,
+}
+
+// namespaceAliases are the aliases of the namespaces, in lower case.
+var namespaceAliases = map[string]string{
+	"image": "File", "image talk": "File talk",
+}
+
+// cutModifier returns s without the modifier prefix, e.g. "subst:", which is
+// case-insensitive, or false if s doesn't start with it.
+func cutModifier(s, prefix string) (string, bool) {
+	if len(prefix) <= len(s) && strings.EqualFold(s[0:len(prefix)], prefix) {
+		return strings.TrimSpace(s[len(prefix):]), true
+	}
+	return s, false
+}
+
+// templateTarget returns the target of the template name, nprops is the
+// number of the properties of the template, the titles are normalized with
+// opts. It returns an error if the page is not a valid title.
+func templateTarget(name string, nprops int, opts *TitleOptions) (*TemplateTarget, error) {
+	t := &TemplateTarget{}
+	s := strings.TrimSpace(name)
+	if s, t.SafeSubst = cutModifier(s, "safesubst:"); t.SafeSubst {
+		t.Subst = true
+	} else {
+		s, t.Subst = cutModifier(s, "subst:")
+	}
+	s, t.NoWiki = cutModifier(s, "msgnw:")
+	forced := t.NoWiki
+	for _, prefix := range []string{ "msg:", "raw:" } {
+		if !forced {
+			s, forced = cutModifier(s, prefix)
+		}
+	}
+
+	if i := strings.IndexByte(s, ':'); 0 < i && !forced {
+		prefix, arg := strings.TrimSpace(s[0:i]), strings.TrimSpace(s[i+1:])
+		if id := defaultMagicWords.ids[prefix + ":"]; isFunction(id) {
+			t.Kind, t.Name, t.Arg = TemplateFunction, id, arg
+			return t, nil
+		}
+		if id := defaultMagicWords.ids[prefix]; isVariable(id) {
+			t.Kind, t.Name, t.Arg = TemplateVariable, id, arg
+			return t, nil
+		}
+		if fn := strings.ToLower(prefix); strings.HasPrefix(fn, "#") || parserFunctions[fn] {
+			t.Kind, t.Name, t.Arg = TemplateFunction, fn, arg
+			if fn == "int" {
+				t.Kind = TemplateMessage
+			}
+			return t, nil
+		}
+	} else if id := defaultMagicWords.ids[s]; nprops == 0 && isVariable(id) && !forced {
+		t.Kind, t.Name = TemplateVariable, id
+		return t, nil
+	}
+
+	t.Kind = TemplatePage
+	template, ok := opts.namespace("Template")
+	if !ok {
+		template = "Template"
+	}
+	title, err := ParseTitle(s, opts)
+	if err == nil && !strings.HasPrefix(s, ":") && title.Namespace == "" && title.Interwiki == "" {
+		title, err = ParseTitle(template + ":" + s, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Namespace = title.Namespace; t.Namespace == template && title.Interwiki == "" {
+		t.Kind = TemplateTransclusion
+	}
+	t.Title = title.PrefixedText()
+	return t, nil
+}
+
+// TemplateTarget returns the target of the template e, which is either
+// WikiEntityTemplate or WikiEntityMagicWord. The namespaces are of
+// ParseOptions.Site if e is parsed with it.
+func (e *Entity) TemplateTarget() (*TemplateTarget, error) {
+	name, props := templateParts(e)
+	switch {
+	case e.Type == WikiEntityMagicWord:
+		arg, _ := magicWordArgs(e)
+		t := &TemplateTarget{ Kind: TemplateVariable, Name: e.Text, Arg: arg }
+		if isFunction(e.Text) {
+			t.Kind = TemplateFunction
+		}
+		return t, nil
+	case e.Type != WikiEntityTemplate:
+		return nil, fmt.Errorf("wiki: %v is not a template", e.Type)
+	case name == nil:
+		return nil, fmt.Errorf("wiki: %q has no name", string(e.Raw))
+	}
+	return templateTarget(name.Text, len(props), e.titleOptions())
+}
+
+// TemplateArg is an argument of a template.
+type TemplateArg struct {
+	Name string // the name, or the number of a positional argument, e.g. "1"
+	Positional bool
+
+	// Value is the value of the argument, of which the Raw is in the Raw of
+	// Prop and the children are copied from Prop.
+	Value *Entity
+
+	Prop *Entity // the WikiEntityTemplateProp of the argument
+}
+
+// TemplateArgs are the arguments of a template, see Entity.TemplateArgs.
+type TemplateArgs struct {
+	List []*TemplateArg // all arguments in order, including the overridden
+	args map[string]*TemplateArg
+}
+
+// Get returns the argument of the name, or nil if there's none.
+func (a *TemplateArgs) Get(name string) *TemplateArg {
+	return a.args[name]
+}
+
+// Arg returns the wikitext of the value of the argument of the name, or ""
+// if there's none.
+func (a *TemplateArgs) Arg(name string) string {
+	if arg := a.args[name]; arg != nil {
+		return arg.Value.Text
+	}
+	return ""
+}
+
+// Positional returns the wikitext of the value of the n-th positional
+// argument, n starts from 1. It's the same as Arg of the number, e.g.
+// "2=b".
+func (a *TemplateArgs) Positional(n int) string {
+	return a.Arg(strconv.Itoa(n))
+}
+
+// Duplicates returns the arguments overridden by the later ones of the same
+// names, MediaWiki tracks them as errors.
+func (a *TemplateArgs) Duplicates() (res []*TemplateArg) {
+	for _, arg := range a.List {
+		if a.args[arg.Name] != arg {
+			res = append(res, arg)
+		}
+	}
+	return
+}
+
+// subEntity returns the entity of e.Raw[a:b] of the same type, the children
+// of e within the range are copied to it.
+func subEntity(e *Entity, a, b int) *Entity {
+	sub := &Entity{ Type: e.Type, Pos: e.Pos + a, Raw: e.Raw[a:b], Text: string(e.Raw[a:b]) }
+	for _, child := range e.Entities {
+		if off := rawOffset(e, child); a <= off && off + len(child.Raw) <= b {
+			c := *child
+			c.Pos, c.parent = off - a, sub
+			sub.Entities = append(sub.Entities, &c)
+		}
+	}
+	return sub
+}
+
+// trimRange returns the range of e.Raw[a:b] without the spaces around.
+func trimRange(e *Entity, a, b int) (int, int) {
+	for a < b && isSpace(rune(e.Raw[a])) {
+		a++
+	}
+	for a < b && isSpace(rune(e.Raw[b-1])) {
+		b--
+	}
+	return a, b
+}
+
+// templateArg returns the argument of the property prop, n is the number of
+// it if it's positional.
+func templateArg(prop *Entity, n int) *TemplateArg {
+	a, b := innerRange(prop)
+	eq, skip := -1, ""
+	segments(prop, a, b, func(text []byte, child *Entity) {
+		switch {
+		case eq >= 0:
+		case child == nil && skip == "":
+			if i := strings.IndexByte(string(text), '='); 0 <= i {
+				eq = cap(prop.Raw) - cap(text) + i
+			}
+		case child == nil:
+		case child.Type == WikiEntityTagBeg:
+			if name, _ := tagName(child.Text); skip == "" && noLinkTags[name] {
+				skip = name
+			}
+		case child.Type == WikiEntityTagEnd:
+			if name, _ := tagName(strings.TrimPrefix(child.Text, "/")); name == skip {
+				skip = ""
+			}
+		}
+	})
+	if eq < 0 {
+		return &TemplateArg{ Name: strconv.Itoa(n), Positional: true, Value: subEntity(prop, a, b), Prop: prop }
+	}
+	ka, kb := trimRange(prop, a, eq)
+	va, vb := trimRange(prop, eq + 1, b)
+	return &TemplateArg{ Name: string(prop.Raw[ka:kb]), Value: subEntity(prop, va, vb), Prop: prop }
+}
+
+// TemplateArgs returns the arguments of the template e, which is either
+// WikiEntityTemplate or WikiEntityMagicWord.
+func (e *Entity) TemplateArgs() (*TemplateArgs, error) {
+	if e.Type != WikiEntityTemplate && e.Type != WikiEntityMagicWord {
+		return nil, fmt.Errorf("wiki: %v is not a template", e.Type)
+	}
+	_, props := templateParts(e)
+	args := &TemplateArgs{ args: make(map[string]*TemplateArg) }
+	n := 0
+	for _, prop := range props {
+		arg := templateArg(prop, n + 1)
+		if arg.Positional {
+			n++
+		}
+		args.List = append(args.List, arg)
+		args.args[arg.Name] = arg
+	}
+	return args, nil
+}
@@ -0,0 +1,118 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateTarget(t *testing.T) {
+	tests := []struct{
+		src string
+		target TemplateTarget
+	}{
+		{ `{{foo_bar|x}}`, TemplateTarget{ Kind: TemplateTransclusion, Title: "Template:Foo bar", Namespace: "Template" } },
+		{ `{{ Template:foo }}`, TemplateTarget{ Kind: TemplateTransclusion, Title: "Template:Foo", Namespace: "Template" } },
+		{ `{{:main page}}`, TemplateTarget{ Kind: TemplatePage, Title: "Main page" } },
+		{ `{{:help:a}}`, TemplateTarget{ Kind: TemplatePage, Title: "Help:A", Namespace: "Help" } },
+		{ `{{user_talk:Foo/sig}}`, TemplateTarget{ Kind: TemplatePage, Title: "User talk:Foo/sig", Namespace: "User talk" } },
+		{ `{{image:a.png}}`, TemplateTarget{ Kind: TemplatePage, Title: "File:A.png", Namespace: "File" } },
+		{ `{{Foo:bar}}`, TemplateTarget{ Kind: TemplateTransclusion, Title: "Template:Foo:bar", Namespace: "Template" } },
+		{ `{{subst:foo}}`, TemplateTarget{ Kind: TemplateTransclusion, Title: "Template:Foo", Namespace: "Template", Subst: true } },
+		{ `{{SafeSubst: :a}}`, TemplateTarget{ Kind: TemplatePage, Title: "A", Subst: true, SafeSubst: true } },
+		{ `{{msgnw:foo}}`, TemplateTarget{ Kind: TemplateTransclusion, Title: "Template:Foo", Namespace: "Template", NoWiki: true } },
+		{ `{{msg:PAGENAME}}`, TemplateTarget{ Kind: TemplateTransclusion, Title: "Template:PAGENAME", Namespace: "Template" } },
+		{ `{{subst:PAGENAME}}`, TemplateTarget{ Kind: TemplateVariable, Name: "PAGENAME", Subst: true } },
+		{ `{{PAGENAME}}`, TemplateTarget{ Kind: TemplateVariable, Name: "PAGENAME" } },
+		{ `{{PAGENAME|x}}`, TemplateTarget{ Kind: TemplateTransclusion, Title: "Template:PAGENAME", Namespace: "Template" } },
+		{ `{{PAGENAME:Foo}}`, TemplateTarget{ Kind: TemplateVariable, Name: "PAGENAME", Arg: "Foo" } },
+		{ `{{DEFAULTSORT:a|noreplace}}`, TemplateTarget{ Kind: TemplateFunction, Name: "DEFAULTSORT", Arg: "a" } },
+		{ `{{#If: a | b }}`, TemplateTarget{ Kind: TemplateFunction, Name: "#if", Arg: "a" } },
+		{ `{{subst:#invoke:m|f}}`, TemplateTarget{ Kind: TemplateFunction, Name: "#invoke", Arg: "m", Subst: true } },
+		{ `{{LC:A}}`, TemplateTarget{ Kind: TemplateFunction, Name: "lc", Arg: "A" } },
+		{ `{{int:Mainpage}}`, TemplateTarget{ Kind: TemplateMessage, Name: "int", Arg: "Mainpage" } },
+	}
+	for i, test := range tests {
+		wiki, err := ParseString(test.src)
+		if err != nil || len(wiki.Entities) != 1 {
+			t.Errorf("TestTemplateTarget: [%d] %v %v", i, err, wiki)
+			continue
+		}
+		target, err := wiki.Entities[0].TemplateTarget()
+		if err != nil {
+			t.Errorf("TestTemplateTarget: [%d] %v", i, err)
+			continue
+		}
+		if *target != test.target {
+			t.Errorf("TestTemplateTarget: [%d] %s: expect %+v, got %+v", i, test.src, test.target, *target)
+		}
+	}
+
+	wiki, _ := ParseString(`text`)
+	if _, err := wiki.Entities[0].TemplateTarget(); err == nil {
+		t.Errorf("TestTemplateTarget: expect an error of text")
+	}
+}
+
+func TestTemplateArgs(t *testing.T) {
+	src := "{{t| a = {{b|c}} |x [[y|z]] | 2 = w |=v|<nowiki>k=</nowiki>|a=dup\n|lang=en}}"
+	wiki, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("TestTemplateArgs: %v", err)
+	}
+	args, err := wiki.Entities[0].TemplateArgs()
+	if err != nil {
+		t.Fatalf("TestTemplateArgs: %v", err)
+	}
+	tests := []struct{
+		name, value string
+		positional bool
+		children int
+	}{
+		{ "a", "{{b|c}}", false, 1 },
+		{ "1", "x [[y|z]] ", true, 1 },
+		{ "2", "w", false, 0 },
+		{ "", "v", false, 0 },
+		{ "2", "<nowiki>k=</nowiki>", true, 2 },
+		{ "a", "dup", false, 0 },
+		{ "lang", "en", false, 0 },
+	}
+	if len(args.List) != len(tests) {
+		t.Fatalf("TestTemplateArgs: expect %d arguments, got %d", len(tests), len(args.List))
+	}
+	for i, test := range tests {
+		arg := args.List[i]
+		if arg.Name != test.name || arg.Value.Text != test.value || arg.Positional != test.positional || len(arg.Value.Entities) != test.children {
+			t.Errorf("TestTemplateArgs: [%d] expect %q=%q (%v, %d), got %q=%q (%v, %d)", i, test.name, test.value, test.positional, test.children, arg.Name, arg.Value.Text, arg.Positional, len(arg.Value.Entities))
+		}
+		for _, child := range arg.Value.Entities {
+			if off := rawOffset(arg.Value, child); off != child.Pos {
+				t.Errorf("TestTemplateArgs: [%d] %v: expect pos %d, got %d", i, child, off, child.Pos)
+			}
+		}
+	}
+	if s := args.Arg("a"); s != "dup" {
+		t.Errorf("TestTemplateArgs: expect a=dup, got %q", s)
+	}
+	if s := args.Positional(2); s != "<nowiki>k=</nowiki>" {
+		t.Errorf("TestTemplateArgs: expect 2=<nowiki>k=</nowiki>, got %q", s)
+	}
+	if s := PlainText(args.Get("1").Value); s != "x z " {
+		t.Errorf("TestTemplateArgs: expect plain text %q, got %q", "x z ", s)
+	}
+	if args.Get("3") != nil || args.Arg("none") != "" {
+		t.Errorf("TestTemplateArgs: expect no 3 and none")
+	}
+	var dups []string
+	for _, arg := range args.Duplicates() {
+		dups = append(dups, arg.Name + "=" + arg.Value.Text)
+	}
+	if s := strings.Join(dups, " "); s != "a={{b|c}} 2=w" {
+		t.Errorf("TestTemplateArgs: expect duplicates %q, got %q", "a={{b|c}} 2=w", s)
+	}
+	if _, err := wiki.Entities[0].Entities[0].TemplateArgs(); err == nil {
+		t.Errorf("TestTemplateArgs: expect an error of the name")
+	}
+}
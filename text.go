@@ -0,0 +1,331 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// textRenderer writes the texts of the entities without the markups, or in
+// Markdown if markdown is true. The character references are decoded.
+type textRenderer struct {
+	w io.Writer
+	err error
+	markdown bool
+	nl int // number of the newlines at the end, -1 if nothing is written
+}
+
+// markdownEscaper escapes the characters of the Markdown markups.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`,
+	`_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`, `>`, `\>`)
+
+// markdownEscape escapes the text s for Markdown, including the '&' of the
+// texts like character references, e.g. "&amp;" of "&amp;amp;".
+func markdownEscape(s string) string {
+	s = markdownEscaper.Replace(s)
+	return charRefRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		return `\` + ref
+	})
+}
+
+// markdownURL returns the URL u for the links of Markdown.
+func markdownURL(u string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(u)
+}
+
+func (r *textRenderer) write(s string) {
+	if s == "" {
+		return
+	}
+	if r.err == nil {
+		_, r.err = io.WriteString(r.w, s)
+	}
+	n := len(s) - len(strings.TrimRight(s, "\n"))
+	if n == len(s) && 0 < r.nl {
+		r.nl += n
+	} else {
+		r.nl = n
+	}
+}
+
+// line starts a new line if it's not at the beginning of a line.
+func (r *textRenderer) line() {
+	if r.nl == 0 {
+		r.write("\n")
+	}
+}
+
+// blank starts a block, which is after a blank line in Markdown.
+func (r *textRenderer) blank() {
+	r.line()
+	if r.markdown && r.nl == 1 {
+		r.write("\n")
+	}
+}
+
+func (r *textRenderer) text(s string) {
+	if r.markdown {
+		s = markdownEscape(s)
+	}
+	r.write(s)
+}
+
+// content renders e.Raw[a:b], texts and the child entities within.
+func (r *textRenderer) content(e *Entity, a, b int) {
+	segments(e, a, b, func(text []byte, child *Entity) {
+		if child == nil {
+			r.text(string(text))
+		} else {
+			r.entity(child)
+		}
+	})
+}
+
+func (r *textRenderer) inner(e *Entity) {
+	a, b := innerRange(e)
+	r.content(e, a, b)
+}
+
+// block renders a sequence of sibling entities.
+func (r *textRenderer) block(entities []*Entity) {
+	for i := 0; i < len(entities); {
+		if !isList(entities[i]) {
+			r.entity(entities[i])
+			i++
+			continue
+		}
+		n := i + 1
+		for n < len(entities) && isList(entities[n]) {
+			n++
+		}
+		r.lists(entities[i:n])
+		i = n
+	}
+}
+
+// listMarker returns the Markdown of the list markups of a list item, e.g.
+// "  1. " of "*#".
+func listMarker(prefix string) (s string) {
+	for i := 0; i < len(prefix); i++ {
+		marker := "- "
+		switch prefix[i] {
+		case '#': marker = "1. "
+		case ':': marker = ""
+		}
+		if i < len(prefix) - 1 {
+			marker = strings.Repeat(" ", len(marker))
+		}
+		s += marker
+	}
+	return
+}
+
+// lists renders a run of list items, a line for each.
+func (r *textRenderer) lists(items []*Entity) {
+	r.blank()
+	for _, item := range items {
+		prefix := listPrefix(item)
+		r.line()
+		if r.markdown {
+			r.write(listMarker(prefix))
+		}
+		start := true
+		listContent(item, len(prefix), func(text []byte, child *Entity) {
+			if child != nil {
+				r.entity(child)
+			} else if start {
+				r.text(string(bytes.TrimLeft(text, " \t")))
+			} else {
+				r.text(string(text))
+			}
+			start = false
+		})
+		r.line()
+	}
+}
+
+func (r *textRenderer) heading(e *Entity) {
+	r.blank()
+	if r.markdown {
+		r.write(strings.Repeat("#", int(e.Type - WikiEntityHeading2) + 2) + " ")
+	}
+	a, b := headingRange(e)
+	r.content(e, a, b)
+	r.write("\n")
+	r.block(headingSection(e))
+}
+
+func (r *textRenderer) linkInternal(e *Entity) {
+	if isCategoryLink(e) {
+		return // category links are not rendered in place
+	}
+	label := linkLabelText(e)
+	if !r.markdown {
+		r.write(label)
+		return
+	}
+	name, _ := linkParts(e)
+	t, err := e.Title(nil)
+	if err != nil {
+		r.write(markdownEscape(label)) // not a valid title
+		return
+	}
+	href := "/wiki/" + t.PrefixedURL()
+	if i := strings.IndexByte(name, '#'); i == 0 {
+		href = fragmentURL(name[1:], AnchorHTML5)
+	} else if 0 < i {
+		href += fragmentURL(name[i+1:], AnchorHTML5)
+	}
+	r.write("[" + markdownEscape(label) + "](" + markdownURL(href) + ")")
+}
+
+func (r *textRenderer) linkExternal(e *Entity) {
+	u, label := externalLinkParts(e)
+	if u == nil {
+		a, b := innerRange(e)
+		r.text("[")
+		r.content(e, a, b)
+		r.text("]")
+		return
+	}
+	href := decodeCharRefs(u.Raw)
+	switch {
+	case !r.markdown && label != nil:
+		r.inner(label)
+	case !r.markdown:
+		r.write(href)
+	case label != nil:
+		r.write("[")
+		r.inner(label)
+		r.write("](" + markdownURL(href) + ")")
+	default:
+		r.write("<" + href + ">")
+	}
+}
+
+func (r *textRenderer) redirect(e *Entity) {
+	title, fragment := redirectTarget(e, nil)
+	href, text := "/wiki/" + urlencode(title) + fragmentURL(fragment, AnchorHTML5), title
+	if fragment != "" {
+		text += "#" + fragment
+	}
+	r.line()
+	if r.markdown {
+		r.write("Redirect to: [" + markdownEscape(text) + "](" + markdownURL(href) + ")\n")
+	} else {
+		r.write("Redirect to: " + text + "\n")
+	}
+}
+
+func (r *textRenderer) quote(e *Entity, markup string) {
+	if r.markdown {
+		r.write(markup)
+	}
+	r.inner(e)
+	if r.markdown {
+		r.write(markup)
+	}
+}
+
+func (r *textRenderer) entity(e *Entity) {
+	switch e.Type {
+	case WikiEntityWiki:
+		r.block(e.Entities)
+	case WikiEntityText:
+		r.text(e.Text)
+	case WikiEntityTextBold:
+		r.quote(e, "**")
+	case WikiEntityTextItalic:
+		r.quote(e, "*")
+	case WikiEntityTextBoldItalic:
+		if len(e.Raw) == 5 {
+			break // a lonely ''''' at the end of a line
+		}
+		r.quote(e, "***")
+	case WikiEntityHeading2, WikiEntityHeading3, WikiEntityHeading4, WikiEntityHeading5:
+		r.heading(e)
+	case WikiEntityLinkInternal:
+		r.linkInternal(e)
+	case WikiEntityLinkExternal:
+		r.linkExternal(e)
+	case WikiEntityTag, WikiEntityTagBeg, WikiEntityTagEnd:
+		if a, b, ok := tagBodyRange(e); ok {
+			r.content(e, a, b) // the body of an extension tag
+			break
+		}
+		if name, _ := tagName(strings.TrimPrefix(e.Text, "/")); name != "br" {
+			break // the tags are not rendered
+		}
+		if r.markdown {
+			r.write("\\\n")
+		} else {
+			r.write("\n")
+		}
+	case WikiEntityListBulleted, WikiEntityListNumbered, WikiEntityIndent:
+		r.lists([]*Entity{ e })
+	case WikiEntityHR:
+		r.blank()
+		if r.markdown {
+			r.write("---\n")
+		}
+	case WikiEntityLinkURL:
+		if r.markdown {
+			r.write("<" + decodeCharRefs(e.Raw) + ">")
+		} else {
+			r.write(decodeCharRefs(e.Raw))
+		}
+	case WikiEntityLinkISBN, WikiEntityLinkRFC, WikiEntityLinkPMID:
+		r.text(decodeCharRefs(e.Raw))
+	case WikiEntityRedirect:
+		r.redirect(e)
+	case WikiEntityCharRef:
+		if r.markdown && e.Text == "&" {
+			r.write(`\&`) // not a reference with the text after it
+		} else {
+			r.text(e.Text)
+		}
+	case WikiEntityTemplate, WikiEntityMagicWord, WikiEntityBehaviorSwitch:
+		// not rendered
+	case WikiEntityParagraph:
+		r.blank(); r.content(e, 0, len(e.Raw)); r.line()
+	case WikiEntityLinkInternalName, WikiEntityLinkInternalProp,
+		WikiEntityLinkExternalURL, WikiEntityLinkExternalLabel,
+		WikiEntityTemplateName, WikiEntityTemplateProp:
+		r.inner(e)
+	default:
+		r.text(string(e.Raw))
+	}
+}
+
+// RenderPlainText writes the text of the entity e to w without the markups,
+// e.g. the labels of the links, with the character references decoded.
+func RenderPlainText(w io.Writer, e *Entity) error {
+	r := &textRenderer{ w: w, nl: -1 }
+	r.entity(e)
+	return r.err
+}
+
+// PlainText returns the text of the entity e without the markups.
+func PlainText(e *Entity) string {
+	var b bytes.Buffer
+	RenderPlainText(&b, e)
+	return b.String()
+}
+
+// RenderMarkdown writes the entity e to w in Markdown, the templates and the
+// tags are not rendered except <br>.
+func RenderMarkdown(w io.Writer, e *Entity) error {
+	r := &textRenderer{ w: w, markdown: true, nl: -1 }
+	r.entity(e)
+	return r.err
+}
+
+// Markdown returns the entity e in Markdown.
+func Markdown(e *Entity) string {
+	var b bytes.Buffer
+	RenderMarkdown(&b, e)
+	return b.String()
+}
@@ -0,0 +1,37 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		src, text, markdown string
+	}{
+		{ ``, ``, `` },
+		{ `a &amp; b &mdash; &bogus; &amp;lt;`, `a & b — &bogus; &lt;`, `a \& b — \&bogus; \&lt;` },
+		{ `a '''b''' ''c'' '''''d''''' *e*`, `a b c d *e*`, `a **b** *c* ***d*** \*e\*` },
+		{ `[[foo bar]] [[a|b&alpha;]] [[c]]s [[Category:X]]`, `foo bar bα cs `, `[foo bar](/wiki/Foo_bar) [bα](/wiki/A) [cs](/wiki/C) ` },
+		{ `[http://a.b/?c&amp;d e] [http://f.g/] http://h.i/`, `e http://f.g/ http://h.i/`, `[e](http://a.b/?c&d) <http://f.g/> <http://h.i/>` },
+		{ `<b>a</b><br/>b {{c}} __NOTOC__`, "a\nb  ", "a\\\nb  " },
+		{ "== A &amp; [[b]] ==\ntext", "A & b\n\ntext", "## A \\& [b](/wiki/B)\n\ntext" },
+		{ "a\n* b\n** c\n*# d\n# e\n----\nf", "a\nb\nc\nd\ne\n\nf", "a\n\n- b\n  - c\n  1. d\n1. e\n\n---\n\nf" },
+		{ "#REDIRECT [[a b#c]]", "Redirect to: A b#c\n", "Redirect to: [A b#c](/wiki/A_b#c)\n" },
+	}
+	for i, test := range tests {
+		wiki, err := ParseString(test.src)
+		if err != nil {
+			t.Errorf("TestPlainText: [%d] %v", i, err)
+			continue
+		}
+		if s := PlainText(wiki); s != test.text {
+			t.Errorf("TestPlainText: [%d] expect %q, got %q", i, test.text, s)
+		}
+		if s := Markdown(wiki); s != test.markdown {
+			t.Errorf("TestPlainText: [%d] Markdown: expect %q, got %q", i, test.markdown, s)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A title is normalized the same way as MediaWiki's TitleParser: the runs of
+// spaces and underscores are folded into one space, the spaces around are
+// removed, the prefix of a namespace is replaced by the canonical name of
+// it, and the first letter of the name is capitalized unless the namespace
+// is case-sensitive, e.g. "Help:Magic words" of " help : magic__words".
+// The text after '#' is the fragment, e.g. "Verb" of "book#Verb". The
+// titles of other wikis are not normalized except the prefixes, e.g.
+// "open front" of "w:open front".
+
+// TitleOptions controls the normalization of titles.
+type TitleOptions struct {
+	Namespaces []string // the canonical namespaces, DefaultNamespaces if nil
+
+	// Aliases maps the aliases of the namespaces in lower case to the
+	// canonical names, e.g. "image" to "File", it's the default aliases of
+	// MediaWiki if nil.
+	Aliases map[string]string
+
+	// Interwikis are the prefixes of the titles of other wikis, e.g. "w"
+	// and "wikipedia", which are case-insensitive.
+	Interwikis []string
+
+	// CaseSensitive tells if the names of the namespace are case-sensitive,
+	// e.g. of the main namespace of Wiktionary. The first letters of the
+	// names are capitalized if it's nil.
+	CaseSensitive func(ns string) bool
+}
+
+// Title is the title of a page, e.g. the target of an internal link.
+type Title struct {
+	Interwiki string // the prefix of another wiki in lower case, e.g. "w"
+	Namespace string // the canonical namespace, "" of the main namespace
+	Name string // the name in the namespace, e.g. "Magic words"
+	Fragment string // the section of the page, e.g. "Verb" of "book#Verb"
+}
+
+// titleSpaceRegexp matches the runs of the spaces of titles, the same as
+// MediaWiki's MediaWikiTitleCodec.
+var titleSpaceRegexp = regexp.MustCompile(`[ _\x{a0}\x{1680}\x{180e}\x{2000}-\x{200a}\x{2028}\x{2029}\x{202f}\x{205f}\x{3000}]+`)
+
+// directionMarkRegexp matches the direction marks, which are removed from
+// titles.
+var directionMarkRegexp = regexp.MustCompile(`[\x{200e}\x{200f}\x{202a}-\x{202e}]`)
+
+// illegalTitleRegexp matches the characters not allowed in titles, and the
+// escaped characters of URLs, e.g. "%20".
+var illegalTitleRegexp = regexp.MustCompile(`[<>\[\]{}|\x00-\x1f\x7f]|%[0-9A-Fa-f]{2}`)
+
+// foldTitle returns s with the spaces folded and trimmed.
+func foldTitle(s string) string {
+	s = directionMarkRegexp.ReplaceAllString(s, "")
+	return strings.Trim(titleSpaceRegexp.ReplaceAllString(s, " "), " ")
+}
+
+// ucfirst returns s with the first letter in upper case.
+func ucfirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	if u := unicode.ToUpper(r); u != r {
+		return string(u) + s[n:]
+	}
+	return s
+}
+
+// namespace returns the canonical name of the namespace prefix, or false if
+// it's not a namespace.
+func (o *TitleOptions) namespace(prefix string) (string, bool) {
+	namespaces, aliases := DefaultNamespaces, namespaceAliases
+	if o != nil && o.Namespaces != nil {
+		namespaces = o.Namespaces
+	}
+	if o != nil && o.Aliases != nil {
+		aliases = o.Aliases
+	}
+	for _, s := range namespaces {
+		if strings.EqualFold(s, prefix) {
+			return s, true
+		}
+	}
+	s, ok := aliases[strings.ToLower(prefix)]
+	return s, ok
+}
+
+// caseSensitive tells if the names of the namespace ns are case-sensitive.
+func (o *TitleOptions) caseSensitive(ns string) bool {
+	return o != nil && o.CaseSensitive != nil && o.CaseSensitive(ns)
+}
+
+// interwiki returns the interwiki prefix in lower case, or false if it's not
+// an interwiki prefix.
+func (o *TitleOptions) interwiki(prefix string) (string, bool) {
+	if o != nil {
+		for _, s := range o.Interwikis {
+			if strings.EqualFold(s, prefix) {
+				return strings.ToLower(s), true
+			}
+		}
+	}
+	return "", false
+}
+
+// ParseTitle returns the normalized title of s, e.g. the target of a link.
+func ParseTitle(s string, opts *TitleOptions) (*Title, error) {
+	t := &Title{}
+	name := foldTitle(s)
+	if i := strings.IndexByte(name, '#'); 0 <= i {
+		name, t.Fragment = strings.TrimRight(name[0:i], " "), name[i+1:]
+	}
+	if m := illegalTitleRegexp.FindString(name); m != "" {
+		return nil, fmt.Errorf("wiki: title %q has illegal characters %q", s, m)
+	}
+	if strings.HasPrefix(name, ":") {
+		name = strings.TrimLeft(name[1:], " ")
+	}
+	if i := strings.IndexByte(name, ':'); 0 < i {
+		prefix, rest := strings.TrimRight(name[0:i], " "), strings.TrimLeft(name[i+1:], " ")
+		if ns, ok := opts.namespace(prefix); ok {
+			t.Namespace, name = ns, rest
+		} else if iw, ok := opts.interwiki(prefix); ok {
+			t.Interwiki, t.Name = iw, rest
+			return t, nil
+		}
+	}
+	if name == "" && (t.Namespace != "" || t.Fragment == "") {
+		return nil, fmt.Errorf("wiki: title %q has no name", s)
+	}
+	if !opts.caseSensitive(t.Namespace) {
+		name = ucfirst(name)
+	}
+	t.Name = name
+	return t, nil
+}
+
+// Title returns the normalized title of the target of an internal link, e.g.
+// WikiEntityLinkInternal or WikiEntityLinkInternalName. The character
+// references of the target are decoded. The options of the titles are of
+// ParseOptions.Site if opts is nil.
+func (e *Entity) Title(opts *TitleOptions) (*Title, error) {
+	if opts == nil {
+		opts = e.titleOptions()
+	}
+	name := e.Text
+	switch e.Type {
+	case WikiEntityLinkInternal:
+		name, _ = linkParts(e)
+	case WikiEntityLinkInternalName:
+	default:
+		return nil, fmt.Errorf("wiki: %v is not an internal link", e.Type)
+	}
+	return ParseTitle(decodeCharRefs([]byte(name)), opts)
+}
+
+// PrefixedText returns the title with the prefixes, e.g. "Help:Magic words"
+// and "w:open front".
+func (t *Title) PrefixedText() string {
+	s := fullTitle(t.Namespace, t.Name)
+	if t.Interwiki != "" {
+		s = t.Interwiki + ":" + s
+	}
+	return s
+}
+
+// PrefixedURL returns the title with the prefixes encoded for URLs the same
+// as MediaWiki's wfUrlencode, e.g. "Appendix:Variations_of_%22a%22".
+func (t *Title) PrefixedURL() string {
+	return urlencode(t.PrefixedText())
+}
+
+// String returns the title with the prefixes and the fragment.
+func (t *Title) String() string {
+	if t.Fragment != "" {
+		return t.PrefixedText() + "#" + t.Fragment
+	}
+	return t.PrefixedText()
+}
@@ -0,0 +1,64 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"testing"
+)
+
+func TestParseTitle(t *testing.T) {
+	wikipedia := &TitleOptions{ Interwikis: []string{ "w", "Wikipedia" } }
+	wiktionary := &TitleOptions{
+		Namespaces: append([]string{ "Appendix", "Wiktionary" }, DefaultNamespaces...),
+		Aliases: map[string]string{ "wt": "Wiktionary", "image": "File" },
+		Interwikis: []string{ "w" },
+		CaseSensitive: func(ns string) bool { return ns != "User" },
+	}
+	tests := []struct{
+		s string
+		opts *TitleOptions
+		title, url, fragment string
+	}{
+		{ " Á", nil, "Á", "%C3%81", "" },
+		{ "á", nil, "Á", "%C3%81", "" },
+		{ "á", wiktionary, "á", "%C3%A1", "" },
+		{ " help : magic__words ", nil, "Help:Magic words", "Help:Magic_words", "" },
+		{ "Appendix:Variations of \"a\"", wiktionary, "Appendix:Variations of \"a\"", "Appendix:Variations_of_%22a%22", "" },
+		{ "appendix:variations of \"a\"", nil, "Appendix:variations of \"a\"", "Appendix:variations_of_%22a%22", "" },
+		{ "w:open front unrounded vowel", wiktionary, "w:open front unrounded vowel", "w:open_front_unrounded_vowel", "" },
+		{ "WIKIPEDIA:Foo", wikipedia, "wikipedia:Foo", "wikipedia:Foo", "" },
+		{ "wt:About", wiktionary, "Wiktionary:About", "Wiktionary:About", "" },
+		{ "user:bob", wiktionary, "User:Bob", "User:Bob", "" },
+		{ ":Category:foo", nil, "Category:Foo", "Category:Foo", "" },
+		{ "Image:a.png", nil, "File:A.png", "File:A.png", "" },
+		{ "book#Verb", nil, "Book", "Book", "Verb" },
+		{ "a b_#c_d", nil, "A b", "A_b", "c d" },
+		{ "#Etymology 2", nil, "", "", "Etymology 2" },
+		{ "a\u200eb\u00a0c", nil, "Ab c", "Ab_c", "" },
+	}
+	for i, test := range tests {
+		title, err := ParseTitle(test.s, test.opts)
+		if err != nil {
+			t.Errorf("TestParseTitle: [%d] %v", i, err)
+			continue
+		}
+		if s := title.PrefixedText(); s != test.title || title.PrefixedURL() != test.url || title.Fragment != test.fragment {
+			t.Errorf("TestParseTitle: [%d] expect %q %q %q, got %q %q %q", i, test.title, test.url, test.fragment, s, title.PrefixedURL(), title.Fragment)
+		}
+	}
+	for _, s := range []string{ "", " _ ", "Category:", "a[b", "a%20b", "a|b" } {
+		if title, err := ParseTitle(s, nil); err == nil {
+			t.Errorf("TestParseTitle: expect an error of %q, got %v", s, title)
+		}
+	}
+
+	wiki, _ := ParseString("[[ help:foo&amp;bar#x|y]]")
+	res, _ := Query(wiki, "linkinternal")
+	if title, err := res[0].Title(nil); err != nil || title.String() != "Help:Foo&bar#x" {
+		t.Errorf("TestParseTitle: %v, %v", title, err)
+	}
+	if _, err := wiki.Title(nil); err == nil {
+		t.Errorf("TestParseTitle: expect an error of the title of %v", wiki)
+	}
+}
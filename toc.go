@@ -0,0 +1,141 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// The table of contents is of the sections, numbered the same way as
+// MediaWiki's Parser::formatHeadings, by the levels of the headings
+// relative to the others, e.g. "1", "1.1", "1.1.2". It's shown if there're
+// four or more headings and no __NOTOC__, or if there's __TOC__ or
+// __FORCETOC__. It's at the first __TOC__, or before the first heading.
+
+// TOC is the table of contents of a document.
+type TOC struct {
+	Entries []*TOCEntry // the entries of the top level
+	Show bool // tells if MediaWiki shows it
+	Switch *Entity // the __TOC__ where it's shown, nil if it's before the first heading
+}
+
+// TOCEntry is an entry of the table of contents, of a section.
+type TOCEntry struct {
+	*PageSection
+	Number string // e.g. "1.1.2"
+	Level int // the level in the table, from 1
+	Anchor string // the id of the heading
+	Entries []*TOCEntry // the entries of the subsections
+}
+
+// findSwitch returns the first behavior switch of the id in the tree of e.
+func findSwitch(e *Entity, id string) *Entity {
+	if e.Type == WikiEntityBehaviorSwitch && e.Text == id {
+		return e
+	}
+	for _, child := range e.Entities {
+		if s := findSwitch(child, id); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// TableOfContents returns the table of contents of the document of wiki.
+func TableOfContents(wiki *Entity) *TOC {
+	toc := &TOC{ Switch: findSwitch(wiki, "TOC") }
+	sections := Sections(wiki)[1:]
+	switches := PageProperties(wiki).Switches
+	toc.Show = 0 < len(sections) && (switches["FORCETOC"] || toc.Switch != nil ||
+		!switches["NOTOC"] && 4 <= len(sections))
+
+	anchors := HeadingAnchors(wiki, AnchorHTML5)
+	var parents []*TOCEntry // the last entries of the levels
+	var levels, counts []int // the heading levels and the numbers of the entries of the levels
+	level, prev := 0, 0
+	for _, s := range sections {
+		switch {
+		case prev < s.Level:
+			level++
+		case s.Level < prev && 1 < level:
+			n := level
+			for level = 1; 0 < n; n-- {
+				if levels[n-1] == s.Level {
+					level = n
+					break
+				} else if levels[n-1] < s.Level {
+					level = n + 1
+					break
+				}
+			}
+		}
+		for len(levels) < level {
+			levels, counts, parents = append(levels, 0), append(counts, 0), append(parents, nil)
+		}
+		if prev < s.Level {
+			counts[level-1] = 0
+		}
+		counts[level-1]++
+		levels[level-1], prev = s.Level, s.Level
+
+		var number []string
+		for _, n := range counts[0:level] {
+			if 0 < n {
+				number = append(number, strconv.Itoa(n))
+			}
+		}
+		entry := &TOCEntry{ PageSection: s, Number: strings.Join(number, "."), Level: level, Anchor: anchors[s.Heading] }
+		if 1 < level && parents[level-2] != nil {
+			parents[level-2].Entries = append(parents[level-2].Entries, entry)
+		} else {
+			toc.Entries = append(toc.Entries, entry)
+		}
+		parents[level-1] = entry
+		for n := level; n < len(parents); n++ {
+			parents[n] = nil
+		}
+	}
+	return toc
+}
+
+func (t *TOC) writeHTML(b *bytes.Buffer, entries []*TOCEntry) {
+	b.WriteString("<ul>\n")
+	for _, entry := range entries {
+		b.WriteString(`<li class="toclevel-` + strconv.Itoa(entry.Level) + " tocsection-" + strconv.Itoa(entry.Index) + `">`)
+		b.WriteString(`<a href="#` + htmlEscape([]byte(entry.Anchor), true) + `">`)
+		b.WriteString(`<span class="tocnumber">` + entry.Number + `</span> `)
+		b.WriteString(`<span class="toctext">` + htmlEscape([]byte(entry.Title), false) + "</span></a>")
+		if 0 < len(entry.Entries) {
+			b.WriteString("\n")
+			t.writeHTML(b, entry.Entries)
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n")
+}
+
+// HTML returns the table of contents in HTML, the same as MediaWiki.
+func (t *TOC) HTML() string {
+	var b bytes.Buffer
+	b.WriteString(`<div id="toc" class="toc"><div class="toctitle"><h2>Contents</h2></div>` + "\n")
+	t.writeHTML(&b, t.Entries)
+	b.WriteString("</div>\n")
+	return b.String()
+}
+
+func (t *TOC) writeMarkdown(b *bytes.Buffer, entries []*TOCEntry, indent string) {
+	for _, entry := range entries {
+		b.WriteString(indent + "- [" + entry.Number + " " + markdownEscape(entry.Title) + "](" + markdownURL("#" + entry.Anchor) + ")\n")
+		t.writeMarkdown(b, entry.Entries, indent + "  ")
+	}
+}
+
+// Markdown returns the table of contents as a list of Markdown.
+func (t *TOC) Markdown() string {
+	var b bytes.Buffer
+	t.writeMarkdown(&b, t.Entries, "")
+	return b.String()
+}
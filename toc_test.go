@@ -0,0 +1,68 @@
+//
+//  Copyright (C) 2013, Duzy Chan <code@duzy.info>, all rights reserverd.
+//
+package wiki
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTableOfContents(t *testing.T) {
+	tests := []struct{
+		src string
+		show bool
+		entries string // the numbers, levels and anchors of the entries
+	}{
+		{ "a", false, "" },
+		{ "== a ==\n== b ==\n== c ==", false, "1:1:a 2:1:b 3:1:c" },
+		{ "== a ==\n=== b ===\n==== c ====\n== d ==\n=== e ===", true, "1:1:a 1.1:2:b 1.1.1:3:c 2:1:d 2.1:2:e" },
+		{ "==== a ====\n== b ==\n=== c ===\n== d ==", true, "1:1:a 2:1:b 2.1:2:c 3:1:d" },
+		{ "== a ==\n==== b ====\n=== c ===\n== d ==", true, "1:1:a 1.1:2:b 1.2:2:c 2:1:d" },
+		{ "== A b ==\n== a b ==\n== A b ==\n== A_b_2 ==", true, "1:1:A_b 2:1:a_b_2 3:1:A_b_3 4:1:A_b_2_2" },
+		{ "__NOTOC__\n== a ==\n== b ==\n== c ==\n== d ==", false, "1:1:a 2:1:b 3:1:c 4:1:d" },
+		{ "__NOTOC__ __TOC__\n== a ==", true, "1:1:a" },
+		{ "__FORCETOC__\n== a ==", true, "1:1:a" },
+		{ "__FORCETOC__", false, "" },
+		{ "== a ==\n<nowiki>\n== b ==\n</nowiki>", false, "1:1:a" },
+	}
+	for i, test := range tests {
+		wiki, _ := ParseString(test.src)
+		toc := TableOfContents(wiki)
+		var entries []string
+		var walk func(a []*TOCEntry)
+		walk = func(a []*TOCEntry) {
+			for _, entry := range a {
+				entries = append(entries, entry.Number + ":" + strconv.Itoa(entry.Level) + ":" + entry.Anchor)
+				walk(entry.Entries)
+			}
+		}
+		walk(toc.Entries)
+		if s := strings.Join(entries, " "); toc.Show != test.show || s != test.entries {
+			t.Errorf("TestTableOfContents: [%d] expect %v %q, got %v %q", i, test.show, test.entries, toc.Show, s)
+		}
+	}
+
+	wiki, _ := ParseString("a __TOC__\n== b & c ==\n=== d ===")
+	toc := TableOfContents(wiki)
+	if toc.Switch == nil || toc.Switch.Text != "TOC" {
+		t.Errorf("TestTableOfContents: switch %v", toc.Switch)
+	}
+	html := `<div id="toc" class="toc"><div class="toctitle"><h2>Contents</h2></div>
+<ul>
+<li class="toclevel-1 tocsection-1"><a href="#b_&amp;_c"><span class="tocnumber">1</span> <span class="toctext">b &amp; c</span></a>
+<ul>
+<li class="toclevel-2 tocsection-2"><a href="#d"><span class="tocnumber">1.1</span> <span class="toctext">d</span></a></li>
+</ul>
+</li>
+</ul>
+</div>
+`
+	if s := toc.HTML(); s != html {
+		t.Errorf("TestTableOfContents: expect %q, got %q", html, s)
+	}
+	if s := toc.Markdown(); s != "- [1 b & c](#b_&_c)\n  - [1.1 d](#d)\n" {
+		t.Errorf("TestTableOfContents: got %q", s)
+	}
+}